@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sath-run/engine/daemon"
+)
+
+type ImageStatus struct {
+	Ref      string `json:"ref"`
+	Id       string `json:"id"`
+	Size     int64  `json:"size"`
+	LastUsed int64  `json:"lastUsed"`
+	InUse    bool   `json:"inUse"`
+}
+
+func getImageStatusFromCore(images []daemon.ImageStatus) []*ImageStatus {
+	statuses := []*ImageStatus{}
+	for _, image := range images {
+		statuses = append(statuses, &ImageStatus{
+			Ref:      image.Ref,
+			Id:       image.Id,
+			Size:     image.Size,
+			LastUsed: image.LastUsed.Unix(),
+			InUse:    image.InUse,
+		})
+	}
+	return statuses
+}
+
+func GetImages(c *gin.Context) {
+	images, err := engine.Images(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"images": getImageStatusFromCore(images),
+	})
+}
+
+// PruneImages removes images exceeding the disk budget, or all unused images if "all" is set
+func PruneImages(c *gin.Context) {
+	var form struct {
+		All bool `json:"all"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	removed, err := engine.PruneImages(c.Request.Context(), form.All)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+			"images":  getImageStatusFromCore(removed),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"images": getImageStatusFromCore(removed),
+	})
+}
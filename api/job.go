@@ -1,41 +1,85 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/sath-run/engine/daemon"
 )
 
 type JobStatus struct {
-	Id          string  `json:"id"`
-	Message     string  `json:"message"`
-	Status      string  `json:"status"`
-	Progress    float64 `json:"progress"`
-	CreatedAt   int64   `json:"createdAt"`
-	CompletedAt int64   `json:"completedAt"`
-	ContainerId string  `json:"containerId"`
-	Image       string  `json:"Image"`
+	Id          string    `json:"id"`
+	Message     string    `json:"message"`
+	Status      string    `json:"status"`
+	Progress    float64   `json:"progress"`
+	CreatedAt   int64     `json:"createdAt"`
+	CompletedAt int64     `json:"completedAt"`
+	ContainerId string    `json:"containerId"`
+	Image       string    `json:"Image"`
+	Gpu         *GpuStats `json:"gpu,omitempty"`
+	Paused      bool      `json:"paused"`
 }
 
-// func getJobStatusFromCore(coreStatus *core.JobStatus) *JobStatus {
-// 	if coreStatus == nil {
-// 		return nil
-// 	}
-// 	status := core.JobStatusText(coreStatus.Status)
-// 	if coreStatus.Paused {
-// 		status = "paused"
-// 	}
-// 	return &JobStatus{
-// 		Id:          coreStatus.Id,
-// 		Message:     coreStatus.Message,
-// 		Status:      status,
-// 		Progress:    coreStatus.Progress,
-// 		CreatedAt:   coreStatus.CreatedAt.Unix(),
-// 		CompletedAt: coreStatus.CompletedAt.Unix(),
-// 		ContainerId: coreStatus.ContainerId,
-// 		Image:       coreStatus.Image,
-// 	}
-// }
+type GpuStats struct {
+	Uuid              string `json:"uuid"`
+	Utilization       uint32 `json:"utilization"`
+	PeakUtilization   uint32 `json:"peakUtilization"`
+	MemoryUtilization uint32 `json:"memoryUtilization"`
+	MemoryUsed        uint64 `json:"memoryUsed"`
+	MemoryTotal       uint64 `json:"memoryTotal"`
+	Temperature       uint32 `json:"temperature"`
+	PowerDraw         uint64 `json:"powerDraw"`
+	PowerLimit        uint64 `json:"powerLimit"`
+	SampledAt         int64  `json:"sampledAt"`
+}
+
+func getJobStatusFromCore(coreStatus daemon.JobStatus) *JobStatus {
+	status := strings.ToLower(strings.TrimPrefix(coreStatus.State.String(), "EES_"))
+	status = strings.ReplaceAll(status, "_", "-")
+	message := coreStatus.Progress.Message
+	if coreStatus.Err != nil {
+		status = "failed"
+		message = coreStatus.Err.Error()
+	}
+	var completedAt int64
+	if !coreStatus.CompletedAt.IsZero() {
+		completedAt = coreStatus.CompletedAt.Unix()
+	}
+	var gpu *GpuStats
+	if stats := coreStatus.GpuStats; stats != nil {
+		gpu = &GpuStats{
+			Uuid:              stats.Uuid,
+			Utilization:       stats.Utilization,
+			PeakUtilization:   coreStatus.GpuPeakUtilization,
+			MemoryUtilization: stats.MemoryUtilization,
+			MemoryUsed:        stats.MemoryUsed,
+			MemoryTotal:       stats.MemoryTotal,
+			Temperature:       stats.Temperature,
+			PowerDraw:         stats.PowerDraw,
+			PowerLimit:        stats.PowerLimit,
+			SampledAt:         stats.Timestamp / 1000,
+		}
+	}
+	return &JobStatus{
+		Id:          coreStatus.Id,
+		Message:     message,
+		Status:      status,
+		Progress:    coreStatus.Progress.Percent(),
+		CreatedAt:   coreStatus.CreatedAt.Unix(),
+		CompletedAt: completedAt,
+		ContainerId: coreStatus.ContainerId,
+		Image:       coreStatus.Image,
+		Gpu:         gpu,
+		Paused:      coreStatus.Paused,
+	}
+}
 
 // func readJobStatusFromLog() ([]*JobStatus, error) {
 // logPath := filepath.Join(utils.SathHome, "log", "jobs.log")
@@ -89,13 +133,10 @@ type JobStatus struct {
 // }
 
 func GetJobStatus(c *gin.Context) {
-	// status := core.GetJobStatus()
-
-	// jobs := []*JobStatus{}
-
-	// if status != nil {
-	// 	jobs = append(jobs, getJobStatusFromCore(status))
-	// }
+	jobs := []*JobStatus{}
+	for _, status := range engine.Jobs() {
+		jobs = append(jobs, getJobStatusFromCore(status))
+	}
 
 	// if c.Query(                "filter") == "all" {
 	// 	completed, err := readJobStatusFromLog()
@@ -106,20 +147,154 @@ func GetJobStatus(c *gin.Context) {
 	// }
 
 	c.JSON(http.StatusOK, gin.H{
-		// "jobs": jobs,
+		"jobs": jobs,
 	})
 }
 
-func PauseJob(c *gin.Context) {
-	// success := core.Pause("")
+// GetJobBenchmark returns timings of recently succeeded jobs in milliseconds
+func GetJobBenchmark(c *gin.Context) {
+	benchmark := engine.JobBenchmark()
+	stages := []gin.H{}
+	for _, stage := range benchmark.Stages {
+		stages = append(stages, gin.H{
+			"stage": strings.ToLower(strings.TrimPrefix(stage.State.String(), "EES_")),
+			"mean":  stage.Mean.Milliseconds(),
+		})
+	}
 	c.JSON(http.StatusOK, gin.H{
-		// "success": success,
+		"jobs":        benchmark.Jobs,
+		"total":       benchmark.Total.Milliseconds(),
+		"overhead":    benchmark.Overhead.Milliseconds(),
+		"overheadP50": benchmark.OverheadP50.Milliseconds(),
+		"overheadP95": benchmark.OverheadP95.Milliseconds(),
+		"stages":      stages,
 	})
 }
 
+func PauseJob(c *gin.Context) {
+	updateJobs(c, engine.PauseJobs)
+}
+
 func ResumeJob(c *gin.Context) {
-	// success := core.Resume("")
+	updateJobs(c, engine.ResumeJobs)
+}
+
+// updateJobs pauses or resumes jobs by ids in request, or all jobs if no id is given
+func updateJobs(c *gin.Context, update func(ids []string) ([]string, error)) {
+	var form struct {
+		Ids []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ids, err := update(form.Ids)
+	if errors.Is(err, daemon.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": err.Error(),
+		})
+		return
+	} else if errors.Is(err, daemon.ErrAmbiguousJobId) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+			"jobs":    ids,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		// "success": success,
+		"jobs": ids,
+	})
+}
+
+// parseSince accepts RFC3339 time, unix timestamp or a duration relative to now, e.g. "10m"
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, errors.New("invalid since: " + since)
+}
+
+func GetJobLogs(c *gin.Context) {
+	var form struct {
+		Follow bool   `form:"follow"`
+		Tail   string `form:"tail"`
+		Since  string `form:"since"`
+		Stdout bool   `form:"stdout"`
+		Stderr bool   `form:"stderr"`
+	}
+	if err := c.ShouldBindQuery(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	options := daemon.LogOptions{
+		Follow: form.Follow,
+		Tail:   -1,
+	}
+	if form.Tail != "" && form.Tail != "all" {
+		tail, err := strconv.Atoi(form.Tail)
+		if err != nil || tail < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid tail: " + form.Tail,
+			})
+			return
+		}
+		options.Tail = tail
+	}
+	since, err := parseSince(form.Since, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	options.Since = since
+	// show both streams if none is specified
+	if form.Stdout || !form.Stderr {
+		options.Streams = append(options.Streams, daemon.LogStreamStdout)
+	}
+	if form.Stderr || !form.Stdout {
+		options.Streams = append(options.Streams, daemon.LogStreamStderr)
+	}
+
+	if !engine.HasJobLogs(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "no such job: " + c.Param("id"),
+		})
+		return
+	}
+
+	// send header immediately, so that client is not blocked in follow mode
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	encoder := json.NewEncoder(c.Writer)
+	err = engine.JobLogs(c.Request.Context(), c.Param("id"), &options, func(entry daemon.LogEntry) error {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
 	})
+	if err != nil {
+		// response has been partially written, nothing more can be reported to client
+		log.Debug().Err(err).Msg("error streaming job logs")
+	}
 }
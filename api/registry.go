@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sath-run/engine/constants"
+	"github.com/sath-run/engine/meta"
+)
+
+type RegistryStatus struct {
+	Registry string `json:"registry"`
+	Username string `json:"username"`
+}
+
+// GetRegistries lists registries whose credentials are stored, without their secrets
+func GetRegistries(c *gin.Context) {
+	credentials, err := engine.Registries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	registries := []*RegistryStatus{}
+	for _, credential := range credentials {
+		registries = append(registries, &RegistryStatus{
+			Registry: credential.Registry,
+			Username: credential.Username,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"registries": registries,
+	})
+}
+
+func RegistryLogin(c *gin.Context) {
+	var form struct {
+		Registry string `json:"registry" binding:"required"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	err := engine.RegistryLogin(c.Request.Context(), meta.RegistryCredential{
+		Registry: form.Registry,
+		Username: form.Username,
+		Password: form.Password,
+	})
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func RegistryLogout(c *gin.Context) {
+	var form struct {
+		Registry string `json:"registry" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := engine.RegistryLogout(form.Registry); errors.Is(err, constants.ErrNil) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "not logged in to " + form.Registry,
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -41,8 +41,15 @@ func Init(file string, egin *daemon.Core) {
 	r.GET("/services/status", GetServiceStatus)
 	// r.GET("/jobs/stream", StreamJobStatus)
 	r.GET("/jobs", GetJobStatus)
+	r.GET("/jobs/benchmark", GetJobBenchmark)
+	r.GET("/jobs/:id/logs", GetJobLogs)
 	r.POST("/jobs/pause", PauseJob)
 	r.POST("/jobs/resume", ResumeJob)
+	r.GET("/images", GetImages)
+	r.POST("/images/prune", PruneImages)
+	r.GET("/registries", GetRegistries)
+	r.POST("/registries/login", RegistryLogin)
+	r.POST("/registries/logout", RegistryLogout)
 	r.POST("/users/login", Login)
 	r.POST("/users/logout", Logout)
 	r.GET("/users/info", GetUserInfo)
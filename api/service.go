@@ -56,10 +56,37 @@ func GetServiceStatus(c *gin.Context) {
 	// 		"execId": status.Id,
 	// 	})
 	// }
+	availability := []gin.H{}
+	for _, v := range engine.Availability() {
+		availability = append(availability, gin.H{
+			"rule":    v.Rule,
+			"allow":   v.Allow,
+			"suspend": v.Suspend,
+			"reason":  v.Reason,
+		})
+	}
+	prefetch := engine.Prefetch()
+	prefetchItems := []gin.H{}
+	for _, item := range prefetch.Items {
+		prefetchItems = append(prefetchItems, gin.H{
+			"kind":     item.Kind,
+			"name":     item.Name,
+			"state":    item.State,
+			"progress": item.Progress.Percent(),
+			"current":  item.Progress.Current,
+			"total":    item.Progress.Total,
+			"reason":   item.Reason,
+		})
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"status":  engine.Status(),
-		"version": constants.Version,
-		"jobs":    jobs,
+		"status":       engine.Status(),
+		"version":      constants.Version,
+		"jobs":         jobs,
+		"availability": availability,
+		"prefetch": gin.H{
+			"active": prefetch.Active,
+			"items":  prefetchItems,
+		},
 	})
 }
 
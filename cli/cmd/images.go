@@ -0,0 +1,120 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sath-run/engine/cli/request"
+	"github.com/spf13/cobra"
+)
+
+// imagesCmd represents the images command
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "List images",
+	Long: `List docker images pulled by SATH engine for jobs.
+Images in use by containers or current jobs are never removed`,
+	Run: runImages,
+}
+
+// imagesPruneCmd represents the images prune command
+var imagesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unused images",
+	Long: `Remove least recently used images until the rest fit in the disk budget of engine.
+With --all, every image pulled by engine which is not in use is removed`,
+	Run: runImagesPrune,
+}
+
+type ImagesResult struct {
+	Images []struct {
+		Ref      string `json:"ref"`
+		Id       string `json:"id"`
+		Size     int64  `json:"size"`
+		LastUsed int64  `json:"lastUsed"`
+		InUse    bool   `json:"inUse"`
+	} `json:"images"`
+}
+
+func fmtSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2fMB", float64(size)/(1<<20))
+	default:
+		return fmt.Sprintf("%.2fKB", float64(size)/(1<<10))
+	}
+}
+
+func runImages(cmd *cobra.Command, args []string) {
+	response := request.EngineGet("/images")
+	var result ImagesResult
+	if err := mapstructure.Decode(&response, &result); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%-40s %-14s %-10s %-16s %-6s\n", "IMAGE", "IMAGE ID", "SIZE", "LAST USED", "IN USE")
+	var total int64
+	for _, image := range result.Images {
+		ref := image.Ref
+		if len(ref) > 40 {
+			ref = ref[:37] + "..."
+		}
+		id := strings.TrimPrefix(image.Id, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		inUse := ""
+		if image.InUse {
+			inUse = "yes"
+		}
+		fmt.Printf("%-40s %-14s %-10s %-16s %-6s\n",
+			ref, id, fmtSize(image.Size),
+			fmtDuration(time.Since(time.Unix(image.LastUsed, 0)))+" ago",
+			inUse,
+		)
+		total += image.Size
+	}
+	fmt.Printf("\n%d images, %s in total\n", len(result.Images), fmtSize(total))
+}
+
+func runImagesPrune(cmd *cobra.Command, args []string) {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, code := request.SendRequestToEngine(http.MethodPost, "/images/prune", map[string]interface{}{
+		"all": all,
+	})
+	var result ImagesResult
+	if err := mapstructure.Decode(&res, &result); err != nil {
+		log.Fatal(err)
+	}
+	var reclaimed int64
+	for _, image := range result.Images {
+		fmt.Printf("removed %s\n", image.Ref)
+		reclaimed += image.Size
+	}
+	if code != http.StatusOK {
+		log.Fatal(res["message"])
+	}
+	if len(result.Images) == 0 {
+		fmt.Println("no image is removed")
+	} else {
+		fmt.Printf("up to %s reclaimed\n", fmtSize(reclaimed))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(imagesCmd)
+	imagesCmd.AddCommand(imagesPruneCmd)
+
+	imagesPruneCmd.Flags().BoolP("all", "a", false, "Remove all images which are not in use")
+}
@@ -37,6 +37,12 @@ type JobStatusResult struct {
 		CompletedAt int64   `json:"completedAt"`
 		ContainerId string  `json:"containerId"`
 		Image       string  `json:"image"`
+		Gpu         *struct {
+			Utilization uint32 `json:"utilization"`
+			MemoryUsed  uint64 `json:"memoryUsed"`
+			MemoryTotal uint64 `json:"memoryTotal"`
+		} `json:"gpu"`
+		Paused bool `json:"paused"`
 	} `json:"jobs"`
 }
 
@@ -74,6 +80,19 @@ func runJobs(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	benchmark, err := cmd.Flags().GetBool("benchmark")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if benchmark {
+		response := request.EngineGet("/jobs/benchmark")
+		var result JobBenchmarkResult
+		if err := mapstructure.Decode(&response, &result); err != nil {
+			log.Fatal(err)
+		}
+		printJobBenchmark(result)
+		return
+	}
 	if follow {
 		if all {
 			fmt.Println("[WARNING] `--all` does not apply to `--follow` mode")
@@ -111,38 +130,74 @@ func runJobs(cmd *cobra.Command, args []string) {
 }
 
 func printJobs(result JobStatusResult) {
-	fmt.Printf("%-10s %-14s %-10s %-30s %-16s %-16s %-16s\n",
-		"JOB ID", "STATUS", "PROGRESS", "IMAGE", "CONTAINER ID", "CREATED", "COMPLETED")
+	fmt.Printf("%-10s %-14s %-10s %-30s %-16s %-16s %-16s %-16s\n",
+		"JOB ID", "STATUS", "PROGRESS", "IMAGE", "CONTAINER ID", "GPU", "CREATED", "COMPLETED")
 	for _, job := range result.Jobs {
 		jobId := job.Id
 		if len(jobId) > 10 {
 			jobId = jobId[2:10]
 		}
 		createdAt := time.Unix(job.CreatedAt, 0)
-		completedAt := time.Unix(job.CompletedAt, 0)
 		image := strings.Split(job.Image, "@")[0]
 		if len(image) > 28 {
 			image = image[:25] + "..."
 		}
 		created := fmtDuration(time.Since(createdAt)) + " ago"
 		completed := ""
-		if !completedAt.IsZero() {
-			completed = fmtDuration(time.Since(completedAt)) + " ago"
+		if job.CompletedAt != 0 {
+			completed = fmtDuration(time.Since(time.Unix(job.CompletedAt, 0))) + " ago"
 		}
 		containerId := job.ContainerId
 		if len(containerId) > 12 {
 			containerId = containerId[:12]
 		}
-		fmt.Printf("%-10s %-14s %-10s %-30s %-16s %-16s %-16s\n",
-			jobId, job.Status,
+		gpu := ""
+		if job.Gpu != nil {
+			gpu = fmt.Sprintf("%d%% %.1f/%.1fG", job.Gpu.Utilization,
+				float64(job.Gpu.MemoryUsed)/(1<<30), float64(job.Gpu.MemoryTotal)/(1<<30))
+		}
+		status := job.Status
+		if job.Paused {
+			status = "paused"
+		}
+		fmt.Printf("%-10s %-14s %-10s %-30s %-16s %-16s %-16s %-16s\n",
+			jobId, status,
 			fmt.Sprintf("%.2f%%", job.Progress),
-			image, containerId,
+			image, containerId, gpu,
 			created,
 			completed,
 		)
 	}
 }
 
+type JobBenchmarkResult struct {
+	Jobs        int   `json:"jobs"`
+	Total       int64 `json:"total"`
+	Overhead    int64 `json:"overhead"`
+	OverheadP50 int64 `json:"overheadP50"`
+	OverheadP95 int64 `json:"overheadP95"`
+	Stages      []struct {
+		Stage string `json:"stage"`
+		Mean  int64  `json:"mean"`
+	} `json:"stages"`
+}
+
+func printJobBenchmark(result JobBenchmarkResult) {
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	fmt.Printf("Jobs:          %d\n", result.Jobs)
+	if result.Jobs == 0 {
+		return
+	}
+	fmt.Printf("Mean total:    %s\n", ms(result.Total))
+	fmt.Printf("Mean overhead: %s (p50 %s, p95 %s)\n",
+		ms(result.Overhead), ms(result.OverheadP50), ms(result.OverheadP95))
+	fmt.Println()
+	fmt.Printf("%-24s %-16s\n", "STAGE", "MEAN")
+	for _, stage := range result.Stages {
+		fmt.Printf("%-24s %-16s\n", stage.Stage, ms(stage.Mean))
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(jobsCmd)
 
@@ -157,4 +212,5 @@ func init() {
 	// jobsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	jobsCmd.Flags().BoolP("follow", "f", false, "This option cause sath to stream status")
 	jobsCmd.Flags().BoolP("all", "a", false, "Show all the jobs including finished ones")
+	jobsCmd.Flags().BoolP("benchmark", "b", false, "Show timings and overhead of engine of recently succeeded jobs")
 }
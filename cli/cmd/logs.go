@@ -0,0 +1,118 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/sath-run/engine/cli/request"
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs [OPTIONS] JOB",
+	Short: "Fetch the logs of a job",
+	Long: `Fetch the logs of a job.
+Logs of finished jobs are available until they expire`,
+	Args: cobra.ExactArgs(1),
+	Run:  runLogs,
+}
+
+type LogEntry struct {
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+	Line   string    `json:"line"`
+}
+
+func runLogs(cmd *cobra.Command, args []string) {
+	follow, err := cmd.Flags().GetBool("follow")
+	if err != nil {
+		log.Fatal(err)
+	}
+	tail, err := cmd.Flags().GetString("tail")
+	if err != nil {
+		log.Fatal(err)
+	}
+	since, err := cmd.Flags().GetString("since")
+	if err != nil {
+		log.Fatal(err)
+	}
+	stream, err := cmd.Flags().GetString("stream")
+	if err != nil {
+		log.Fatal(err)
+	}
+	timestamps, err := cmd.Flags().GetBool("timestamps")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	query := url.Values{}
+	if follow {
+		query.Set("follow", "true")
+	}
+	query.Set("tail", tail)
+	if len(since) > 0 {
+		query.Set("since", since)
+	}
+	switch stream {
+	case "", "all":
+	case "stdout", "stderr":
+		query.Set(stream, "true")
+	default:
+		log.Fatalf("invalid stream %s, it should be one of stdout, stderr or all", stream)
+	}
+
+	resp := request.EngineStream(fmt.Sprintf("/jobs/%s/logs?%s", url.PathEscape(args[0]), query.Encode()))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		data, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &result); err == nil {
+			fmt.Println(result["message"])
+		} else {
+			fmt.Println(string(data))
+		}
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Fatal(err)
+		}
+		out := os.Stdout
+		if entry.Stream == "stderr" {
+			out = os.Stderr
+		}
+		if timestamps {
+			fmt.Fprintf(out, "%s %s\n", entry.Time.Format(time.RFC3339Nano), entry.Line)
+		} else {
+			fmt.Fprintln(out, entry.Line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().StringP("tail", "n", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().String("since", "", "Show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m for 42 minutes)")
+	logsCmd.Flags().String("stream", "all", "Only show the given stream (stdout, stderr or all)")
+	logsCmd.Flags().BoolP("timestamps", "t", false, "Show timestamps")
+}
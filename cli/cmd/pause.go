@@ -5,6 +5,8 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"net/http"
 
 	"github.com/sath-run/engine/cli/request"
 	"github.com/spf13/cobra"
@@ -12,15 +14,41 @@ import (
 
 // pauseCmd represents the pause command
 var pauseCmd = &cobra.Command{
-	Use:   "pause",
-	Short: "Pause all running jobs",
-	Long:  `Pause all running jobs and stop receiving new jobs any more`,
+	Use:   "pause [JOB...]",
+	Short: "Pause running jobs",
+	Long: `Pause jobs without losing their progress, use ` + "`sath resume`" + ` to continue them.
+Tasks of paused jobs are frozen, and their downloads and uploads are stopped.
+If no job is specified, all jobs are paused and no new job is received any more`,
 	Run: func(cmd *cobra.Command, args []string) {
-		resp := request.EnginePost("/jobs/pause", nil)
-		fmt.Println(resp)
+		updateJobs("/jobs/pause", "paused", args)
 	},
 }
 
+// updateJobs pauses or resumes jobs by ids, or all jobs if ids is empty
+func updateJobs(path string, action string, ids []string) {
+	data := map[string]interface{}{}
+	if len(ids) > 0 {
+		data["ids"] = ids
+	}
+	res, code := request.SendRequestToEngine(http.MethodPost, path, data)
+	if code != http.StatusOK {
+		if res != nil && res["message"] != nil {
+			fmt.Println(res["message"])
+		}
+		if code >= http.StatusInternalServerError || code < 200 {
+			log.Fatal(res, code)
+		}
+		return
+	}
+	jobs, _ := res["jobs"].([]interface{})
+	if len(ids) == 0 {
+		fmt.Printf("sath-engine is %s\n", action)
+	}
+	for _, id := range jobs {
+		fmt.Printf("job %s %s\n", id, action)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(pauseCmd)
 
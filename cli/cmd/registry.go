@@ -0,0 +1,122 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sath-run/engine/cli/request"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// registryCmd represents the registry command
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "List registries logged in",
+	Long: `List docker registries whose credentials are stored by SATH engine.
+The credentials are used to pull images from the registries, e.g. local mirrors,
+unless credentials are provided along with jobs`,
+	Run: runRegistry,
+}
+
+// registryLoginCmd represents the registry login command
+var registryLoginCmd = &cobra.Command{
+	Use:   "login REGISTRY",
+	Short: "Login a docker registry",
+	Long:  `Verify and store the credential of a docker registry, e.g. sath registry login mirror.lab:5000`,
+	Args:  cobra.ExactArgs(1),
+	Run:   runRegistryLogin,
+}
+
+// registryLogoutCmd represents the registry logout command
+var registryLogoutCmd = &cobra.Command{
+	Use:   "logout REGISTRY",
+	Short: "Logout a docker registry",
+	Long:  `Remove the stored credential of a docker registry`,
+	Args:  cobra.ExactArgs(1),
+	Run:   runRegistryLogout,
+}
+
+func runRegistry(cmd *cobra.Command, args []string) {
+	response := request.EngineGet("/registries")
+	var result struct {
+		Registries []struct {
+			Registry string `json:"registry"`
+			Username string `json:"username"`
+		} `json:"registries"`
+	}
+	if err := mapstructure.Decode(&response, &result); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%-40s %-20s\n", "REGISTRY", "USERNAME")
+	for _, registry := range result.Registries {
+		fmt.Printf("%-40s %-20s\n", registry.Registry, registry.Username)
+	}
+}
+
+func runRegistryLogin(cmd *cobra.Command, args []string) {
+	request.TryPing()
+	reader := bufio.NewReader(os.Stdin)
+	username, err := cmd.Flags().GetString("username")
+	if err != nil {
+		log.Fatal(err)
+	}
+	password, err := cmd.Flags().GetString("password")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for len(username) == 0 {
+		fmt.Print("Enter Username: ")
+		username, err = reader.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+		username = strings.Trim(username, "\n")
+	}
+	for len(password) == 0 {
+		fmt.Print("Enter Password: ")
+		bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			log.Fatal(err)
+		}
+		password = string(bytePassword)
+		fmt.Println("")
+	}
+	res, status := request.SendRequestToEngine(http.MethodPost, "/registries/login", map[string]interface{}{
+		"registry": args[0],
+		"username": username,
+		"password": password,
+	})
+	if status != http.StatusOK {
+		log.Fatalf("login failed: %v", res["message"])
+	}
+	fmt.Printf("logged in to %s\n", args[0])
+}
+
+func runRegistryLogout(cmd *cobra.Command, args []string) {
+	res, status := request.SendRequestToEngine(http.MethodPost, "/registries/logout", map[string]interface{}{
+		"registry": args[0],
+	})
+	if status != http.StatusOK {
+		log.Fatal(res["message"])
+	}
+	fmt.Printf("logged out of %s\n", args[0])
+}
+
+func init() {
+	rootCmd.AddCommand(registryCmd)
+	registryCmd.AddCommand(registryLoginCmd)
+	registryCmd.AddCommand(registryLogoutCmd)
+
+	registryLoginCmd.Flags().StringP("username", "u", "", "Username")
+	registryLoginCmd.Flags().StringP("password", "p", "", "Password")
+}
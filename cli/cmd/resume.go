@@ -0,0 +1,23 @@
+/*
+Copyright © 2026 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// resumeCmd represents the resume command
+var resumeCmd = &cobra.Command{
+	Use:   "resume [JOB...]",
+	Short: "Resume paused jobs",
+	Long: `Resume jobs paused by ` + "`sath pause`" + `.
+If no job is specified, all jobs are resumed and new jobs are received again`,
+	Run: func(cmd *cobra.Command, args []string) {
+		updateJobs("/jobs/resume", "resumed", args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resumeCmd)
+}
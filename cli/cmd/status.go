@@ -45,6 +45,63 @@ func printStatusResult(result map[string]interface{}) {
 	}
 }
 
+func printAvailability(result map[string]interface{}) {
+	rules, ok := result["availability"].([]interface{})
+	if !ok || len(rules) == 0 {
+		return
+	}
+	fmt.Println("Availability policies:")
+	available, suspended := true, false
+	for _, r := range rules {
+		rule, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		verdict := "allow"
+		if allow, _ := rule["allow"].(bool); !allow {
+			available = false
+			verdict = "deny"
+			if suspend, _ := rule["suspend"].(bool); suspend {
+				suspended = true
+				verdict = "suspend"
+			}
+		}
+		fmt.Printf("  %-12s %-8s %s\n", rule["rule"], verdict, rule["reason"])
+	}
+	if suspended {
+		fmt.Println("  running jobs are suspended until all policies allow")
+	} else if !available {
+		fmt.Println("  no new job is accepted until all policies allow")
+	}
+}
+
+func printPrefetch(result map[string]interface{}) {
+	prefetch, _ := result["prefetch"].(map[string]interface{})
+	items, ok := prefetch["items"].([]interface{})
+	if !ok || len(items) == 0 {
+		return
+	}
+	if active, _ := prefetch["active"].(bool); active {
+		fmt.Println("Prefetching while idle:")
+	} else {
+		fmt.Println("Prefetched while idle:")
+	}
+	for _, i := range items {
+		item, ok := i.(map[string]any)
+		if !ok {
+			continue
+		}
+		state, _ := item["state"].(string)
+		if progress, _ := item["progress"].(float64); state == "fetching" && progress > 0 {
+			state = fmt.Sprintf("%s %.1f%%", state, progress)
+		}
+		if reason, _ := item["reason"].(string); reason != "" {
+			state += ": " + reason
+		}
+		fmt.Printf("  %-8s %-48s %s\n", item["kind"], item["name"], state)
+	}
+}
+
 func runStatus(cmd *cobra.Command, args []string) {
 	fmt.Println("sath version:", constants.Version)
 	user := request.EngineGet("/users/info")
@@ -61,6 +118,8 @@ func runStatus(cmd *cobra.Command, args []string) {
 	}
 	status := request.EngineGet("/services/status")
 	printStatusResult(status)
+	printAvailability(status)
+	printPrefetch(status)
 }
 
 func init() {
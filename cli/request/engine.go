@@ -48,6 +48,16 @@ func PingSathEngine() bool {
 	return false
 }
 
+func newEngineClient() http.Client {
+	return http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+}
+
 func sendRequestToEngine(method string, path string, data map[string]interface{}) (map[string]interface{}, int, error) {
 	url := Origin + path
 	buffer := new(bytes.Buffer)
@@ -57,13 +67,7 @@ func sendRequestToEngine(method string, path string, data map[string]interface{}
 		return nil, 0, err
 	}
 
-	client := http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", socket)
-			},
-		},
-	}
+	client := newEngineClient()
 
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := client.Do(req)
@@ -89,8 +93,7 @@ func sendRequestToEngine(method string, path string, data map[string]interface{}
 	return result, resp.StatusCode, nil
 }
 
-func SendRequestToEngine(method string, path string, data map[string]interface{}) (map[string]interface{}, int) {
-	res, code, err := sendRequestToEngine(method, path, data)
+func checkEngineConnection(err error) {
 	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist) {
 		if pid, _ := FindRunningDaemonPid(); pid == 0 {
 			fmt.Println("sath-engine is not started")
@@ -105,9 +108,23 @@ func SendRequestToEngine(method string, path string, data map[string]interface{}
 	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+func SendRequestToEngine(method string, path string, data map[string]interface{}) (map[string]interface{}, int) {
+	res, code, err := sendRequestToEngine(method, path, data)
+	checkEngineConnection(err)
 	return res, code
 }
 
+// EngineStream sends a GET request to engine and returns the response
+// with its body unread, so that it can be consumed as a stream
+func EngineStream(path string) *http.Response {
+	client := newEngineClient()
+	resp, err := client.Get(Origin + path)
+	checkEngineConnection(err)
+	return resp
+}
+
 func EngineGet(path string) map[string]interface{} {
 	res, code := SendRequestToEngine(http.MethodGet, path, nil)
 	if code < 200 || code >= 400 {
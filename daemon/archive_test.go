@@ -0,0 +1,74 @@
+package daemon_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestArchiveDir(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"step.txt":          "1200",
+		"state/weights.bin": "\x00\x01\x02",
+		"state/empty":       "",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		checkErr(os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		checkErr(os.WriteFile(path, []byte(content), 0600))
+	}
+	checkErr(os.Symlink("state/weights.bin", filepath.Join(src, "latest")))
+
+	archive := filepath.Join(t.TempDir(), "checkpoint.tar.gz")
+	checkErr(daemon.ArchiveDir(src, archive))
+	dst := t.TempDir()
+	checkErr(daemon.ExtractArchive(archive, dst))
+
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("expect %s to be %q, got %q", name, content, data)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dst, "state", "weights.bin")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expect mode to be kept, got %v, err %v", info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "latest")); err != nil || link != "state/weights.bin" {
+		t.Errorf("expect symlink to be kept, got %q, err %v", link, err)
+	}
+}
+
+func TestExtractArchiveTraversal(t *testing.T) {
+	for _, name := range []string{"../escape.txt", "state/../../escape.txt", "parent/escape.txt"} {
+		archive := filepath.Join(t.TempDir(), "checkpoint.tar.gz")
+		f, err := os.Create(archive)
+		checkErr(err)
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		// a link to parent is allowed by itself, but nothing is extracted through it
+		checkErr(tw.WriteHeader(&tar.Header{Name: "parent", Linkname: "..", Typeflag: tar.TypeSymlink}))
+		checkErr(tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: 2, Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte("hi"))
+		checkErr(err)
+		checkErr(tw.Close())
+		checkErr(gz.Close())
+		checkErr(f.Close())
+
+		dir := filepath.Join(t.TempDir(), "checkpoint")
+		checkErr(os.Mkdir(dir, os.ModePerm))
+		if err := daemon.ExtractArchive(archive, dir); err == nil {
+			t.Errorf("expect error extracting %s", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "..", "escape.txt")); err == nil {
+			t.Errorf("expect %s not to be extracted outside of dir", name)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// assigner keeps the stream of job assignment connected, over which the engine advertises its capacity
+// and the server pushes jobs. Jobs are polled instead while the stream is not connected.
+type assigner struct {
+	scheduler *Scheduler
+	// whether the stream is connected, polling is suspended meanwhile
+	connected atomic.Bool
+	// signaled when capacity changes
+	changed chan struct{}
+	logger  zerolog.Logger
+
+	mu     sync.Mutex
+	stream pb.Engine_AssignJobsClient
+	// sends on stream one at a time, mu is not held meanwhile so that a slow send never blocks advertise,
+	// which is called in loop
+	sendMu sync.Mutex
+	// capacity advertised by scheduler, and the one last sent on stream
+	capacity *pb.EngineCapacity
+	sent     *pb.EngineCapacity
+}
+
+func newAssigner(scheduler *Scheduler) *assigner {
+	return &assigner{
+		scheduler: scheduler,
+		changed:   make(chan struct{}, 1),
+		capacity:  &pb.EngineCapacity{},
+		logger:    log.With().Str("component", "assigner").Logger(),
+	}
+}
+
+// wait waits for d, it returns false if the scheduler is closed meanwhile
+func (a *assigner) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-a.scheduler.closeChan:
+		return false
+	}
+}
+
+// run connects the stream as long as the scheduler lives, and reconnects once it breaks
+func (a *assigner) run() {
+	backoff := PollBackoff{Base: 10 * time.Second, Max: 10 * time.Minute}
+	for {
+		user := a.scheduler.c.user
+		if user == nil {
+			if !a.wait(30 * time.Second) {
+				return
+			}
+			continue
+		}
+		connected, err := a.serve(user)
+		if connected {
+			backoff = PollBackoff{Base: backoff.Base, Max: backoff.Max}
+		}
+		d := backoff.Next(PollResult{Err: err})
+		if status.Code(err) == codes.Unimplemented {
+			// the server may be upgraded later
+			a.logger.Debug().Msg("server does not assign jobs by stream, jobs are polled")
+			d = 30 * time.Minute
+		} else {
+			a.logger.Info().Err(err).Dur("retry", d).Msg("stream of job assignment disconnected, jobs are polled")
+		}
+		if !a.wait(d) {
+			return
+		}
+	}
+}
+
+// serve receives jobs from the stream until it breaks, it returns whether it was ever connected
+func (a *assigner) serve(user *User) (bool, error) {
+	ctx, cancel := context.WithCancel(a.scheduler.c.AppendToOutgoingContext(context.Background(), user))
+	defer cancel()
+	stream, err := a.scheduler.c.AssignJobs(ctx)
+	if err != nil {
+		return false, err
+	}
+	// servers send headers once the stream is accepted, otherwise it ends with the status of server
+	if md, err := stream.Header(); err != nil {
+		return false, err
+	} else if md == nil {
+		_, err := stream.Recv()
+		return false, err
+	}
+	a.mu.Lock()
+	a.stream, a.sent = stream, nil
+	a.mu.Unlock()
+	a.connected.Store(true)
+	a.logger.Info().Msg("jobs are assigned by stream")
+	defer func() {
+		a.connected.Store(false)
+		a.mu.Lock()
+		a.stream = nil
+		a.mu.Unlock()
+	}()
+
+	go a.sendCapacity(ctx)
+	for {
+		assignment, err := stream.Recv()
+		if err != nil {
+			return true, err
+		}
+		if len(BatchJobs(assignment.Job)) == 0 {
+			continue
+		}
+		select {
+		case a.scheduler.assignChan <- assignment.Job:
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
+
+// send sends req on the current stream, it is dropped if the stream is not connected
+func (a *assigner) send(req *pb.AssignmentRequest) error {
+	a.mu.Lock()
+	stream := a.stream
+	a.mu.Unlock()
+	if stream == nil {
+		return nil
+	}
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+	return stream.Send(req)
+}
+
+// sendCapacity sends capacity whenever it changes, and periodically to keep the stream alive
+func (a *assigner) sendCapacity(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		a.mu.Lock()
+		capacity, sent := a.capacity, a.sent
+		a.mu.Unlock()
+		if !proto.Equal(capacity, sent) {
+			if err := a.send(&pb.AssignmentRequest{
+				Message: &pb.AssignmentRequest_Capacity{Capacity: capacity},
+			}); err != nil {
+				a.logger.Debug().Err(err).Msg("fail to send capacity")
+			} else {
+				a.mu.Lock()
+				a.sent = capacity
+				a.mu.Unlock()
+			}
+		}
+		select {
+		case <-a.changed:
+		case <-ticker.C:
+			a.mu.Lock()
+			a.sent = nil
+			a.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// advertise updates capacity of engine, it is sent on stream if changed
+func (a *assigner) advertise(capacity *pb.EngineCapacity) {
+	a.mu.Lock()
+	changed := !proto.Equal(a.capacity, capacity)
+	if changed {
+		a.capacity = capacity
+	}
+	a.mu.Unlock()
+	if changed {
+		select {
+		case a.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// answer tells server whether a job assigned is accepted
+func (a *assigner) answer(jobId string, accepted bool, reason string) {
+	err := a.send(&pb.AssignmentRequest{
+		Message: &pb.AssignmentRequest_Answer{Answer: &pb.AssignmentAnswer{
+			JobId:    jobId,
+			Accepted: accepted,
+			Reason:   reason,
+		}},
+	})
+	if err != nil {
+		a.logger.Warn().Err(err).Str("job", jobId).Msg("fail to answer job assignment")
+	}
+}
+
+// capacity returns how many jobs the engine accepts now, and the number of those which would
+// wait for containers to free up. It is only called in loop.
+func (scheduler *Scheduler) capacity() (*pb.EngineCapacity, int) {
+	slots, running := scheduler.jobSlots()
+	free := max(slots-running, 0)
+	ahead := len(scheduler.ahead) + int(scheduler.fetched.Load())
+	busyGpus := 0
+	for _, c := range scheduler.containers {
+		if c.currentJob != nil && c.gpu != nil {
+			busyGpus++
+		}
+	}
+	capacity := &pb.EngineCapacity{
+		Jobs:      uint32(max(free+scheduler.config.Lookahead-ahead, 0)),
+		FreeSlots: uint32(max(free-ahead, 0)),
+		FreeGpus:  uint32(max(scheduler.gpus.count()-busyGpus, 0)),
+	}
+	switch {
+	case scheduler.status != StatusRunning:
+		capacity.Jobs, capacity.Reason = 0, "engine is paused"
+	case !scheduler.available:
+		capacity.Jobs, capacity.Reason = 0, "host is not available by policy"
+	case capacity.Jobs == 0:
+		capacity.Reason = ErrNoCapacity.Error()
+	}
+	return capacity, max(free-ahead, 0)
+}
+
+// advertise sends capacity to server if jobs are assigned by stream, it is called in loop whenever something happens
+func (scheduler *Scheduler) advertise() {
+	if scheduler.assigner == nil {
+		return
+	}
+	capacity, _ := scheduler.capacity()
+	scheduler.assigner.advertise(capacity)
+}
+
+// admitJobs admits jobs assigned by server at once, it is called in loop
+func (scheduler *Scheduler) admitJobs(res *pb.JobGetResponse) {
+	for _, job := range BatchJobs(res) {
+		scheduler.admitJob(job)
+	}
+}
+
+// admitJob admits a job assigned by server if it passes local checks, and answers the server.
+// It is called in loop.
+func (scheduler *Scheduler) admitJob(res *pb.JobGetResponse) {
+	capacity, startable := scheduler.capacity()
+	reason := capacity.Reason
+	if capacity.Jobs > 0 {
+		reason = ""
+		if scheduler.getJob(res.JobId) != nil {
+			reason = "job is already assigned"
+		} else if scheduler.gpus.unsatisfiable(res.GpuConf) {
+			reason = ErrNoMatchingGpu.Error()
+		} else if res.Image == nil {
+			reason = ErrNoImage.Error()
+		} else if err := scheduler.config.imagePolicy.Check(res.Image.Url); err != nil {
+			reason = err.Error()
+		}
+	}
+	if reason != "" {
+		scheduler.logger.Info().Str("job", res.JobId).Str("reason", reason).Msg("job assignment rejected")
+		go scheduler.assigner.answer(res.JobId, false, reason)
+		return
+	}
+	lookahead := startable == 0
+	user := scheduler.c.user
+	scheduler.fetched.Add(1)
+	go func() {
+		if lookahead && scheduler.diskSpaceLow(scheduler.config.LookaheadMinFreeDisk) {
+			scheduler.fetched.Add(-1)
+			scheduler.assigner.answer(res.JobId, false, "disk space is low")
+			return
+		}
+		if err := scheduler.acceptJob(res, user, lookahead); err != nil {
+			scheduler.logger.Warn().Err(err).Msg("scheduler fails to create job")
+			scheduler.assigner.answer(res.JobId, false, err.Error())
+			return
+		}
+		scheduler.assigner.answer(res.JobId, true, "")
+	}()
+}
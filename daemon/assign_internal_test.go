@@ -0,0 +1,155 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"google.golang.org/grpc"
+)
+
+// assignStream records answers of engine on the stream of job assignment
+type assignStream struct {
+	grpc.ClientStream
+	answers chan *pb.AssignmentAnswer
+	// sends block until it is closed, if set
+	blocked chan struct{}
+}
+
+// assignTo connects the scheduler to a stream of job assignment, which records answers
+func (scheduler *Scheduler) assignTo() *assignStream {
+	stream := &assignStream{answers: make(chan *pb.AssignmentAnswer, 16)}
+	scheduler.assigner = newAssigner(scheduler)
+	scheduler.assigner.stream = stream
+	return stream
+}
+
+func (s *assignStream) Send(req *pb.AssignmentRequest) error {
+	if s.blocked != nil {
+		<-s.blocked
+	}
+	if answer := req.GetAnswer(); answer != nil {
+		s.answers <- answer
+	}
+	return nil
+}
+
+func (s *assignStream) Recv() (*pb.JobAssignment, error) {
+	select {}
+}
+
+func (s *assignStream) answer(t *testing.T) *pb.AssignmentAnswer {
+	t.Helper()
+	select {
+	case answer := <-s.answers:
+		return answer
+	case <-time.After(5 * time.Second):
+		t.Fatal("no answer of job assignment")
+		return nil
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		name      string
+		lookahead int
+		running   bool
+		available bool
+		gpus      *pb.GpuInfo
+		capacity  *pb.EngineCapacity
+	}{
+		{"idle", 0, true, true, &pb.GpuInfo{}, &pb.EngineCapacity{Jobs: 2, FreeSlots: 2}},
+		{"lookahead", 3, true, true, &pb.GpuInfo{}, &pb.EngineCapacity{Jobs: 5, FreeSlots: 2}},
+		{"gpus", 0, true, true, &pb.GpuInfo{Gpus: []*pb.Gpu{{Uuid: "GPU-0"}, {Uuid: "GPU-1"}, {Uuid: "GPU-2"}}},
+			&pb.EngineCapacity{Jobs: 3, FreeSlots: 3, FreeGpus: 3}},
+		{"paused", 1, false, true, &pb.GpuInfo{}, &pb.EngineCapacity{FreeSlots: 2, Reason: "engine is paused"}},
+		{"unavailable", 1, true, false, &pb.GpuInfo{}, &pb.EngineCapacity{FreeSlots: 2, Reason: "host is not available by policy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t, nil, &SchedulerConfig{Lookahead: tt.lookahead, LogDir: t.TempDir()}, tt.gpus)
+			if !tt.running {
+				s.status = StatusPaused
+			}
+			s.available = tt.available
+			capacity, startable := s.capacity()
+			if capacity.String() != tt.capacity.String() || startable != int(tt.capacity.FreeSlots) {
+				t.Errorf("expect capacity %v, got %v with %d startable", tt.capacity, capacity, startable)
+			}
+		})
+	}
+}
+
+func TestAdmitJob(t *testing.T) {
+	config := &SchedulerConfig{
+		Lookahead:            1,
+		LookaheadMinFreeDisk: 1,
+		LogDir:               t.TempDir(),
+		ImagePolicy:          ImagePolicyConfig{AllowedRegistries: []string{"docker.io"}},
+	}
+	s := newTestScheduler(t, &testEngineClient{}, config, &pb.GpuInfo{})
+	stream := s.assignTo()
+	image := &pb.Image{Url: "alpine:3"}
+
+	tests := []struct {
+		name   string
+		res    *pb.JobGetResponse
+		reason string
+	}{
+		{"accepted", &pb.JobGetResponse{JobId: "a", Image: image}, ""},
+		{"already assigned", &pb.JobGetResponse{JobId: "a", Image: image}, "job is already assigned"},
+		{"no matching gpu", &pb.JobGetResponse{JobId: "b", Image: image, GpuConf: &pb.GpuConf{Opt: pb.GpuOpt_EGO_REQUIRED}},
+			ErrNoMatchingGpu.Error()},
+		{"preferred gpu", &pb.JobGetResponse{JobId: "c", Image: image, GpuConf: &pb.GpuConf{Opt: pb.GpuOpt_EGO_PREFERRED}}, ""},
+		{"no image", &pb.JobGetResponse{JobId: "d"}, ErrNoImage.Error()},
+		{"denied image", &pb.JobGetResponse{JobId: "e", Image: &pb.Image{Url: "ghcr.io/sath-run/vina:1"}},
+			"image policy violation: registry ghcr.io of image ghcr.io/sath-run/vina:1 is not allowed"},
+		// fetched ahead, up to lookahead
+		{"lookahead", &pb.JobGetResponse{JobId: "f", Image: image}, ""},
+		{"no capacity", &pb.JobGetResponse{JobId: "g", Image: image}, "no free capacity"},
+	}
+	for _, tt := range tests {
+		s.admitJobs(tt.res)
+		answer := stream.answer(t)
+		if answer.JobId != tt.res.JobId || answer.Accepted != (tt.reason == "") || answer.Reason != tt.reason {
+			t.Errorf("%s: expect answer %q accepted %v %q, got %v", tt.name, tt.res.JobId, tt.reason == "", tt.reason, answer)
+		}
+		if answer.Accepted {
+			if job := <-s.jobChan; job.metadata.JobId != tt.res.JobId {
+				t.Errorf("%s: expect job %s received, got %s", tt.name, tt.res.JobId, job.metadata.JobId)
+			}
+		}
+	}
+
+	s.status = StatusPaused
+	s.admitJobs(&pb.JobGetResponse{JobId: "h", Image: image})
+	if answer := stream.answer(t); answer.Accepted || answer.Reason != "engine is paused" {
+		t.Errorf("expect job rejected while paused, got %v", answer)
+	}
+}
+
+// a slow send on the stream must not block the loop, which advertises capacity whenever something happens
+func TestAdvertiseWhileSending(t *testing.T) {
+	s := newTestScheduler(t, nil, &SchedulerConfig{LogDir: t.TempDir()}, &pb.GpuInfo{})
+	stream := s.assignTo()
+	stream.blocked = make(chan struct{})
+	// rejected at once, the answer is blocked on the stream
+	s.status = StatusPaused
+	s.admitJobs(&pb.JobGetResponse{JobId: "a", Image: &pb.Image{Url: "alpine:3"}})
+
+	advertised := make(chan struct{})
+	go func() {
+		s.status = StatusRunning
+		s.advertise()
+		close(advertised)
+	}()
+	select {
+	case <-advertised:
+	case <-time.After(time.Second):
+		t.Fatal("advertise is blocked by sending on stream")
+	}
+	close(stream.blocked)
+	if answer := stream.answer(t); answer.JobId != "a" || answer.Accepted {
+		t.Errorf("expect job a rejected, got %v", answer)
+	}
+}
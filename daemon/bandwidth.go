@@ -0,0 +1,42 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+// transfers smaller than this are dominated by latency and are not used to measure bandwidth
+const minBandwidthSample = 1024 * 1024
+
+var (
+	downloadBandwidth = &BandwidthMeter{}
+	uploadBandwidth   = &BandwidthMeter{}
+)
+
+// BandwidthMeter estimates bandwidth from transfers of job files,
+// recent transfers weigh more than older ones
+type BandwidthMeter struct {
+	mu   sync.Mutex
+	rate float64
+}
+
+func (m *BandwidthMeter) Record(bytes int64, duration time.Duration) {
+	if bytes < minBandwidthSample || duration <= 0 {
+		return
+	}
+	rate := float64(bytes) / duration.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rate == 0 {
+		m.rate = rate
+	} else {
+		m.rate = 0.7*m.rate + 0.3*rate
+	}
+}
+
+// Rate returns the estimated bandwidth in bytes/s, 0 if nothing has been measured
+func (m *BandwidthMeter) Rate() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint64(m.rate)
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+// BatchJobs returns jobs of a response in the order they should run, the first one is the response itself.
+// Jobs without id and duplicates are dropped, nil is returned if there is no job.
+func BatchJobs(res *pb.JobGetResponse) []*pb.JobGetResponse {
+	if res == nil {
+		return nil
+	}
+	jobs := []*pb.JobGetResponse{}
+	ids := map[string]bool{}
+	for _, job := range append([]*pb.JobGetResponse{res}, res.Batch...) {
+		if job == nil || job.JobId == "" || ids[job.JobId] {
+			continue
+		}
+		ids[job.JobId] = true
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	return jobs
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"errors"
+	"testing"
+
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+func TestFetchBatch(t *testing.T) {
+	config := &SchedulerConfig{JobBatchSize: 8, Lookahead: 1, LookaheadMinFreeDisk: 1, LogDir: t.TempDir()}
+	var batchSize uint32
+	client := &testEngineClient{getNewJob: func(in *pb.JobGetRequest) (*pb.JobGetResponse, error) {
+		batchSize = in.BatchSize
+		// more jobs than asked
+		res := &pb.JobGetResponse{}
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			res.Batch = append(res.Batch, &pb.JobGetResponse{JobId: id, Image: &pb.Image{Url: "alpine:3"}})
+		}
+		// a job without image fails at once
+		res.Batch[1].Image = nil
+		return res, nil
+	}}
+	s := newTestScheduler(t, client, config, &pb.GpuInfo{})
+
+	s.fetchNewJob()
+	if result := <-s.pollChan; !result.Job || result.Err != nil {
+		t.Fatalf("expect jobs fetched, got %+v", result)
+	}
+	// two slots and one job ahead
+	if batchSize != 3 {
+		t.Errorf("expect batch size clamped to 3, got %d", batchSize)
+	}
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		job := <-s.jobChan
+		var want error
+		if i == 1 {
+			want = ErrNoImage
+		} else if i >= 3 {
+			want = ErrNoCapacity
+		}
+		if job.metadata.JobId != id || !errors.Is(job.err, want) {
+			t.Errorf("expect job %s with error %v, got %s with %v", id, want, job.metadata.JobId, job.err)
+		}
+	}
+}
+
+func TestBatchWarmContainers(t *testing.T) {
+	config := &SchedulerConfig{LogDir: t.TempDir()}
+	s := newTestScheduler(t, nil, config, &pb.GpuInfo{})
+	// no more jobs are polled once a job completes
+	s.status = StatusPaused
+	a := newTestSchedulerJob(config, "a", "sath/amber")
+	b := newTestSchedulerJob(config, "b", "sath/vina")
+	g := newTestSchedulerJob(config, "g", "sath/gromacs")
+	d := newTestSchedulerJob(config, "d", "sath/amber")
+	for _, job := range []*Job{a, b, g, d} {
+		s.queue(job)
+	}
+	if containers, running := s.runningContainers(); containers != 2 || running != 2 {
+		t.Fatalf("expect 2 containers running, got %d containers with %d running", containers, running)
+	}
+
+	// d goes ahead of g, which is fetched earlier, since it runs in the warm container of a
+	s.rescheduleContainer(a.container)
+	if d.container == nil || g.container != nil {
+		t.Errorf("expect d to run before g, got g %v d %v", g.container != nil, d.container != nil)
+	}
+	if containers, running := s.runningContainers(); containers != 2 || running != 2 {
+		t.Errorf("expect 2 containers running, got %d containers with %d running", containers, running)
+	}
+
+	// no container is warm for g, a new one is created once a slot frees up
+	s.rescheduleContainer(b.container)
+	if g.container == nil {
+		t.Errorf("expect g to run")
+	}
+	if containers, running := s.runningContainers(); containers != 3 || running != 2 {
+		t.Errorf("expect 3 containers with 2 running, got %d containers with %d running", containers, running)
+	}
+}
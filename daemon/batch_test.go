@@ -0,0 +1,34 @@
+package daemon_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+func TestBatchJobs(t *testing.T) {
+	tests := []struct {
+		res *pb.JobGetResponse
+		ids []string
+	}{
+		{nil, nil},
+		{&pb.JobGetResponse{}, nil},
+		{&pb.JobGetResponse{JobId: "a"}, []string{"a"}},
+		{&pb.JobGetResponse{JobId: "a", Batch: []*pb.JobGetResponse{{JobId: "b"}, {JobId: "c"}}}, []string{"a", "b", "c"}},
+		// jobs without id and duplicates are dropped
+		{&pb.JobGetResponse{JobId: "a", Batch: []*pb.JobGetResponse{{JobId: "a"}, nil, {}, {JobId: "b"}}}, []string{"a", "b"}},
+		// the first job may be left empty by server
+		{&pb.JobGetResponse{Batch: []*pb.JobGetResponse{{JobId: "b"}, {JobId: "c"}}}, []string{"b", "c"}},
+	}
+	for i, tt := range tests {
+		var ids []string
+		for _, job := range daemon.BatchJobs(tt.res) {
+			ids = append(ids, job.JobId)
+		}
+		if !slices.Equal(ids, tt.ids) {
+			t.Errorf("%d: expect jobs %v, got %v", i, tt.ids, ids)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+// max number of completed jobs whose timings are kept for benchmark
+const maxBenchmarkJobs = 100
+
+// JobTimings is how long each stage of a job took, and the overhead of engine,
+// which is the total time of the job except the time spent running the task
+type JobTimings struct {
+	Stages   map[pb.EnumExecState]time.Duration
+	Total    time.Duration
+	Overhead time.Duration
+}
+
+// StageBenchmark is the mean time of a stage of jobs
+type StageBenchmark struct {
+	State pb.EnumExecState
+	Mean  time.Duration
+}
+
+// JobBenchmark summarizes timings of recently completed jobs
+type JobBenchmark struct {
+	Jobs int
+	// mean time of whole jobs
+	Total time.Duration
+	// mean, median and 95th percentile of overhead of engine
+	Overhead    time.Duration
+	OverheadP50 time.Duration
+	OverheadP95 time.Duration
+	// stages in the order of states
+	Stages []StageBenchmark
+}
+
+// NewJobBenchmark summarizes timings of jobs
+func NewJobBenchmark(timings []JobTimings) JobBenchmark {
+	benchmark := JobBenchmark{Jobs: len(timings), Stages: []StageBenchmark{}}
+	if len(timings) == 0 {
+		return benchmark
+	}
+	n := time.Duration(len(timings))
+	overheads := make([]time.Duration, 0, len(timings))
+	stages := map[pb.EnumExecState]time.Duration{}
+	for _, t := range timings {
+		benchmark.Total += t.Total
+		benchmark.Overhead += t.Overhead
+		overheads = append(overheads, t.Overhead)
+		for state, d := range t.Stages {
+			stages[state] += d
+		}
+	}
+	benchmark.Total /= n
+	benchmark.Overhead /= n
+	slices.Sort(overheads)
+	benchmark.OverheadP50 = percentile(overheads, 50)
+	benchmark.OverheadP95 = percentile(overheads, 95)
+	for state, d := range stages {
+		benchmark.Stages = append(benchmark.Stages, StageBenchmark{State: state, Mean: d / n})
+	}
+	slices.SortFunc(benchmark.Stages, func(a, b StageBenchmark) int {
+		return int(a.State - b.State)
+	})
+	return benchmark
+}
+
+// percentile returns the p-th percentile of sorted durations by the nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// jobBenchmark keeps timings of recently completed jobs
+type jobBenchmark struct {
+	mu      sync.Mutex
+	timings []JobTimings
+}
+
+func (b *jobBenchmark) add(timings JobTimings) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timings = append(b.timings, timings)
+	if len(b.timings) > maxBenchmarkJobs {
+		b.timings = b.timings[len(b.timings)-maxBenchmarkJobs:]
+	}
+}
+
+func (b *jobBenchmark) report() JobBenchmark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return NewJobBenchmark(b.timings)
+}
+
+// timings returns how long each stage of the completed job took
+func (job *Job) timings() JobTimings {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	timings := JobTimings{Stages: map[pb.EnumExecState]time.Duration{}}
+	var running time.Duration
+	for i, stage := range job.stages {
+		end := job.completedAt
+		if i+1 < len(job.stages) {
+			end = job.stages[i+1].startedAt
+		}
+		d := end.Sub(stage.startedAt)
+		if stage.state == pb.EnumExecState_EES_RUNNING {
+			running += d
+		}
+		timings.Stages[stage.state] += d
+	}
+	timings.Total = job.completedAt.Sub(job.createdAt)
+	timings.Overhead = timings.Total - running
+	return timings
+}
@@ -0,0 +1,79 @@
+package daemon_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+func TestJobBenchmark(t *testing.T) {
+	const (
+		downloading = pb.EnumExecState_EES_DOWNLOADING_INPUTS
+		running     = pb.EnumExecState_EES_RUNNING
+		uploading   = pb.EnumExecState_EES_PROCESSING_OUPUTS
+	)
+	timings := func(download, run, upload time.Duration) daemon.JobTimings {
+		return daemon.JobTimings{
+			Stages:   map[pb.EnumExecState]time.Duration{uploading: upload, running: run, downloading: download},
+			Total:    download + run + upload,
+			Overhead: download + upload,
+		}
+	}
+	tests := []struct {
+		name    string
+		timings []daemon.JobTimings
+		want    daemon.JobBenchmark
+	}{
+		{
+			name: "no jobs",
+			want: daemon.JobBenchmark{Stages: []daemon.StageBenchmark{}},
+		},
+		{
+			name:    "one job",
+			timings: []daemon.JobTimings{timings(time.Second, time.Minute, 2*time.Second)},
+			want: daemon.JobBenchmark{
+				Jobs:        1,
+				Total:       time.Minute + 3*time.Second,
+				Overhead:    3 * time.Second,
+				OverheadP50: 3 * time.Second,
+				OverheadP95: 3 * time.Second,
+				Stages: []daemon.StageBenchmark{
+					{State: downloading, Mean: time.Second},
+					{State: running, Mean: time.Minute},
+					{State: uploading, Mean: 2 * time.Second},
+				},
+			},
+		},
+		{
+			name: "percentiles of overhead",
+			timings: []daemon.JobTimings{
+				timings(4*time.Second, time.Minute, 0),
+				timings(time.Second, time.Minute, 0),
+				timings(3*time.Second, time.Minute, 0),
+				timings(2*time.Second, time.Minute, 0),
+			},
+			want: daemon.JobBenchmark{
+				Jobs:        4,
+				Total:       time.Minute + 2500*time.Millisecond,
+				Overhead:    2500 * time.Millisecond,
+				OverheadP50: 2 * time.Second,
+				OverheadP95: 4 * time.Second,
+				Stages: []daemon.StageBenchmark{
+					{State: downloading, Mean: 2500 * time.Millisecond},
+					{State: running, Mean: time.Minute},
+					{State: uploading, Mean: 0},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := daemon.NewJobBenchmark(tt.timings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewJobBenchmark() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointPath returns the dir inside container where the task writes its checkpoint,
+// empty if the job does not checkpoint
+func (job *Job) checkpointPath() string {
+	conf := job.metadata.Checkpoint
+	if conf == nil {
+		return ""
+	} else if conf.Path == "" {
+		return "/checkpoint"
+	}
+	return conf.Path
+}
+
+// checkpointDir is where the checkpoint of a previous run is restored, before moved into container
+func (job *Job) checkpointDir() string {
+	return filepath.Join(job.dir, "checkpoint")
+}
+
+// checkpointArchive is where the snapshot of checkpoint dir is kept locally if it is not uploaded
+func (job *Job) checkpointArchive() string {
+	return filepath.Join(job.config.CheckpointDir, job.metadata.JobId+".tar.gz")
+}
+
+// criuDir is where processes of the task are checkpointed by docker
+func (job *Job) criuDir() string {
+	return filepath.Join(job.config.CheckpointDir, "criu", job.metadata.JobId)
+}
+
+func (job *Job) checkpointInterval() time.Duration {
+	if conf := job.metadata.Checkpoint; conf != nil && conf.Interval > 0 {
+		return time.Duration(conf.Interval) * time.Second
+	}
+	return job.config.CheckpointInterval
+}
+
+// downloadCheckpoint downloads the snapshot to restore if it is specified by server
+func (job *Job) downloadCheckpoint(ctx context.Context) error {
+	conf := job.metadata.Checkpoint
+	if conf == nil || conf.Restore == nil || conf.Restore.Url == "" {
+		return nil
+	}
+	return job.downloadFile(ctx, "checkpoint.tar.gz", job.dir, conf.Restore.Url)
+}
+
+// restoreCheckpoint extracts the snapshot downloaded from server, or else the one kept locally by
+// a previous run of the job, so that the task continues from it
+func (job *Job) restoreCheckpoint() error {
+	if job.metadata.Checkpoint == nil {
+		return nil
+	}
+	archive := filepath.Join(job.dir, "checkpoint.tar.gz")
+	if _, err := os.Stat(archive); err != nil {
+		archive = job.checkpointArchive()
+	}
+	if _, err := os.Stat(archive); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := os.MkdirAll(job.checkpointDir(), os.ModePerm); err != nil {
+		return err
+	}
+	if err := ExtractArchive(archive, job.checkpointDir()); err != nil {
+		return fmt.Errorf("fail to restore checkpoint: %w", err)
+	}
+	job.logger.Info().Str("archive", archive).Msg("checkpoint restored")
+	return nil
+}
+
+// latestCriuCheckpoint returns the id of the latest checkpoint of processes, empty if there is none
+func (job *Job) latestCriuCheckpoint() string {
+	entries, err := os.ReadDir(job.criuDir())
+	if err != nil {
+		return ""
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "sath-") {
+			ids = append(ids, entry.Name())
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	// ids are suffixed by unix time of the same length, so the latest sorts last
+	slices.Sort(ids)
+	return ids[len(ids)-1]
+}
+
+// snapshotCheckpoints saves checkpoints of the running task every interval until done is closed
+func (job *Job) snapshotCheckpoints(done <-chan struct{}) {
+	ticker := time.NewTicker(job.checkpointInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		// a paused task makes no progress, its last checkpoint is still current
+		if job.isPaused() {
+			continue
+		}
+		if err := job.saveCheckpoint(); err != nil {
+			job.logger.Warn().Err(err).Msg("fail to save checkpoint")
+		}
+	}
+}
+
+// saveCheckpoint checkpoints processes of the task if supported, and snapshots the checkpoint dir,
+// which is uploaded to server or kept locally
+func (job *Job) saveCheckpoint() error {
+	ctx, cancel := job.stageContext("saving checkpoint", job.config.UploadTimeout)
+	defer cancel()
+	ctn := job.container
+	if ctn.criu {
+		// processes are checkpointed right before the dir, the task should tolerate the small gap
+		dir := job.criuDir()
+		id := fmt.Sprintf("sath-%d", time.Now().Unix())
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		if err := ctn.checkpoint(ctx, dir, id); err != nil {
+			return fmt.Errorf("fail to checkpoint container: %w", err)
+		}
+		// only the latest checkpoint is kept
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.Name() != id {
+				os.RemoveAll(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	names, err := os.ReadDir(ctn.checkpointDir())
+	if err != nil {
+		return err
+	} else if len(names) == 0 && !ctn.criu {
+		// the task has not written any checkpoint yet
+		return nil
+	}
+	tmp := filepath.Join(job.dir, "checkpoint.tar.gz.sath_tmp")
+	defer os.Remove(tmp)
+	if err := job.frozen(func() error { return ArchiveDir(ctn.checkpointDir(), tmp) }); err != nil {
+		return err
+	}
+	if upload := job.metadata.Checkpoint.Upload; upload != nil && upload.Url != "" {
+		if err := job.uploadFile(ctx, tmp, upload); err != nil {
+			return contextErr(ctx, err)
+		}
+	} else if err := os.Rename(tmp, job.checkpointArchive()); err != nil {
+		return err
+	}
+	job.checkpointAt.Store(time.Now().UnixMilli())
+	job.logger.Info().Msg("checkpoint saved")
+	return job.notifyStatusToRemote(JobNotification{Message: "checkpoint saved"})
+}
+
+// removeCheckpoints removes local checkpoints of the job, which are no longer needed once it succeeds
+func (job *Job) removeCheckpoints() {
+	if job.metadata.Checkpoint == nil {
+		return
+	}
+	for _, path := range []string{job.checkpointArchive(), job.criuDir()} {
+		if err := os.RemoveAll(path); err != nil {
+			job.logger.Warn().Err(err).Msg("fail to remove checkpoint")
+		}
+	}
+}
+
+// pruneCheckpoints removes local checkpoints older than retention, whose jobs are unlikely to come back
+func pruneCheckpoints(dir string, retention time.Duration) {
+	for _, dir := range []string{dir, filepath.Join(dir, "criu")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || entry.Name() == "criu" || time.Since(info.ModTime()) < retention {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if err := os.RemoveAll(path); err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("fail to remove expired checkpoint")
+			}
+		}
+	}
+}
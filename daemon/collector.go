@@ -0,0 +1,474 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"github.com/sath-run/engine/utils"
+	"github.com/shirou/gopsutil/v3/common"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// Probe supplies the raw data parsed by collectors,
+// tests replace it with output recorded from real machines
+type Probe struct {
+	// root of proc, sys and etc dirs, empty for the host
+	Root string
+	// Command runs an external command and returns its stdout
+	Command func(name string, args ...string) ([]byte, error)
+	// DockerInfo returns the result of "docker info"
+	DockerInfo func(ctx context.Context) (*system.Info, error)
+}
+
+// HostProbe reads data from the host the engine runs on
+func HostProbe() *Probe {
+	return &Probe{
+		Command: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).Output()
+		},
+		DockerInfo: func(ctx context.Context) (*system.Info, error) {
+			cli, err := client.NewClientWithOpts(client.FromEnv)
+			if err != nil {
+				return nil, err
+			}
+			defer cli.Close()
+			info, err := cli.Info(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &info, nil
+		},
+	}
+}
+
+// context makes gopsutil read from the root of probe
+func (p *Probe) context(ctx context.Context) context.Context {
+	if p.Root == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, common.EnvKey, common.EnvMap{
+		common.HostProcEnvKey: filepath.Join(p.Root, "proc"),
+		common.HostSysEnvKey:  filepath.Join(p.Root, "sys"),
+		common.HostEtcEnvKey:  filepath.Join(p.Root, "etc"),
+	})
+}
+
+func (p *Probe) path(elem ...string) string {
+	if p.Root == "" {
+		return filepath.Join(append([]string{"/"}, elem...)...)
+	}
+	return filepath.Join(append([]string{p.Root}, elem...)...)
+}
+
+// Collector fills a part of SystemInfo, failures are reported in the err field of that part
+type Collector interface {
+	Collect(ctx context.Context, info *pb.SystemInfo)
+}
+
+func DefaultCollectors(probe *Probe, dataDir string) []Collector {
+	return []Collector{
+		&HostCollector{Probe: probe},
+		&CpuCollector{Probe: probe},
+		&MemoryCollector{Probe: probe},
+		&DiskCollector{Probe: probe, Path: dataDir},
+		&NetworkCollector{Probe: probe, Download: downloadBandwidth, Upload: uploadBandwidth},
+		&GpuCollector{Probe: probe},
+		&RuntimeCollector{Probe: probe},
+		&CgroupCollector{Probe: probe, InContainer: os.Getenv("SATH_ENV") == "docker"},
+	}
+}
+
+func CollectSystemInfo(ctx context.Context, collectors []Collector) *pb.SystemInfo {
+	info := pb.SystemInfo{
+		Host:    &pb.HostInfo{},
+		Cpu:     &pb.CpuInfo{},
+		Memory:  &pb.MemoryInfo{},
+		Gpu:     &pb.GpuInfo{},
+		Disk:    &pb.DiskInfo{},
+		Network: &pb.NetworkInfo{},
+		Runtime: &pb.RuntimeInfo{},
+		Cgroup:  &pb.CgroupInfo{},
+	}
+	for _, collector := range collectors {
+		collector.Collect(ctx, &info)
+	}
+	return &info
+}
+
+func GetSystemInfo() *pb.SystemInfo {
+	dataDir := filepath.Join(utils.SathHome, "data")
+	return CollectSystemInfo(context.Background(), DefaultCollectors(HostProbe(), dataDir))
+}
+
+type HostCollector struct {
+	Probe *Probe
+}
+
+func (c *HostCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	hostInfo, err := host.InfoWithContext(c.Probe.context(ctx))
+	if err != nil {
+		info.Host.Err = err.Error()
+		return
+	}
+	info.Host.PlatformFamily = hostInfo.PlatformFamily
+	info.Host.PlatformVersion = hostInfo.PlatformVersion
+	info.Host.KernelVersion = hostInfo.KernelVersion
+	info.Host.KernelArch = hostInfo.KernelArch
+	info.Host.Os = hostInfo.OS
+	info.Host.Platform = hostInfo.Platform
+}
+
+type CpuCollector struct {
+	Probe *Probe
+}
+
+func (c *CpuCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	cpus, err := cpu.InfoWithContext(c.Probe.context(ctx))
+	if err != nil {
+		info.Cpu.Err = err.Error()
+		return
+	}
+	for _, cpu := range cpus {
+		info.Cpu.Cpus = append(info.Cpu.Cpus, &pb.Cpu{
+			Id:        cpu.CPU,
+			CacheSize: cpu.CacheSize,
+			Clock:     uint64(cpu.Mhz * 1e6),
+			ModelName: cpu.ModelName,
+		})
+	}
+}
+
+type MemoryCollector struct {
+	Probe *Probe
+}
+
+func (c *MemoryCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	memInfo, err := mem.VirtualMemoryWithContext(c.Probe.context(ctx))
+	if err != nil {
+		info.Memory.Err = err.Error()
+		return
+	}
+	info.Memory.Total = memInfo.Total
+}
+
+// DiskCollector reports the size of the file system where Path is located
+type DiskCollector struct {
+	Probe *Probe
+	Path  string
+}
+
+func (c *DiskCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	info.Disk.Path = c.Path
+	out, err := c.Probe.Command("df", "-Pk", c.Path)
+	if err != nil {
+		info.Disk.Err = err.Error()
+		return
+	}
+	total, free, err := parseDf(out)
+	if err != nil {
+		info.Disk.Err = err.Error()
+		return
+	}
+	info.Disk.Total = total
+	info.Disk.Free = free
+}
+
+// parseDf parses the output of "df -Pk", and returns total and available size in bytes
+func parseDf(out []byte) (uint64, uint64, error) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 2 {
+		return 0, 0, fmt.Errorf("unexpected output of df: %q", out)
+	}
+	// filesystem, 1024-blocks, used, available, capacity, mounted on
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 6 {
+		return 0, 0, fmt.Errorf("unexpected output of df: %q", out)
+	}
+	total, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	free, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return total * 1024, free * 1024, nil
+}
+
+// NetworkCollector reports physical and virtual network interfaces except loopback,
+// and bandwidth measured by meters if they are set
+type NetworkCollector struct {
+	Probe    *Probe
+	Download *BandwidthMeter
+	Upload   *BandwidthMeter
+}
+
+func (c *NetworkCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	if c.Download != nil {
+		info.Network.DownloadBandwidth = c.Download.Rate()
+	}
+	if c.Upload != nil {
+		info.Network.UploadBandwidth = c.Upload.Rate()
+	}
+	dir := c.Probe.path("sys", "class", "net")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		info.Network.Err = err.Error()
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "lo" {
+			continue
+		}
+		read := func(file string) string {
+			data, _ := os.ReadFile(filepath.Join(dir, name, file))
+			return strings.TrimSpace(string(data))
+		}
+		iface := &pb.NetworkInterface{
+			Name: name,
+			Mac:  read("address"),
+			Up:   read("operstate") == "up",
+		}
+		if mtu, err := strconv.ParseUint(read("mtu"), 10, 32); err == nil {
+			iface.Mtu = uint32(mtu)
+		}
+		// speed is -1 or unreadable for virtual interfaces and links which are down
+		if speed, err := strconv.ParseInt(read("speed"), 10, 64); err == nil && speed > 0 {
+			iface.Speed = uint64(speed)
+		}
+		info.Network.Interfaces = append(info.Network.Interfaces, iface)
+	}
+}
+
+// RuntimeCollector reports the docker daemon which runs jobs, and whether nvidia container toolkit is available
+type RuntimeCollector struct {
+	Probe *Probe
+}
+
+func (c *RuntimeCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	// nvidia-ctk is not installed in the image of engine, in which case the runtime of docker tells
+	if out, err := c.Probe.Command("nvidia-ctk", "--version"); err == nil {
+		info.Runtime.NvidiaToolkitVersion = parseNvidiaCtkVersion(out)
+		info.Runtime.NvidiaToolkit = true
+	}
+
+	dockerInfo, err := c.Probe.DockerInfo(ctx)
+	if err != nil {
+		info.Runtime.Err = err.Error()
+		return
+	}
+	info.Runtime.Version = dockerInfo.ServerVersion
+	info.Runtime.StorageDriver = dockerInfo.Driver
+	info.Runtime.CgroupDriver = dockerInfo.CgroupDriver
+	info.Runtime.CgroupVersion = dockerInfo.CgroupVersion
+	for name := range dockerInfo.Runtimes {
+		info.Runtime.Runtimes = append(info.Runtime.Runtimes, name)
+		if name == "nvidia" {
+			info.Runtime.NvidiaToolkit = true
+		}
+	}
+	sort.Strings(info.Runtime.Runtimes)
+}
+
+// parseNvidiaCtkVersion parses output of "nvidia-ctk --version" like "NVIDIA Container Toolkit CLI version 1.14.6"
+func parseNvidiaCtkVersion(out []byte) string {
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// CgroupCollector reports cpu and memory limits of the cgroup which engine runs in,
+// which are lower than resources of the host if engine runs in a container with limits
+type CgroupCollector struct {
+	Probe       *Probe
+	InContainer bool
+}
+
+func (c *CgroupCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	info.Cgroup.InContainer = c.InContainer
+	if _, err := os.Stat(c.Probe.path(".dockerenv")); err == nil {
+		info.Cgroup.InContainer = true
+	}
+	data, err := os.ReadFile(c.Probe.path("proc", "self", "cgroup"))
+	if err != nil {
+		info.Cgroup.Err = err.Error()
+		return
+	}
+	// lines are like "0::/system.slice/sath.service" for v2, or "4:cpu,cpuacct:/docker/<id>" for v1
+	paths := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[1] == "" {
+			paths[""] = fields[2]
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			paths[controller] = fields[2]
+		}
+	}
+
+	root := c.Probe.path("sys", "fs", "cgroup")
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		info.Cgroup.Version = "2"
+		if quota, period, ok := strings.Cut(readCgroupFile(root, paths[""], "cpu.max"), " "); ok && quota != "max" {
+			info.Cgroup.CpuLimit = cpuLimit(quota, period)
+		}
+		info.Cgroup.MemoryLimit = memoryLimit(readCgroupFile(root, paths[""], "memory.max"))
+		return
+	}
+	info.Cgroup.Version = "1"
+	cpuRoot := filepath.Join(root, "cpu,cpuacct")
+	if _, err := os.Stat(cpuRoot); err != nil {
+		cpuRoot = filepath.Join(root, "cpu")
+	}
+	quota := readCgroupFile(cpuRoot, paths["cpu"], "cpu.cfs_quota_us")
+	period := readCgroupFile(cpuRoot, paths["cpu"], "cpu.cfs_period_us")
+	if quota != "" && quota != "-1" {
+		info.Cgroup.CpuLimit = cpuLimit(quota, period)
+	}
+	info.Cgroup.MemoryLimit = memoryLimit(readCgroupFile(filepath.Join(root, "memory"), paths["memory"], "memory.limit_in_bytes"))
+}
+
+// readCgroupFile reads file of the cgroup, the root of hierarchy is used if the cgroup is not visible,
+// which is the case in containers where cgroup paths of the host are shown
+func readCgroupFile(root string, path string, name string) string {
+	for _, dir := range []string{filepath.Join(root, path), root} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
+
+func cpuLimit(quota string, period string) float64 {
+	q, err1 := strconv.ParseFloat(quota, 64)
+	p, err2 := strconv.ParseFloat(period, 64)
+	if err1 != nil || err2 != nil || q <= 0 || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// memoryLimit parses memory limit in bytes, v1 reports a huge number page aligned to max int64 if not limited
+func memoryLimit(limit string) uint64 {
+	value, err := strconv.ParseUint(limit, 10, 64)
+	if err != nil || value >= 1<<62 {
+		return 0
+	}
+	return value
+}
+
+// GpuCollector reports nvidia gpus by nvidia-smi and amd gpus by rocm-smi
+type GpuCollector struct {
+	Probe *Probe
+}
+
+func (c *GpuCollector) Collect(ctx context.Context, info *pb.SystemInfo) {
+	gpuInfo, nvidiaErr := c.Probe.nvidiaGPUInfo()
+	if nvidiaErr == nil {
+		info.Gpu.CudaVersion = gpuInfo.CudaVersion
+		info.Gpu.DriverVersion = gpuInfo.DriverVersion
+		for _, gpu := range gpuInfo.Gpus {
+			info.Gpu.Gpus = append(info.Gpu.Gpus, &pb.Gpu{
+				Id:                  gpu.Id,
+				Uuid:                gpu.Uuid,
+				ProductName:         gpu.ProductName,
+				ProductBrand:        gpu.ProductBrand,
+				ProductArchitecture: gpu.ProductArchitecture,
+				VbiosVersion:        gpu.VbiosVersion,
+				GpuPartNumber:       gpu.GpuPartNumber,
+				Clocks:              gpuClocks(gpu.Uuid, gpu.Clocks),
+				MaxClocks:           gpuClocks(gpu.Uuid, gpu.MaxClocks),
+				Vendor:              "nvidia",
+				Vram:                parseMemory(gpu.FbMemoryUsage.Total),
+			})
+		}
+	}
+
+	rocmInfo, rocmErr := c.Probe.rocmGPUInfo()
+	if rocmErr == nil {
+		info.Gpu.RocmDriverVersion = rocmInfo.DriverVersion
+		for _, gpu := range rocmInfo.Gpus {
+			info.Gpu.Gpus = append(info.Gpu.Gpus, &pb.Gpu{
+				Id:                  fmt.Sprintf("card%d", gpu.Index),
+				Uuid:                gpu.Uuid,
+				ProductName:         gpu.ProductName,
+				GpuPartNumber:       gpu.Sku,
+				ProductArchitecture: gpu.GfxVersion,
+				Vendor:              "amd",
+				Vram:                gpu.VramTotal,
+			})
+		}
+	}
+	if nvidiaErr != nil && rocmErr != nil {
+		info.Gpu.Err = errors.Join(nvidiaErr, rocmErr).Error()
+	}
+}
+
+// gpuClocks converts clocks of nvidia-smi, an unexpected format is logged instead of
+// being reported as 0 MHz silently
+func gpuClocks(uuid string, clock GpuClock) *pb.GpuClocks {
+	clocks := &pb.GpuClocks{}
+	for _, c := range []struct {
+		name  string
+		value string
+		dest  *uint64
+	}{
+		{"graphics", clock.Graphics, &clocks.Graphics},
+		{"sm", clock.Sm, &clocks.Sm},
+		{"mem", clock.Mem, &clocks.Mem},
+		{"video", clock.Video, &clocks.Video},
+	} {
+		value, err := parseClock(c.value)
+		if err != nil {
+			log.Warn().Err(err).Str("gpu", uuid).Str("clock", c.name).Msg("unexpected clock of nvidia-smi")
+		}
+		*c.dest = value
+	}
+	return clocks
+}
+
+// CollectGpuStats samples utilization, memory, temperature, power and clocks of nvidia gpus
+func CollectGpuStats(probe *Probe) ([]*pb.GpuStats, error) {
+	info, err := probe.nvidiaGPUInfo()
+	if err != nil {
+		return nil, err
+	}
+	return gpuStatsFromInfo(info), nil
+}
+
+func (p *Probe) nvidiaGPUInfo() (*GPUInfo, error) {
+	out, err := p.Command("nvidia-smi", "-q", "-x")
+	if err != nil {
+		return nil, err
+	}
+	return ParseNvidiaSmi(out)
+}
+
+func (p *Probe) rocmGPUInfo() (*RocmInfo, error) {
+	out, err := p.Command("rocm-smi",
+		"--showproductname", "--showuniqueid", "--showmeminfo", "vram", "--showdriverversion", "--json")
+	if err != nil {
+		return nil, err
+	}
+	return ParseRocmSmi(out)
+}
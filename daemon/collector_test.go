@@ -0,0 +1,496 @@
+package daemon_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/system"
+	"github.com/sath-run/engine/daemon"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordedProbe replays output recorded from real machines, commands without a recording
+// fail as if they were not installed, and "docker-info" is the output of docker info.
+// Outputs are keyed by command name, or by its leading arguments as well if output differs by them,
+// the longest matching key wins.
+func recordedProbe(root string, outputs map[string]string) *daemon.Probe {
+	return &daemon.Probe{
+		Root: root,
+		Command: func(name string, args ...string) ([]byte, error) {
+			line := strings.Join(append([]string{name}, args...), " ")
+			file, ok, matched := "", false, ""
+			for key, f := range outputs {
+				if (line == key || strings.HasPrefix(line, key+" ")) && len(key) > len(matched) {
+					file, ok, matched = f, true, key
+				}
+			}
+			if !ok {
+				return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+			}
+			return os.ReadFile(filepath.Join("testdata", file))
+		},
+		DockerInfo: func(ctx context.Context) (*system.Info, error) {
+			file, ok := outputs["docker-info"]
+			if !ok {
+				return nil, errors.New("Cannot connect to the Docker daemon at unix:///var/run/docker.sock")
+			}
+			data, err := os.ReadFile(filepath.Join("testdata", file))
+			if err != nil {
+				return nil, err
+			}
+			var info system.Info
+			err = json.Unmarshal(data, &info)
+			return &info, err
+		},
+	}
+}
+
+func collect(collector daemon.Collector) *pb.SystemInfo {
+	return daemon.CollectSystemInfo(context.Background(), []daemon.Collector{collector})
+}
+
+func TestGpuCollectorNvidia(t *testing.T) {
+	const mhz = 1000 * 1000
+	tests := []struct {
+		fixture   string
+		driver    string
+		cuda      string
+		products  []string
+		vram      uint64
+		clocks    *pb.GpuClocks
+		maxClocks *pb.GpuClocks
+	}{
+		{
+			fixture:   "driver-470-rtx3090.xml",
+			driver:    "470.182.03",
+			cuda:      "11.4",
+			products:  []string{"NVIDIA GeForce RTX 3090"},
+			vram:      24268 << 20,
+			clocks:    &pb.GpuClocks{Graphics: 1875 * mhz, Sm: 1875 * mhz, Mem: 9751 * mhz, Video: 1665 * mhz},
+			maxClocks: &pb.GpuClocks{Graphics: 2100 * mhz, Sm: 2100 * mhz, Mem: 9751 * mhz, Video: 1950 * mhz},
+		},
+		{
+			fixture:   "driver-525-a100x2.xml",
+			driver:    "525.105.17",
+			cuda:      "12.0",
+			products:  []string{"NVIDIA A100-SXM4-40GB", "NVIDIA A100-SXM4-40GB"},
+			vram:      40960 << 20,
+			clocks:    &pb.GpuClocks{Graphics: 1410 * mhz, Sm: 1410 * mhz, Mem: 1215 * mhz, Video: 1275 * mhz},
+			maxClocks: &pb.GpuClocks{Graphics: 1410 * mhz, Sm: 1410 * mhz, Mem: 1215 * mhz, Video: 1290 * mhz},
+		},
+		{
+			fixture:   "driver-535-rtx4090.xml",
+			driver:    "535.129.03",
+			cuda:      "12.2",
+			products:  []string{"NVIDIA GeForce RTX 4090"},
+			vram:      24564 << 20,
+			clocks:    &pb.GpuClocks{Graphics: 2730 * mhz, Sm: 2730 * mhz, Mem: 10501 * mhz, Video: 2130 * mhz},
+			maxClocks: &pb.GpuClocks{Graphics: 3120 * mhz, Sm: 3120 * mhz, Mem: 10501 * mhz, Video: 2415 * mhz},
+		},
+		{
+			// max video clock is not reported
+			fixture:   "driver-550-h100.xml",
+			driver:    "550.54.15",
+			cuda:      "12.4",
+			products:  []string{"NVIDIA H100 80GB HBM3"},
+			vram:      81559 << 20,
+			clocks:    &pb.GpuClocks{Graphics: 1980 * mhz, Sm: 1980 * mhz, Mem: 2619 * mhz, Video: 1545 * mhz},
+			maxClocks: &pb.GpuClocks{Graphics: 1980 * mhz, Sm: 1980 * mhz, Mem: 2619 * mhz},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			probe := recordedProbe("", map[string]string{"nvidia-smi": filepath.Join("nvidia-smi", tt.fixture)})
+			info := collect(&daemon.GpuCollector{Probe: probe}).Gpu
+			if info.Err != "" {
+				t.Fatal(info.Err)
+			}
+			if info.DriverVersion != tt.driver || info.CudaVersion != tt.cuda {
+				t.Errorf("got driver %q cuda %q, want %q %q", info.DriverVersion, info.CudaVersion, tt.driver, tt.cuda)
+			}
+			if len(info.Gpus) != len(tt.products) {
+				t.Fatalf("got %d gpus, want %d", len(info.Gpus), len(tt.products))
+			}
+			for i, gpu := range info.Gpus {
+				if gpu.ProductName != tt.products[i] {
+					t.Errorf("gpu %d: got product %q, want %q", i, gpu.ProductName, tt.products[i])
+				}
+				if gpu.Vendor != "nvidia" || gpu.Uuid == "" {
+					t.Errorf("gpu %d: got vendor %q uuid %q", i, gpu.Vendor, gpu.Uuid)
+				}
+				if gpu.Vram != tt.vram {
+					t.Errorf("gpu %d: got vram %d, want %d", i, gpu.Vram, tt.vram)
+				}
+			}
+			gpu := info.Gpus[0]
+			if !proto.Equal(gpu.Clocks, tt.clocks) {
+				t.Errorf("got clocks {%v}, want {%v}", gpu.Clocks, tt.clocks)
+			}
+			if !proto.Equal(gpu.MaxClocks, tt.maxClocks) {
+				t.Errorf("got max clocks {%v}, want {%v}", gpu.MaxClocks, tt.maxClocks)
+			}
+		})
+	}
+}
+
+func TestGpuCollectorRocm(t *testing.T) {
+	probe := recordedProbe("", map[string]string{"rocm-smi": "rocm-smi/rocm5-mi100x2.json"})
+	info := collect(&daemon.GpuCollector{Probe: probe}).Gpu
+	if info.Err != "" {
+		t.Fatal(info.Err)
+	}
+	if info.RocmDriverVersion != "5.16.9.22.20" {
+		t.Errorf("got rocm driver %q", info.RocmDriverVersion)
+	}
+	if len(info.Gpus) != 2 {
+		t.Fatalf("got %d gpus, want 2", len(info.Gpus))
+	}
+	for _, gpu := range info.Gpus {
+		if gpu.Vendor != "amd" || gpu.Vram != 34342961152 {
+			t.Errorf("got vendor %q vram %d", gpu.Vendor, gpu.Vram)
+		}
+	}
+}
+
+func TestGpuCollectorNoGpu(t *testing.T) {
+	info := collect(&daemon.GpuCollector{Probe: recordedProbe("", nil)}).Gpu
+	if info.Err == "" || len(info.Gpus) != 0 {
+		t.Errorf("got err %q and %d gpus, want an error and no gpu", info.Err, len(info.Gpus))
+	}
+}
+
+func TestCollectGpuStats(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		utilization []uint32
+		memoryUsed  uint64
+		temperature uint32
+		powerDraw   uint64
+		powerLimit  uint64
+	}{
+		// drivers before 530 report power in power_readings
+		{"driver-470-rtx3090.xml", []uint32{97}, 18342 << 20, 71, 338210, 350000},
+		{"driver-525-a100x2.xml", []uint32{100, 0}, 35021 << 20, 58, 287440, 400000},
+		// later ones in gpu_power_readings
+		{"driver-535-rtx4090.xml", []uint32{88}, 20112 << 20, 64, 402870, 450000},
+		// which report average power draw in later drivers
+		{"driver-550-h100.xml", []uint32{76}, 61440 << 20, 49, 512310, 700000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			probe := recordedProbe("", map[string]string{"nvidia-smi": filepath.Join("nvidia-smi", tt.fixture)})
+			stats, err := daemon.CollectGpuStats(probe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats) != len(tt.utilization) {
+				t.Fatalf("got %d gpus, want %d", len(stats), len(tt.utilization))
+			}
+			for i, s := range stats {
+				if s.Id != int32(i) {
+					t.Errorf("gpu %d: got id %d", i, s.Id)
+				}
+				if s.Utilization != tt.utilization[i] {
+					t.Errorf("gpu %d: got utilization %d, want %d", i, s.Utilization, tt.utilization[i])
+				}
+			}
+			s := stats[0]
+			if s.MemoryUsed != tt.memoryUsed || s.Temperature != tt.temperature {
+				t.Errorf("got memory used %d temperature %d, want %d %d", s.MemoryUsed, s.Temperature, tt.memoryUsed, tt.temperature)
+			}
+			if s.PowerDraw != tt.powerDraw || s.PowerLimit != tt.powerLimit {
+				t.Errorf("got power %d/%d, want %d/%d", s.PowerDraw, s.PowerLimit, tt.powerDraw, tt.powerLimit)
+			}
+			if s.Clocks.Graphics == 0 || s.MemoryTotal == 0 || s.Timestamp == 0 {
+				t.Errorf("got incomplete stats {%v}", s)
+			}
+		})
+	}
+}
+
+func TestDiskCollector(t *testing.T) {
+	tests := []struct {
+		fixture string
+		total   uint64
+		free    uint64
+	}{
+		{"linux-ext4.txt", 959863856 * 1024, 65115092 * 1024},
+		{"macos-apfs.txt", 482797652 * 1024, 153417120 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			probe := recordedProbe("", map[string]string{"df": filepath.Join("df", tt.fixture)})
+			info := collect(&daemon.DiskCollector{Probe: probe, Path: "/data"}).Disk
+			if info.Err != "" {
+				t.Fatal(info.Err)
+			}
+			if info.Path != "/data" || info.Total != tt.total || info.Free != tt.free {
+				t.Errorf("got %s %d/%d, want %d/%d", info.Path, info.Free, info.Total, tt.free, tt.total)
+			}
+		})
+	}
+}
+
+func TestHostCollectors(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("recorded proc and sys files are only read on linux")
+	}
+	tests := []struct {
+		host       string
+		platform   string
+		version    string
+		family     string
+		cpus       int
+		cpuModel   string
+		cpuClock   uint64
+		memory     uint64
+		interfaces []*pb.NetworkInterface
+	}{
+		{
+			host:     "ubuntu-22.04-workstation",
+			platform: "ubuntu",
+			version:  "22.04",
+			family:   "debian",
+			cpus:     4,
+			cpuModel: "12th Gen Intel(R) Core(TM) i5-12500",
+			cpuClock: 3000 * 1000 * 1000,
+			memory:   32594816 * 1024,
+			interfaces: []*pb.NetworkInterface{
+				{Name: "docker0", Mac: "02:42:7a:5d:13:9c", Mtu: 1500},
+				{Name: "enp5s0", Mac: "a8:a1:59:3c:7e:21", Mtu: 1500, Speed: 1000, Up: true},
+				{Name: "wlp4s0", Mac: "dc:21:5c:9a:0b:77", Mtu: 1500},
+			},
+		},
+		{
+			host:     "rocky-9-server",
+			platform: "rocky",
+			version:  "9.3",
+			family:   "rhel",
+			cpus:     2,
+			cpuModel: "Intel(R) Xeon(R) Gold 6338 CPU @ 2.00GHz",
+			cpuClock: 2000 * 1000 * 1000,
+			memory:   263842160 * 1024,
+			interfaces: []*pb.NetworkInterface{
+				{Name: "ens1f0", Mac: "b4:96:91:a2:3c:10", Mtu: 9000, Speed: 25000, Up: true},
+				{Name: "ens1f1", Mac: "b4:96:91:a2:3c:11", Mtu: 1500},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			probe := recordedProbe(filepath.Join("testdata", "hosts", tt.host), nil)
+			info := daemon.CollectSystemInfo(context.Background(), []daemon.Collector{
+				&daemon.HostCollector{Probe: probe},
+				&daemon.CpuCollector{Probe: probe},
+				&daemon.MemoryCollector{Probe: probe},
+				&daemon.NetworkCollector{Probe: probe},
+			})
+
+			if info.Host.Err != "" {
+				t.Error(info.Host.Err)
+			} else if info.Host.Platform != tt.platform || info.Host.PlatformVersion != tt.version || info.Host.PlatformFamily != tt.family {
+				t.Errorf("got platform %q %q %q", info.Host.Platform, info.Host.PlatformVersion, info.Host.PlatformFamily)
+			}
+
+			if info.Cpu.Err != "" {
+				t.Error(info.Cpu.Err)
+			} else if len(info.Cpu.Cpus) != tt.cpus {
+				t.Errorf("got %d cpus, want %d", len(info.Cpu.Cpus), tt.cpus)
+			} else if cpu := info.Cpu.Cpus[0]; cpu.ModelName != tt.cpuModel || cpu.Clock != tt.cpuClock {
+				t.Errorf("got cpu %q %d", cpu.ModelName, cpu.Clock)
+			}
+
+			if info.Memory.Err != "" {
+				t.Error(info.Memory.Err)
+			} else if info.Memory.Total != tt.memory {
+				t.Errorf("got memory %d, want %d", info.Memory.Total, tt.memory)
+			}
+
+			if info.Network.Err != "" {
+				t.Error(info.Network.Err)
+			} else if len(info.Network.Interfaces) != len(tt.interfaces) {
+				t.Errorf("got %d interfaces, want %d", len(info.Network.Interfaces), len(tt.interfaces))
+			} else {
+				for i, iface := range info.Network.Interfaces {
+					if !proto.Equal(iface, tt.interfaces[i]) {
+						t.Errorf("got interface {%v}, want {%v}", iface, tt.interfaces[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	tests := []struct {
+		name     string
+		outputs  map[string]string
+		expected *pb.RuntimeInfo
+	}{
+		{
+			name:    "docker 26 with nvidia runtime",
+			outputs: map[string]string{"docker-info": "docker-info/docker-26-nvidia.json"},
+			expected: &pb.RuntimeInfo{
+				Version:       "26.1.3",
+				StorageDriver: "overlay2",
+				CgroupDriver:  "systemd",
+				CgroupVersion: "2",
+				Runtimes:      []string{"io.containerd.runc.v2", "nvidia", "runc"},
+				NvidiaToolkit: true,
+			},
+		},
+		{
+			name: "docker 20.10 with nvidia-ctk but no nvidia runtime",
+			outputs: map[string]string{
+				"docker-info": "docker-info/docker-20.10-cgroupv1.json",
+				"nvidia-ctk":  "nvidia-ctk/1.14.6.txt",
+			},
+			expected: &pb.RuntimeInfo{
+				Version:              "20.10.24",
+				StorageDriver:        "btrfs",
+				CgroupDriver:         "cgroupfs",
+				CgroupVersion:        "1",
+				Runtimes:             []string{"io.containerd.runc.v2", "io.containerd.runtime.v1.linux", "runc"},
+				NvidiaToolkit:        true,
+				NvidiaToolkitVersion: "1.14.6",
+			},
+		},
+		{
+			name:    "docker is not running",
+			outputs: map[string]string{},
+			expected: &pb.RuntimeInfo{
+				Err: "Cannot connect to the Docker daemon at unix:///var/run/docker.sock",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := collect(&daemon.RuntimeCollector{Probe: recordedProbe("", tt.outputs)}).Runtime
+			if !proto.Equal(info, tt.expected) {
+				t.Errorf("got {%v}, want {%v}", info, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCgroupCollector(t *testing.T) {
+	tests := []struct {
+		host        string
+		inContainer bool
+		version     string
+		cpuLimit    float64
+		memoryLimit uint64
+	}{
+		{"ubuntu-22.04-workstation", false, "2", 0, 0},
+		{"rocky-9-server", false, "1", 4, 16 << 30},
+		{"docker-cgroupv2-limited", true, "2", 2.5, 8 << 30},
+		{"docker-cgroupv1", true, "1", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			probe := recordedProbe(filepath.Join("testdata", "hosts", tt.host), nil)
+			info := collect(&daemon.CgroupCollector{Probe: probe}).Cgroup
+			if info.Err != "" {
+				t.Fatal(info.Err)
+			}
+			if info.InContainer != tt.inContainer || info.Version != tt.version {
+				t.Errorf("got in container %v version %q", info.InContainer, info.Version)
+			}
+			if info.CpuLimit != tt.cpuLimit || info.MemoryLimit != tt.memoryLimit {
+				t.Errorf("got limits %v cpus %d bytes, want %v %d", info.CpuLimit, info.MemoryLimit, tt.cpuLimit, tt.memoryLimit)
+			}
+		})
+	}
+}
+
+func TestNetworkBandwidth(t *testing.T) {
+	download, upload := &daemon.BandwidthMeter{}, &daemon.BandwidthMeter{}
+	// small transfers are dominated by latency and ignored
+	download.Record(1024, time.Millisecond)
+	download.Record(100<<20, 10*time.Second)
+	upload.Record(20<<20, 4*time.Second)
+	upload.Record(20<<20, 2*time.Second)
+	probe := recordedProbe(filepath.Join("testdata", "hosts", "ubuntu-22.04-workstation"), nil)
+	info := collect(&daemon.NetworkCollector{Probe: probe, Download: download, Upload: upload}).Network
+	if info.DownloadBandwidth != 10<<20 {
+		t.Errorf("got download bandwidth %d, want %d", info.DownloadBandwidth, 10<<20)
+	}
+	if info.UploadBandwidth <= 5<<20 || info.UploadBandwidth >= 10<<20 {
+		t.Errorf("got upload bandwidth %d, want between 5M and 10M", info.UploadBandwidth)
+	}
+}
+
+func TestSystemInfoChanges(t *testing.T) {
+	probe := func(nvidia string) *pb.SystemInfo {
+		probe := recordedProbe("", map[string]string{
+			"nvidia-smi": filepath.Join("nvidia-smi", nvidia),
+			"df":         "df/linux-ext4.txt",
+		})
+		return daemon.CollectSystemInfo(context.Background(), []daemon.Collector{
+			&daemon.GpuCollector{Probe: probe},
+			&daemon.DiskCollector{Probe: probe, Path: "/data"},
+			&daemon.NetworkCollector{Probe: probe, Download: &daemon.BandwidthMeter{}, Upload: &daemon.BandwidthMeter{}},
+		})
+	}
+	last := probe("driver-470-rtx3090.xml")
+	last.Network.DownloadBandwidth = 10 << 20
+
+	tests := []struct {
+		name    string
+		update  func(info *pb.SystemInfo) *pb.SystemInfo
+		changes []string
+	}{
+		{"unchanged", func(info *pb.SystemInfo) *pb.SystemInfo { return info }, []string{}},
+		{"gpu clocks", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Gpu.Gpus[0].Clocks.Graphics /= 2
+			return info
+		}, []string{}},
+		{"gpu replaced", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Gpu = probe("driver-525-a100x2.xml").Gpu
+			return info
+		}, []string{"gpu"}},
+		{"little disk used", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Disk.Free -= 100 << 20
+			return info
+		}, []string{}},
+		{"disk freed", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Disk.Free += info.Disk.Total / 10
+			return info
+		}, []string{"disk"}},
+		{"bandwidth fluctuates", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Network.DownloadBandwidth = 12 << 20
+			return info
+		}, []string{}},
+		{"bandwidth drops", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Network.DownloadBandwidth = 2 << 20
+			return info
+		}, []string{"network"}},
+		{"upload measured", func(info *pb.SystemInfo) *pb.SystemInfo {
+			info.Network.UploadBandwidth = 1 << 20
+			return info
+		}, []string{"network"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tt.update(proto.Clone(last).(*pb.SystemInfo))
+			changes := daemon.SystemInfoChanges(last, info)
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("expect changes %v, got %v", tt.changes, changes)
+			}
+		})
+	}
+
+	if changes := daemon.SystemInfoChanges(nil, last); !slices.Equal(changes, []string{"all"}) {
+		t.Errorf("expect all to be changed if nothing is reported, got %v", changes)
+	}
+}
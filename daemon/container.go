@@ -2,12 +2,16 @@ package daemon
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
-	"github.com/docker/cli/opts"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/checkpoint"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
@@ -22,27 +26,39 @@ type Container struct {
 	imageUrl   string
 	imageAuth  string
 	dir        string
-	gpuOpt     pb.GpuOpt
-	vram       uint64
+	gpu        *gpuDevice
 	currentJob *Job
+	// whether the container is paused by docker
+	paused     atomic.Bool
 	binds      []string
 	logger     zerolog.Logger
 	resourceId string
+	// dir inside container where the task writes its checkpoint, empty if not bound
+	checkpointPath string
+	// whether the task runs as the main process of container, so that it can be checkpointed
+	// by docker. Such a container runs a single task and is removed afterwards.
+	criu bool
 }
 
-func newContainer(dockerCli *client.Client, dir string, job *Job) *Container {
+func newContainer(dockerCli *client.Client, dir string, job *Job, gpu *gpuDevice, criu bool) *Container {
 	ctn := &Container{
-		cli:        dockerCli,
-		imageUrl:   job.metadata.Image.Url,
-		imageAuth:  job.metadata.Image.Auth,
-		currentJob: job,
-		dir:        dir,
-		gpuOpt:     job.metadata.GpuConf.Opt,
-		vram:       0, // TODO
-		resourceId: job.metadata.ResourceId,
+		cli:            dockerCli,
+		gpu:            gpu,
+		imageUrl:       job.runImageRef(),
+		imageAuth:      job.metadata.Image.Auth,
+		currentJob:     job,
+		dir:            dir,
+		resourceId:     job.metadata.ResourceId,
+		checkpointPath: job.checkpointPath(),
+		criu:           criu,
+		logger:         log.With().Str("container_dir", dir).Logger(),
 	}
 
-	for _, v := range []string{"data", "source", "output", "resource"} {
+	volumes := []string{"data", "source", "output", "resource"}
+	if ctn.checkpointPath != "" {
+		volumes = append(volumes, "checkpoint")
+	}
+	for _, v := range volumes {
 		var (
 			src      string
 			dst      string
@@ -57,6 +73,9 @@ func newContainer(dockerCli *client.Client, dir string, job *Job) *Container {
 			readonly = false
 		}
 		dst = job.metadata.Image.Binds[v]
+		if v == "checkpoint" {
+			dst = ctn.checkpointPath
+		}
 		if dst == "" {
 			dst = "/" + v
 		}
@@ -72,9 +91,61 @@ func newContainer(dockerCli *client.Client, dir string, job *Job) *Container {
 }
 
 func (ctn *Container) init(ctx context.Context) error {
-	gpuOptsVal := opts.GpuOpts{}
-	if ctn.gpuOpt != pb.GpuOpt_EGO_None {
-		gpuOptsVal.Set("all")
+	if err := ctn.create(ctx, nil); err != nil {
+		return err
+	}
+	if err := ctn.cli.ContainerStart(ctx, ctn.id, container.StartOptions{}); err != nil {
+		return err
+	}
+	if err := ctn.waitRunning(ctx); err != nil {
+		return err
+	}
+
+	// TODO: download files for sources if specified
+
+	return nil
+}
+
+// amdRenderNode returns the render node under /dev/dri of the amd card of index, as linked in sysfs
+func amdRenderNode(sysfs string, index int) (string, error) {
+	matches, _ := filepath.Glob(filepath.Join(sysfs, "class/drm", fmt.Sprintf("card%d", index), "device/drm/renderD*"))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no render node of amd gpu card%d", index)
+	}
+	return filepath.Join("/dev/dri", filepath.Base(matches[0])), nil
+}
+
+// create creates the docker container without starting it. If cmd is nil, the container
+// keeps running with a tty so that tasks are executed in it, otherwise cmd is its main process.
+func (ctn *Container) create(ctx context.Context, cmd []string) error {
+	var (
+		deviceRequests []container.DeviceRequest
+		devices        []container.DeviceMapping
+		groups         []string
+		env            []string
+	)
+	if gpu := ctn.gpu; gpu != nil && gpu.Model == pb.GpuModel_EGM_AMD {
+		// ROCm needs kfd, and only the render node of the assigned gpu is exposed, so the container
+		// sees it as its only gpu
+		render, err := amdRenderNode("/sys", gpu.Index)
+		if err != nil {
+			return err
+		}
+		for _, path := range []string{"/dev/kfd", render} {
+			devices = append(devices, container.DeviceMapping{
+				PathOnHost:        path,
+				PathInContainer:   path,
+				CgroupPermissions: "rwm",
+			})
+		}
+		groups = append(groups, "video")
+	} else if gpu != nil {
+		// only expose the assigned gpu to container
+		deviceRequests = append(deviceRequests, container.DeviceRequest{
+			Driver:       "nvidia",
+			DeviceIDs:    []string{gpu.Uuid},
+			Capabilities: [][]string{{"gpu"}},
+		})
 	}
 	hostname := os.Getenv("HOSTNAME")
 	if hostname == "" {
@@ -82,16 +153,20 @@ func (ctn *Container) init(ctx context.Context) error {
 	}
 	cbody, err := ctn.cli.ContainerCreate(ctx, &container.Config{
 		Image: ctn.imageUrl,
-		Tty:   true,
+		Cmd:   cmd,
+		Tty:   cmd == nil,
 		Labels: map[string]string{
 			"run.sath.starter": hostname,
 		},
 		AttachStdout: true,
 		AttachStderr: true,
+		Env:          env,
 	}, &container.HostConfig{
-		Binds: ctn.binds,
+		Binds:    ctn.binds,
+		GroupAdd: groups,
 		Resources: container.Resources{
-			DeviceRequests: gpuOptsVal.Value(),
+			DeviceRequests: deviceRequests,
+			Devices:        devices,
 		},
 	},
 		nil,
@@ -106,33 +181,167 @@ func (ctn *Container) init(ctx context.Context) error {
 	for _, warn := range cbody.Warnings {
 		ctn.logger.Warn().Msg(warn)
 	}
+	return nil
+}
 
-	if err := ctn.cli.ContainerStart(ctx, ctn.id, container.StartOptions{}); err != nil {
-		return err
+// waitRunning waits until the container is running, so that commands can be executed in it
+func (ctn *Container) waitRunning(ctx context.Context) error {
+	for i := 0; ; i++ {
+		inspect, err := ctn.cli.ContainerInspect(ctx, ctn.id)
+		if err != nil {
+			return err
+		}
+		if inspect.State.Running {
+			return nil
+		} else if inspect.State.Status == "exited" || inspect.State.Status == "dead" {
+			return fmt.Errorf("container exited unexpectedly with code %d: %s", inspect.State.ExitCode, inspect.State.Error)
+		} else if i >= 100 {
+			return fmt.Errorf("container is not running, status: %s", inspect.State.Status)
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-
-	// TODO: download files for sources if specified
-
-	return nil
 }
 
-func (ctn *Container) run(ctx context.Context, cmd []string) (*types.HijackedResponse, error) {
+// run executes cmd inside the container without tty, so that stdout and stderr
+// are multiplexed in the hijacked stream and can be separated by stdcopy.
+// The exec is started by attaching to it, so no output is lost even for very short commands.
+func (ctn *Container) run(ctx context.Context, cmd []string) (string, *types.HijackedResponse, error) {
 	res, err := ctn.cli.ContainerExecCreate(ctx, ctn.id, container.ExecOptions{
 		AttachStderr: true,
 		AttachStdout: true,
 		Cmd:          cmd,
 	})
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	hijack, err := ctn.cli.ContainerExecAttach(ctx, res.ID, container.ExecStartOptions{
-		Tty: true,
+		Tty: false,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return res.ID, &hijack, nil
+}
+
+// runMain creates the container with cmd as its main process and starts it, processes are restored
+// from the checkpoint in checkpointDir if checkpointId is not empty. Its output is multiplexed like run,
+// and the returned wait function blocks until the main process exits and returns its exit code.
+func (ctn *Container) runMain(ctx context.Context, cmd []string, checkpointDir string, checkpointId string) (*types.HijackedResponse, func() (int, error), error) {
+	if err := ctn.remove(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := ctn.create(ctx, cmd); err != nil {
+		return nil, nil, err
+	}
+	// attach and wait before starting, so that neither output nor exit is missed
+	hijack, err := ctn.cli.ContainerAttach(ctx, ctn.id, container.AttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	waitCh, errCh := ctn.cli.ContainerWait(ctx, ctn.id, container.WaitConditionNextExit)
+	options := container.StartOptions{}
+	if checkpointId != "" {
+		options.CheckpointID = checkpointId
+		options.CheckpointDir = checkpointDir
+	}
+	if err := ctn.cli.ContainerStart(ctx, ctn.id, options); err != nil {
+		hijack.Close()
+		return nil, nil, err
+	}
+	wait := func() (int, error) {
+		select {
+		case resp := <-waitCh:
+			if resp.Error != nil {
+				return 0, errors.New(resp.Error.Message)
+			}
+			return int(resp.StatusCode), nil
+		case err := <-errCh:
+			return 0, err
+		}
+	}
+	return &hijack, wait, nil
+}
+
+// checkpoint saves processes of container into checkpointDir by docker checkpoint, the container keeps running
+func (ctn *Container) checkpoint(ctx context.Context, checkpointDir string, checkpointId string) error {
+	return ctn.cli.CheckpointCreate(ctx, ctn.id, checkpoint.CreateOptions{
+		CheckpointID:  checkpointId,
+		CheckpointDir: checkpointDir,
+	})
+}
+
+func (ctn *Container) exitCode(ctx context.Context, execId string) (int, error) {
+	// the exec may still be marked as running for a short while after its output is closed
+	for i := 0; ; i++ {
+		inspect, err := ctn.cli.ContainerExecInspect(ctx, execId)
+		if err != nil {
+			return 0, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		} else if i >= 50 {
+			return 0, fmt.Errorf("exec %s is still running", execId)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// remove force removes the docker container, a new one will be created by init when needed
+func (ctn *Container) remove(ctx context.Context) error {
+	if ctn.id == "" {
+		return nil
+	}
+	if err := ctn.cli.ContainerRemove(ctx, ctn.id, container.RemoveOptions{Force: true}); err != nil {
+		return err
 	}
-	return &hijack, nil
+	ctn.id = ""
+	ctn.paused.Store(false)
+	return nil
+}
+
+// pause freezes processes of the container, e.g. when the host is no longer available for jobs
+func (ctn *Container) pause(ctx context.Context) error {
+	if ctn.id == "" || !ctn.paused.CompareAndSwap(false, true) {
+		return nil
+	}
+	if err := ctn.cli.ContainerPause(ctx, ctn.id); err != nil {
+		ctn.paused.Store(false)
+		return err
+	}
+	return nil
+}
+
+func (ctn *Container) unpause(ctx context.Context) error {
+	if ctn.id == "" || !ctn.paused.CompareAndSwap(true, false) {
+		return nil
+	}
+	if err := ctn.cli.ContainerUnpause(ctx, ctn.id); err != nil {
+		ctn.paused.Store(true)
+		return err
+	}
+	return nil
+}
+
+// cpuTime returns the total cpu time used by processes of the container
+func (ctn *Container) cpuTime(ctx context.Context) (time.Duration, error) {
+	if ctn.id == "" {
+		return 0, nil
+	}
+	resp, err := ctn.cli.ContainerStatsOneShot(ctx, ctn.id)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var stats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, err
+	}
+	return time.Duration(stats.CPUStats.CPUUsage.TotalUsage), nil
 }
 
 func (ctn *Container) dataDir() string {
@@ -143,6 +352,10 @@ func (ctn *Container) outputDir() string {
 	return filepath.Join(ctn.dir, "output")
 }
 
+func (ctn *Container) checkpointDir() string {
+	return filepath.Join(ctn.dir, "checkpoint")
+}
+
 func stopCurrentRunningContainers(ctx context.Context, client *client.Client) error {
 	filter := filters.NewArgs(filters.Arg("label", "run.sath.starter"))
 	containers, err := client.ContainerList(ctx, container.ListOptions{
@@ -11,7 +11,9 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/registry"
 	"github.com/pkg/errors"
+	"github.com/sath-run/engine/meta"
 	"github.com/sath-run/engine/utils"
 )
 
@@ -38,6 +40,10 @@ type Config struct {
 	GrpcAddress string
 	SSL         bool
 	DataDir     string
+	Scheduler   SchedulerConfig
+
+	// how often system info is probed again to report changes to server
+	SystemInfoInterval time.Duration
 }
 
 func Default(ctx context.Context, config *Config) (*Core, error) {
@@ -66,7 +72,9 @@ func Default(ctx context.Context, config *Config) (*Core, error) {
 	}
 
 	core.hb = NewHeartbeat(core.c)
-	core.scheduler, err = NewScheduler(ctx, core.c, core.localDataDir, time.Second*30)
+	schedulerConfig := config.Scheduler
+	schedulerConfig.JobInterval = time.Second * 30
+	core.scheduler, err = NewScheduler(ctx, core.c, core.localDataDir, &schedulerConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +82,13 @@ func Default(ctx context.Context, config *Config) (*Core, error) {
 		log.Fatal().Err(err).Send()
 	}
 
+	interval := config.SystemInfoInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	// ctx only limits initialization, watching lasts as long as the engine
+	go core.watchSystemInfo(context.Background(), interval)
+
 	if u := core.c.User(); u != nil {
 		core.Start()
 	} else {
@@ -93,6 +108,23 @@ func (core *Core) Pause() error {
 	return nil
 }
 
+// PauseJobs pauses jobs by ids. If ids is empty, all jobs are paused and no new job is fetched.
+func (core *Core) PauseJobs(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		core.Pause()
+	}
+	return core.scheduler.PauseJobs(ids)
+}
+
+// ResumeJobs resumes jobs by ids. If ids is empty, all jobs are resumed and new jobs are fetched again.
+func (core *Core) ResumeJobs(ids []string) ([]string, error) {
+	paused, err := core.scheduler.ResumeJobs(ids)
+	if err == nil && len(ids) == 0 && core.c.User() != nil {
+		core.Start()
+	}
+	return paused, err
+}
+
 func (core *Core) Stop(waitTillJobDone bool) error {
 	return nil
 }
@@ -211,6 +243,79 @@ func (core *Core) Status() string {
 	}
 }
 
+// Availability returns verdicts of availability policy, nil if no rule is configured or it has not been evaluated
+func (core *Core) Availability() []PolicyVerdict {
+	return core.scheduler.PolicyVerdicts()
+}
+
+// Prefetch returns images and resources prefetched while the host is idle
+func (core *Core) Prefetch() PrefetchStatus {
+	return core.scheduler.PrefetchStatus()
+}
+
+func (core *Core) Jobs() []JobStatus {
+	return core.scheduler.Jobs()
+}
+
+// JobBenchmark returns timings of recently succeeded jobs, including the overhead of engine
+func (core *Core) JobBenchmark() JobBenchmark {
+	return core.scheduler.JobBenchmark()
+}
+
+// Images returns images pulled by engine
+func (core *Core) Images(ctx context.Context) ([]ImageStatus, error) {
+	return core.scheduler.Images(ctx)
+}
+
+// PruneImages removes images exceeding the disk budget, or all images not in use if all is true
+func (core *Core) PruneImages(ctx context.Context, all bool) ([]ImageStatus, error) {
+	budget := core.scheduler.config.ImageDiskBudget
+	if all {
+		budget = 0
+	} else if budget <= 0 {
+		return []ImageStatus{}, nil
+	}
+	return core.scheduler.PruneImages(ctx, budget)
+}
+
+// RegistryLogin verifies a credential of registry by docker, and stores it for pulling images from the registry
+func (core *Core) RegistryLogin(ctx context.Context, credential meta.RegistryCredential) error {
+	resp, err := core.scheduler.cli.RegistryLogin(ctx, registry.AuthConfig{
+		Username:      credential.Username,
+		Password:      credential.Password,
+		ServerAddress: credential.Registry,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.IdentityToken != "" {
+		// the token is used instead of password afterwards
+		credential.IdentityToken, credential.Password = resp.IdentityToken, ""
+	}
+	return meta.PutRegistryCredential(credential)
+}
+
+// RegistryLogout removes the stored credential of registry
+func (core *Core) RegistryLogout(domain string) error {
+	if _, err := meta.GetRegistryCredential(domain); err != nil {
+		return err
+	}
+	return meta.RemoveRegistryCredential(domain)
+}
+
+// Registries returns registries whose credentials are stored
+func (core *Core) Registries() ([]meta.RegistryCredential, error) {
+	return meta.GetRegistryCredentials()
+}
+
+func (core *Core) HasJobLogs(id string) bool {
+	return core.scheduler.HasJobLogs(id)
+}
+
+func (core *Core) JobLogs(ctx context.Context, id string, options *LogOptions, fn func(LogEntry) error) error {
+	return core.scheduler.JobLogs(ctx, id, options, fn)
+}
+
 func (core *Core) Login(account string, password string) error {
 	ctx := core.c.AppendToOutgoingContext(context.TODO(), nil)
 	return core.c.Login(ctx, account, password)
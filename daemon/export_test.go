@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+// internals exported for tests of package daemon_test
+
+const MaxLogLineSize = maxLogLineSize
+
+var (
+	OpenRotatingFile = openRotatingFile
+	NewTailBuffer    = newTailBuffer
+	ReadJobLogs      = readJobLogs
+	PruneJobLogs     = pruneJobLogs
+	LatestJobLogs    = latestJobLogs
+	RecordImage      = recordImage
+)
+
+func NewLineWriter(fn func(line string)) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+func NewNotifier(send func(*pb.ExecNotificationRequest) error, interval time.Duration, maxLines int) *notifier {
+	return newNotifier(send, interval, maxLines, zerolog.Nop())
+}
+
+func (n *notifier) NotifyState(req *pb.ExecNotificationRequest) error { return n.notifyState(req) }
+func (n *notifier) NotifyProgress(req *pb.ExecNotificationRequest) error {
+	return n.notifyProgress(req)
+}
+func (n *notifier) NotifyGpuStats(req *pb.ExecNotificationRequest) error {
+	return n.notifyGpuStats(req)
+}
+func (n *notifier) NotifyLog(req *pb.ExecNotificationRequest) error { return n.notifyLog(req) }
+func (n *notifier) Close() error                                    { return n.close() }
+
+const ProgressFileName = progressFileName
+
+var (
+	ParseProgressLine = parseProgressLine
+	ReadProgressFile  = readProgressFile
+	AmdRenderNode     = amdRenderNode
+)
+
+// NewTestJob returns a job which is not connected to server nor docker
+func NewTestJob(config *SchedulerConfig) *Job {
+	job := &Job{
+		config:    config.withDefaults(),
+		metadata:  &pb.JobGetResponse{JobId: "test", Image: &pb.Image{}},
+		createdAt: time.Now(),
+		finished:  make(chan struct{}),
+		logger:    zerolog.Nop(),
+	}
+	job.notifier = newNotifier(func(*pb.ExecNotificationRequest) error { return nil }, time.Millisecond, 1, job.logger)
+	job.lastActivity.Store(time.Now().UnixNano())
+	return job
+}
+
+func (job *Job) StageContext(stage string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return job.stageContext(stage, timeout)
+}
+
+func (job *Job) WatchTask(ctx context.Context, stall context.CancelCauseFunc, done <-chan struct{}, kill func()) {
+	job.watchTask(ctx, stall, done, kill)
+}
+
+// Touch records output of the task
+func (job *Job) Touch() {
+	job.lastActivity.Store(time.Now().UnixNano())
+}
+
+// GpuUnsatisfiable reports whether a job of conf can never run on a device with gpus of info
+func GpuUnsatisfiable(info *pb.GpuInfo, conf *pb.GpuConf) bool {
+	return newGpuAllocator(gpuDevices(info)).unsatisfiable(conf)
+}
+
+// Fail records err of the job as its stages do when they fail
+func (job *Job) Fail(err error) {
+	job.setErr(err)
+}
+
+// Detach tells the downloader that job stops waiting for it
+func (dld *Downloader) Detach(job *Job) {
+	dld.detach(job)
+}
@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+var ErrNoMatchingGpu = errors.New("no gpu of this device matches the requirement of job")
+
+// gpuDevice is a GPU that can be assigned to one container at a time
+type gpuDevice struct {
+	// index of amd card, which is used by ROCR_VISIBLE_DEVICES
+	Index       int
+	Uuid        string
+	Model       pb.GpuModel
+	Vram        uint64
+	ProductName string
+
+	assigned bool
+	// gone is set if the device is no longer detected while it is assigned
+	gone bool
+}
+
+// gpuAllocator tracks GPUs of the device and whether each of them is assigned to a container
+type gpuAllocator struct {
+	mu      sync.Mutex
+	devices []*gpuDevice
+}
+
+func newGpuAllocator(devices []*gpuDevice) *gpuAllocator {
+	return &gpuAllocator{devices: devices}
+}
+
+// gpuDevices converts gpus detected by GpuCollector into allocatable devices
+func gpuDevices(info *pb.GpuInfo) []*gpuDevice {
+	devices := []*gpuDevice{}
+	for _, gpu := range info.GetGpus() {
+		dev := &gpuDevice{
+			Uuid:        gpu.Uuid,
+			Model:       pb.GpuModel_EGM_NVIDIA,
+			Vram:        gpu.Vram,
+			ProductName: gpu.ProductName,
+		}
+		if gpu.Vendor == "amd" {
+			dev.Model = pb.GpuModel_EGM_AMD
+			dev.Index, _ = strconv.Atoi(strings.TrimPrefix(gpu.Id, "card"))
+		}
+		devices = append(devices, dev)
+	}
+	return devices
+}
+
+// gpuMatches reports whether the device satisfies the vram and model requirement of conf,
+// vram of both is in bytes
+func gpuMatches(dev *gpuDevice, conf *pb.GpuConf) bool {
+	if conf == nil {
+		return true
+	}
+	if conf.Vram > 0 && dev.Vram < conf.Vram {
+		return false
+	}
+	if len(conf.Model) == 0 || slices.Contains(conf.Model, pb.GpuModel_EGM_All) {
+		return true
+	}
+	return slices.Contains(conf.Model, dev.Model)
+}
+
+func (a *gpuAllocator) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	count := 0
+	for _, dev := range a.devices {
+		if !dev.gone {
+			count++
+		}
+	}
+	return count
+}
+
+// present reports whether the device is still detected
+func (a *gpuAllocator) present(dev *gpuDevice) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !dev.gone
+}
+
+// update replaces devices with newly detected ones. Devices which are still present keep
+// their assignment, and devices which are gone are removed once they are released.
+func (a *gpuAllocator) update(devices []*gpuDevice) (added []string, removed []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	detected := map[string]*gpuDevice{}
+	for _, dev := range devices {
+		detected[dev.Uuid] = dev
+	}
+	kept := []*gpuDevice{}
+	for _, dev := range a.devices {
+		if _, ok := detected[dev.Uuid]; ok {
+			delete(detected, dev.Uuid)
+			dev.gone = false
+			kept = append(kept, dev)
+			continue
+		}
+		if !dev.gone {
+			removed = append(removed, dev.Uuid)
+		}
+		if dev.assigned {
+			dev.gone = true
+			kept = append(kept, dev)
+		}
+	}
+	for _, dev := range devices {
+		if _, ok := detected[dev.Uuid]; ok {
+			added = append(added, dev.Uuid)
+			kept = append(kept, dev)
+		}
+	}
+	a.devices = kept
+	return added, removed
+}
+
+// hasMatching reports whether any device, assigned or not, satisfies conf
+func (a *gpuAllocator) hasMatching(conf *pb.GpuConf) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, dev := range a.devices {
+		if !dev.gone && gpuMatches(dev, conf) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsatisfiable reports whether conf requires a gpu which no device satisfies, so the job can never run here
+func (a *gpuAllocator) unsatisfiable(conf *pb.GpuConf) bool {
+	return conf != nil && conf.Opt == pb.GpuOpt_EGO_REQUIRED && !a.hasMatching(conf)
+}
+
+// allocate assigns a free device satisfying conf, it returns nil if there is none
+func (a *gpuAllocator) allocate(conf *pb.GpuConf) *gpuDevice {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var best *gpuDevice
+	for _, dev := range a.devices {
+		if dev.assigned || dev.gone || !gpuMatches(dev, conf) {
+			continue
+		}
+		// prefer the smallest device which fits, so larger ones are left for demanding jobs
+		if best == nil || dev.Vram < best.Vram {
+			best = dev
+		}
+	}
+	if best != nil {
+		best.assigned = true
+	}
+	return best
+}
+
+func (a *gpuAllocator) release(dev *gpuDevice) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	dev.assigned = false
+	if dev.gone {
+		a.devices = slices.DeleteFunc(a.devices, func(d *gpuDevice) bool {
+			return d == dev
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+// gpuMonitor periodically samples utilization, memory, temperature, power and clocks of nvidia gpus
+type gpuMonitor struct {
+	interval time.Duration
+	sample   func() ([]*pb.GpuStats, error)
+
+	mu    sync.Mutex
+	stats map[string]*pb.GpuStats
+}
+
+func newGpuMonitor(interval time.Duration, sample func() ([]*pb.GpuStats, error)) *gpuMonitor {
+	return &gpuMonitor{
+		interval: interval,
+		sample:   sample,
+		stats:    map[string]*pb.GpuStats{},
+	}
+}
+
+func (m *gpuMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.update()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *gpuMonitor) update() {
+	samples, err := m.sample()
+	if err != nil {
+		log.Debug().Err(err).Msg("fail to sample gpu stats")
+		return
+	}
+	stats := map[string]*pb.GpuStats{}
+	for _, s := range samples {
+		stats[s.Uuid] = s
+	}
+	m.mu.Lock()
+	m.stats = stats
+	m.mu.Unlock()
+}
+
+// get returns the latest stats of the gpu, or nil if it has not been sampled
+func (m *gpuMonitor) get(uuid string) *pb.GpuStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats[uuid]
+}
+
+// temperature returns the highest temperature of gpus in celsius, 0 if not sampled
+func (m *gpuMonitor) temperature() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	highest := uint32(0)
+	for _, s := range m.stats {
+		highest = max(highest, s.Temperature)
+	}
+	return float64(highest)
+}
+
+func gpuStatsFromInfo(info *GPUInfo) []*pb.GpuStats {
+	now := time.Now()
+	stats := []*pb.GpuStats{}
+	for i, gpu := range info.Gpus {
+		id, err := strconv.Atoi(gpu.MinorNumber)
+		if err != nil {
+			id = i
+		}
+		stats = append(stats, &pb.GpuStats{
+			Id:                int32(id),
+			Uuid:              gpu.Uuid,
+			Utilization:       parsePercent(gpu.Utilization.Gpu),
+			MemoryUtilization: parsePercent(gpu.Utilization.Memory),
+			MemoryUsed:        parseMemory(gpu.FbMemoryUsage.Used),
+			MemoryTotal:       parseMemory(gpu.FbMemoryUsage.Total),
+			Temperature:       parseTemperature(gpu.Temperature.Gpu),
+			PowerDraw:         gpu.powerDraw(),
+			PowerLimit:        gpu.powerLimit(),
+			Clocks:            gpuClocks(gpu.Uuid, gpu.Clocks),
+			Timestamp:         now.UnixMilli(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,65 @@
+package daemon_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+func TestGpuUnsatisfiable(t *testing.T) {
+	const gib = 1 << 30
+	info := &pb.GpuInfo{Gpus: []*pb.Gpu{
+		{Id: "0", Uuid: "GPU-0", Vendor: "nvidia", Vram: 24 * gib},
+		{Id: "card1", Vendor: "amd", Vram: 16 * gib},
+	}}
+	tests := []struct {
+		name string
+		info *pb.GpuInfo
+		conf *pb.GpuConf
+		want bool
+	}{
+		{"no requirement", info, nil, false},
+		{"cpu job", &pb.GpuInfo{}, &pb.GpuConf{Opt: pb.GpuOpt_EGO_None}, false},
+		{"preferred without gpu", &pb.GpuInfo{}, &pb.GpuConf{Opt: pb.GpuOpt_EGO_PREFERRED}, false},
+		{"required without gpu", &pb.GpuInfo{}, &pb.GpuConf{Opt: pb.GpuOpt_EGO_REQUIRED}, true},
+		{"required any gpu", info, &pb.GpuConf{Opt: pb.GpuOpt_EGO_REQUIRED}, false},
+		{"required vram in bytes", info, &pb.GpuConf{Opt: pb.GpuOpt_EGO_REQUIRED, Vram: 20 * gib}, false},
+		{"required too much vram", info, &pb.GpuConf{Opt: pb.GpuOpt_EGO_REQUIRED, Vram: 32 * gib}, true},
+		{"required model", info, &pb.GpuConf{Opt: pb.GpuOpt_EGO_REQUIRED, Model: []pb.GpuModel{pb.GpuModel_EGM_AMD}}, false},
+		{"required model with too little vram", info, &pb.GpuConf{
+			Opt: pb.GpuOpt_EGO_REQUIRED, Vram: 20 * gib, Model: []pb.GpuModel{pb.GpuModel_EGM_AMD},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := daemon.GpuUnsatisfiable(tt.info, tt.conf); got != tt.want {
+				t.Errorf("GpuUnsatisfiable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmdRenderNode(t *testing.T) {
+	sysfs := t.TempDir()
+	for card, render := range map[string]string{"card0": "renderD128", "card1": "renderD129"} {
+		checkErr(os.MkdirAll(filepath.Join(sysfs, "class/drm", card, "device/drm", render), os.ModePerm))
+	}
+	tests := []struct {
+		index int
+		want  string
+		ok    bool
+	}{
+		{0, "/dev/dri/renderD128", true},
+		{1, "/dev/dri/renderD129", true},
+		{2, "", false},
+	}
+	for _, tt := range tests {
+		got, err := daemon.AmdRenderNode(sysfs, tt.index)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("AmdRenderNode(%d) = %q, %v, want %q", tt.index, got, err, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package daemon
 import (
 	"context"
 	"crypto/tls"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	"github.com/sath-run/engine/constants"
@@ -11,9 +12,11 @@ import (
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type Connection struct {
@@ -21,6 +24,11 @@ type Connection struct {
 	deviceId    string
 	deviceToken string
 	user        *User
+
+	// mu guards device token and system info, which are updated when system info changes
+	mu sync.Mutex
+	// system info last reported to server
+	systemInfo *pb.SystemInfo
 }
 
 type User struct {
@@ -61,14 +69,16 @@ func NewConnectionWithClient(client pb.EngineClient) (*Connection, error) {
 	ctx := c.AppendToOutgoingContext(context.TODO(), nil)
 
 	// get or refresh device token from server
+	systemInfo := GetSystemInfo()
 	resp, err := c.HandShake(ctx, &pb.HandShakeRequest{
-		SystemInfo: GetSystemInfo(),
+		SystemInfo: systemInfo,
 	})
 	if err != nil {
 		return nil, err
 	}
 	c.deviceToken = resp.Token
 	c.deviceId = resp.DeviceId
+	c.systemInfo = systemInfo
 
 	if err := meta.SetCredentialDeviceToken(c.deviceToken); err != nil {
 		return nil, err
@@ -94,11 +104,48 @@ func (c *Connection) AppendToOutgoingContext(ctx context.Context, user *User, kv
 	} else if u := c.user; u != nil {
 		kv = append(kv, "authorization", u.token)
 	} else {
+		c.mu.Lock()
 		kv = append(kv, "authorization", c.deviceToken)
+		c.mu.Unlock()
 	}
 	return metadata.AppendToOutgoingContext(ctx, kv...)
 }
 
+// SystemInfo returns the system info last reported to server
+func (c *Connection) SystemInfo() *pb.SystemInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.systemInfo
+}
+
+// UpdateSystemInfo reports changed system info to server, servers which do not
+// implement UpdateSystemInfo are updated by handshaking again
+func (c *Connection) UpdateSystemInfo(ctx context.Context, info *pb.SystemInfo, changes []string) error {
+	_, err := c.EngineClient.UpdateSystemInfo(c.AppendToOutgoingContext(ctx, nil), &pb.SystemInfoUpdateRequest{
+		SystemInfo: info,
+		Changes:    changes,
+	})
+	if status.Code(err) == codes.Unimplemented {
+		var resp *pb.HandShakeResponse
+		resp, err = c.HandShake(c.AppendToOutgoingContext(ctx, nil), &pb.HandShakeRequest{
+			SystemInfo: info,
+		})
+		if err == nil {
+			c.mu.Lock()
+			c.deviceToken = resp.Token
+			c.mu.Unlock()
+			err = meta.SetCredentialDeviceToken(resp.Token)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.systemInfo = info
+	c.mu.Unlock()
+	return nil
+}
+
 func (c *Connection) Login(ctx context.Context, username string, password string) error {
 	res, err := c.EngineClient.Login(ctx, &pb.LoginRequest{
 		Account:  username,
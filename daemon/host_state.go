@@ -0,0 +1,192 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// sensors of these drivers measure the temperature of cpu
+var cpuSensors = []string{"coretemp", "k10temp", "zenpower", "cpu_thermal", "x86_pkg_temp"}
+
+// HostState is the state of the host which policies are evaluated against
+type HostState struct {
+	Time time.Time
+	// percentage of cpu used by processes other than jobs, negative if unknown
+	CpuUsage float64
+	// interactive sessions of users
+	Sessions    []Session
+	SessionsErr error
+	// false only if the host has a battery and no AC adapter is online
+	OnAC     bool
+	PowerErr error
+	// highest temperatures of cpu and gpus in celsius, 0 if unknown
+	CpuTemperature float64
+	GpuTemperature float64
+}
+
+type Session struct {
+	Id        string
+	User      string
+	Idle      bool
+	IdleSince time.Time
+}
+
+// HostSensor reads the state of the host
+type HostSensor struct {
+	Probe *Probe
+	// period of sampling cpu usage, 0 to skip it
+	CpuWindow time.Duration
+	// JobsCpu returns total cpu time used by running jobs, it may be nil if no job runs
+	JobsCpu func(ctx context.Context) time.Duration
+	// GpuTemperature returns the highest temperature of gpus, it may be nil if there is no gpu
+	GpuTemperature func() float64
+}
+
+func (s *HostSensor) Read(ctx context.Context) *HostState {
+	state := &HostState{CpuUsage: -1}
+	if s.CpuWindow > 0 {
+		state.CpuUsage = s.cpuUsage(ctx)
+	}
+	state.Time = time.Now()
+	state.Sessions, state.SessionsErr = s.sessions()
+	state.OnAC, state.PowerErr = s.onAC()
+	state.CpuTemperature = s.cpuTemperature(ctx)
+	if s.GpuTemperature != nil {
+		state.GpuTemperature = s.GpuTemperature()
+	}
+	return state
+}
+
+// cpuUsage samples cpu times of the host and jobs for a window, and returns the percentage used by others
+func (s *HostSensor) cpuUsage(ctx context.Context) float64 {
+	sample := func() (busy float64, total float64, jobs time.Duration, err error) {
+		times, err := cpu.TimesWithContext(s.Probe.context(ctx), false)
+		if err != nil || len(times) == 0 {
+			return 0, 0, 0, errors.Join(err, errors.New("no cpu times"))
+		}
+		t := times[0]
+		total = t.User + t.Nice + t.System + t.Idle + t.Iowait + t.Irq + t.Softirq + t.Steal
+		busy = total - t.Idle - t.Iowait
+		if s.JobsCpu != nil {
+			jobs = s.JobsCpu(ctx)
+		}
+		return busy, total, jobs, nil
+	}
+	busy0, total0, jobs0, err := sample()
+	if err != nil {
+		return -1
+	}
+	select {
+	case <-time.After(s.CpuWindow):
+	case <-ctx.Done():
+		return -1
+	}
+	busy1, total1, jobs1, err := sample()
+	if err != nil || total1 <= total0 {
+		return -1
+	}
+	others := (busy1 - busy0) - (jobs1 - jobs0).Seconds()
+	return min(max(others/(total1-total0)*100, 0), 100)
+}
+
+// sessions lists sessions of users logged in locally or remotely through logind,
+// their idle hints are set by desktop environments and terminals
+func (s *HostSensor) sessions() ([]Session, error) {
+	out, err := s.Probe.Command("loginctl", "list-sessions", "--no-legend")
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		out, err := s.Probe.Command("loginctl", "show-session", fields[0],
+			"-p", "Id", "-p", "Name", "-p", "Class", "-p", "IdleHint", "-p", "IdleSinceHint")
+		if err != nil {
+			return nil, err
+		}
+		if session, ok := parseLoginctlSession(out); ok {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// parseLoginctlSession parses properties of a session, it returns false if it is not a session of user, e.g. a greeter
+func parseLoginctlSession(out []byte) (Session, bool) {
+	props := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			props[key] = value
+		}
+	}
+	if props["Class"] != "user" {
+		return Session{}, false
+	}
+	session := Session{
+		Id:   props["Id"],
+		User: props["Name"],
+		Idle: props["IdleHint"] == "yes",
+	}
+	if usec, err := strconv.ParseInt(props["IdleSinceHint"], 10, 64); err == nil && usec > 0 {
+		session.IdleSince = time.UnixMicro(usec)
+	}
+	return session, true
+}
+
+// onAC reads power supplies, a host without battery, e.g. a desktop, is always on AC
+func (s *HostSensor) onAC() (bool, error) {
+	dir := s.Probe.path("sys", "class", "power_supply")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	battery := false
+	for _, entry := range entries {
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(dir, entry.Name(), name))
+			return strings.TrimSpace(string(data))
+		}
+		switch read("type") {
+		case "Mains", "USB":
+			if read("online") == "1" {
+				return true, nil
+			}
+		case "Battery":
+			// peripherals like wireless mice also report batteries
+			if read("scope") != "Device" {
+				battery = true
+			}
+		}
+	}
+	return !battery, nil
+}
+
+func (s *HostSensor) cpuTemperature(ctx context.Context) float64 {
+	// warnings of unreadable sensors are ignored, the rest are still useful
+	temperatures, _ := host.SensorsTemperaturesWithContext(s.Probe.context(ctx))
+	highest := 0.0
+	for _, t := range temperatures {
+		for _, name := range cpuSensors {
+			if strings.HasPrefix(t.SensorKey, name) {
+				highest = max(highest, t.Temperature)
+			}
+		}
+	}
+	return highest
+}
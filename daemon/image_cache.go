@@ -0,0 +1,192 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/sath-run/engine/constants"
+	"github.com/sath-run/engine/meta"
+)
+
+// ImageStatus is an image pulled by engine
+type ImageStatus struct {
+	meta.Image
+	// used by a container or a current job, so it is never removed
+	InUse bool
+}
+
+// SelectImagesToEvict returns images to remove, least recently used first, so that the total size fits in budget.
+// Images in use are never selected, even if the budget is still exceeded.
+func SelectImagesToEvict(images []ImageStatus, budget int64) []ImageStatus {
+	refs, total := imageRefs(images)
+	candidates := slices.Clone(images)
+	slices.SortStableFunc(candidates, func(a, b ImageStatus) int {
+		return a.LastUsed.Compare(b.LastUsed)
+	})
+	evict := []ImageStatus{}
+	for _, image := range candidates {
+		if total <= budget {
+			break
+		} else if image.InUse {
+			continue
+		}
+		evict = append(evict, image)
+		// space is only freed once the last reference is removed
+		if refs[image.Id]--; refs[image.Id] == 0 {
+			total -= image.Size
+		}
+	}
+	return evict
+}
+
+// ImagesSize returns the disk size taken by images
+func ImagesSize(images []ImageStatus) int64 {
+	_, total := imageRefs(images)
+	return total
+}
+
+// imageRefs counts references of each image by id, and returns the total size of images.
+// An image may be pulled by more than one reference, its size only counts once.
+func imageRefs(images []ImageStatus) (map[string]int, int64) {
+	refs := map[string]int{}
+	var total int64
+	for _, image := range images {
+		if refs[image.Id]++; refs[image.Id] == 1 {
+			total += image.Size
+		}
+	}
+	return refs, total
+}
+
+// recordImage records the image of a job with its size and the time it is used, and also the time it is pulled
+// if pulled is true. Only images pulled by engine are recorded, others are never collected, e.g. images of user.
+func recordImage(ctx context.Context, cli *client.Client, ref string, pulled bool) error {
+	record, err := meta.GetImage(ref)
+	if errors.Is(err, constants.ErrNil) && !pulled {
+		return nil
+	} else if err != nil && !errors.Is(err, constants.ErrNil) {
+		return err
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return err
+	}
+	record.Ref = ref
+	record.Id = inspect.ID
+	record.Size = inspect.Size
+	record.LastUsed = time.Now()
+	if pulled {
+		record.PulledAt = record.LastUsed
+	}
+	return meta.PutImage(record)
+}
+
+// imagesInUse returns references and ids of images used by containers of engine or by current jobs
+func (scheduler *Scheduler) imagesInUse(ctx context.Context) (map[string]bool, error) {
+	containers, err := scheduler.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", "run.sath.starter")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[string]bool{}
+	for _, c := range containers {
+		inUse[c.Image] = true
+		inUse[c.ImageID] = true
+	}
+	// images of jobs may be pulled but not yet used by any container
+	for _, job := range scheduler.allJobs() {
+		inUse[job.imageRef()] = true
+		inUse[job.runImageRef()] = true
+	}
+	return inUse, nil
+}
+
+// Images returns images pulled by engine, most recently used first. Images removed outside of engine are forgotten.
+func (scheduler *Scheduler) Images(ctx context.Context) ([]ImageStatus, error) {
+	records, err := meta.GetImages()
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := scheduler.imagesInUse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	images := []ImageStatus{}
+	for _, record := range records {
+		inspect, _, err := scheduler.cli.ImageInspectWithRaw(ctx, record.Ref)
+		if client.IsErrNotFound(err) {
+			if err := meta.RemoveImage(record.Ref); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// the reference may have been pulled again outside of engine
+		if inspect.ID != record.Id || inspect.Size != record.Size {
+			record.Id, record.Size = inspect.ID, inspect.Size
+			if err := meta.PutImage(record); err != nil {
+				return nil, err
+			}
+		}
+		images = append(images, ImageStatus{
+			Image: record,
+			InUse: inUse[record.Ref] || inUse[record.Id],
+		})
+	}
+	slices.SortFunc(images, func(a, b ImageStatus) int {
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+	return images, nil
+}
+
+// PruneImages removes images pulled by engine which are not in use, least recently used first,
+// until the rest fit in budget. It returns removed images.
+func (scheduler *Scheduler) PruneImages(ctx context.Context, budget int64) ([]ImageStatus, error) {
+	scheduler.pruneLock.Lock()
+	defer scheduler.pruneLock.Unlock()
+	images, err := scheduler.Images(ctx)
+	if err != nil {
+		return nil, err
+	}
+	removed := []ImageStatus{}
+	for _, img := range SelectImagesToEvict(images, budget) {
+		_, err := scheduler.cli.ImageRemove(ctx, img.Ref, image.RemoveOptions{PruneChildren: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			// e.g. the image is also used by containers not created by engine
+			scheduler.logger.Warn().Err(err).Str("image", img.Ref).Msg("fail to remove image")
+			continue
+		}
+		if err := meta.RemoveImage(img.Ref); err != nil {
+			return removed, err
+		}
+		removed = append(removed, img)
+		scheduler.logger.Info().Str("image", img.Ref).Int64("size", img.Size).Msg("image removed")
+	}
+	return removed, nil
+}
+
+// collectImages removes least recently used images every interval to keep them in the disk budget
+func (scheduler *Scheduler) collectImages(interval time.Duration) {
+	if scheduler.config.ImageDiskBudget <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if _, err := scheduler.PruneImages(ctx, scheduler.config.ImageDiskBudget); err != nil {
+			scheduler.logger.Warn().Err(err).Msg("fail to collect images")
+		}
+		cancel()
+		<-ticker.C
+	}
+}
@@ -0,0 +1,94 @@
+package daemon_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/constants"
+	"github.com/sath-run/engine/daemon"
+	"github.com/sath-run/engine/meta"
+)
+
+func TestSelectImagesToEvict(t *testing.T) {
+	now := time.Now()
+	image := func(ref string, id string, size int64, age time.Duration, inUse bool) daemon.ImageStatus {
+		return daemon.ImageStatus{
+			Image: meta.Image{Ref: ref, Id: id, Size: size, LastUsed: now.Add(-age)},
+			InUse: inUse,
+		}
+	}
+	images := []daemon.ImageStatus{
+		image("amber:22", "sha256:a", 8, time.Hour, false),
+		image("gromacs:2024", "sha256:g", 6, 48*time.Hour, false),
+		image("gromacs:latest", "sha256:g", 6, 24*time.Hour, false),
+		image("openmm:8", "sha256:o", 4, 72*time.Hour, true),
+		image("vina:1.2", "sha256:v", 2, 12*time.Hour, false),
+	}
+	tests := []struct {
+		budget int64
+		evict  []string
+	}{
+		// 20 in total, shared layers of gromacs only count once
+		{20, []string{}},
+		// openmm is the oldest but in use, both references of gromacs are needed to free its space
+		{14, []string{"gromacs:2024", "gromacs:latest"}},
+		{12, []string{"gromacs:2024", "gromacs:latest", "vina:1.2"}},
+		// images in use are kept even if the budget is exceeded
+		{0, []string{"gromacs:2024", "gromacs:latest", "vina:1.2", "amber:22"}},
+	}
+	for _, tt := range tests {
+		evict := []string{}
+		for _, image := range daemon.SelectImagesToEvict(images, tt.budget) {
+			evict = append(evict, image.Ref)
+		}
+		if !slices.Equal(evict, tt.evict) {
+			t.Errorf("budget %d: expect to evict %v, got %v", tt.budget, tt.evict, evict)
+		}
+	}
+}
+
+func TestImagesSize(t *testing.T) {
+	image := func(ref string, id string, size int64) daemon.ImageStatus {
+		return daemon.ImageStatus{Image: meta.Image{Ref: ref, Id: id, Size: size}}
+	}
+	tests := []struct {
+		name   string
+		images []daemon.ImageStatus
+		size   int64
+	}{
+		{"no images", nil, 0},
+		{"distinct images", []daemon.ImageStatus{image("amber:22", "sha256:a", 8), image("vina:1.2", "sha256:v", 2)}, 10},
+		// an image pulled by more than one reference only takes its space once
+		{"shared image", []daemon.ImageStatus{
+			image("gromacs:2024", "sha256:g", 6),
+			image("gromacs:latest", "sha256:g", 6),
+			image("mirror.lab/gromacs:2024", "sha256:g", 6),
+			image("vina:1.2", "sha256:v", 2),
+		}, 8},
+	}
+	for _, tt := range tests {
+		if size := daemon.ImagesSize(tt.images); size != tt.size {
+			t.Errorf("%s: expect size %d, got %d", tt.name, tt.size, size)
+		}
+	}
+}
+
+func TestRecordImageNotPulled(t *testing.T) {
+	ref := "sath-test/present:" + time.Now().Format("150405.000000")
+	// an image present before, which engine uses without pulling, is not recorded, so it is never evicted
+	// whatever the budget is; docker is not needed since the image is not inspected
+	checkErr(daemon.RecordImage(context.Background(), nil, ref, false))
+	if _, err := meta.GetImage(ref); !errors.Is(err, constants.ErrNil) {
+		t.Errorf("expect image not recorded, got %v", err)
+	}
+	images, err := meta.GetImages()
+	checkErr(err)
+	for _, image := range images {
+		if image.Ref == ref {
+			t.Errorf("expect %s never listed for eviction", ref)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package daemon
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/client"
+)
+
+var ErrImagePolicy = errors.New("image policy violation")
+
+const (
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignPayloadMediaType    = "application/vnd.dev.cosign.simplesigning.v1+json"
+)
+
+// ImagePolicyConfig restricts images which jobs may run, images are allowed by default
+type ImagePolicyConfig struct {
+	// registries images may be pulled from, e.g. "ghcr.io", empty to allow all
+	AllowedRegistries []string
+	// repositories images may be pulled from, glob patterns are supported, e.g. "ghcr.io/sath-run/*", empty to allow all
+	AllowedRepositories []string
+	// repositories or references which are never run, glob patterns are supported, e.g. "docker.io/library/*:latest"
+	Denied []string
+	// only run images pinned by digest, e.g. "ubuntu@sha256:..."
+	RequireDigest bool
+	// paths of PEM encoded public keys, e.g. cosign.pub, images must be signed by any of them if set
+	PublicKeys []string
+}
+
+// ImagePolicy checks images against the configured rules before they are run
+type ImagePolicy struct {
+	config ImagePolicyConfig
+	keys   []crypto.PublicKey
+
+	mu sync.Mutex
+	// digests whose signatures have been verified
+	verified map[string]bool
+}
+
+func NewImagePolicy(config *ImagePolicyConfig) (*ImagePolicy, error) {
+	policy := &ImagePolicy{
+		config:   *config,
+		verified: map[string]bool{},
+	}
+	for _, pattern := range append(config.AllowedRepositories, config.Denied...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid image pattern %q: %w", pattern, err)
+		}
+	}
+	for _, file := range config.PublicKeys {
+		key, err := loadPublicKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("fail to load public key %s: %w", file, err)
+		}
+		policy.keys = append(policy.keys, key)
+	}
+	return policy, nil
+}
+
+func loadPublicKey(file string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// Check checks the reference of an image against allowed and denied registries and repositories
+func (p *ImagePolicy) Check(ref string) error {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return fmt.Errorf("%w: invalid image reference %s: %v", ErrImagePolicy, ref, err)
+	}
+	name := named.Name()
+	if len(p.config.AllowedRegistries) > 0 && !matchAny([]string{reference.Domain(named)}, p.config.AllowedRegistries) {
+		return fmt.Errorf("%w: registry %s of image %s is not allowed", ErrImagePolicy, reference.Domain(named), ref)
+	}
+	if len(p.config.AllowedRepositories) > 0 && !matchAny([]string{name}, p.config.AllowedRepositories) {
+		return fmt.Errorf("%w: repository %s is not allowed", ErrImagePolicy, name)
+	}
+	// denied patterns may match the repository, or the reference with tag or digest
+	candidates := []string{name, reference.TagNameOnly(named).String()}
+	if _, ok := named.(reference.Canonical); ok {
+		candidates = append(candidates, named.String())
+	}
+	if matchAny(candidates, p.config.Denied) {
+		return fmt.Errorf("%w: image %s is denied", ErrImagePolicy, ref)
+	}
+	if _, ok := named.(reference.Canonical); p.config.RequireDigest && !ok {
+		return fmt.Errorf("%w: image %s is not pinned by digest", ErrImagePolicy, ref)
+	}
+	return nil
+}
+
+// matchAny reports whether any of names matches any of patterns
+func matchAny(names []string, patterns []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequiresSignature reports whether images must be signed by configured keys
+func (p *ImagePolicy) RequiresSignature() bool {
+	return len(p.keys) > 0
+}
+
+// Verify verifies the signature of a local image pulled by ref, which is stored in registry by cosign.
+// It returns the reference pinned by the digest verified, by which the image should be run, so that a tag pulled
+// again meanwhile is never run unverified. It succeeds with ref if no key is configured.
+func (p *ImagePolicy) Verify(ctx context.Context, cli *client.Client, ref string, auth string) (string, error) {
+	if !p.RequiresSignature() {
+		return ref, nil
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid image reference %s: %v", ErrImagePolicy, ref, err)
+	}
+	digest, err := localImageDigest(ctx, cli, named)
+	if err != nil {
+		return "", err
+	}
+	pinned := named.Name() + "@" + digest
+	p.mu.Lock()
+	verified := p.verified[pinned]
+	p.mu.Unlock()
+	if verified {
+		return pinned, nil
+	}
+
+	registry, err := newRegistryClient(named, auth)
+	if err != nil {
+		return "", err
+	}
+	// cosign stores signatures of an image by the tag "sha256-<hex>.sig" in the same repository
+	manifest, err := registry.manifest(ctx, strings.Replace(digest, ":", "-", 1)+".sig")
+	if err != nil {
+		return "", fmt.Errorf("fail to fetch signature of image %s: %w", ref, err)
+	} else if manifest == nil {
+		return "", fmt.Errorf("%w: image %s is not signed", ErrImagePolicy, ref)
+	}
+	if err := VerifyCosignSignature(manifest, func(digest string) ([]byte, error) {
+		return registry.blob(ctx, digest)
+	}, digest, p.keys); err != nil {
+		return "", fmt.Errorf("%w: image %s: %v", ErrImagePolicy, ref, err)
+	}
+	p.mu.Lock()
+	p.verified[pinned] = true
+	p.mu.Unlock()
+	return pinned, nil
+}
+
+// localImageDigest returns the digest of manifest by which the local image was pulled from the repository of named
+func localImageDigest(ctx context.Context, cli *client.Client, named reference.Named) (string, error) {
+	if canonical, ok := named.(reference.Canonical); ok {
+		return canonical.Digest().String(), nil
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, named.String())
+	if err != nil {
+		return "", err
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		pulled, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if canonical, ok := pulled.(reference.Canonical); ok && pulled.Name() == named.Name() {
+			return canonical.Digest().String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: image %s has no digest of registry", ErrImagePolicy, named)
+}
+
+// VerifyCosignSignature verifies a cosign signature manifest of the image with digest, fetch reads payloads
+// of signatures by their digests. It succeeds if any signature is made by any of keys, and its payload refers to digest.
+func VerifyCosignSignature(manifest []byte, fetch func(digest string) ([]byte, error), digest string, keys []crypto.PublicKey) error {
+	var m struct {
+		Layers []struct {
+			MediaType   string            `json:"mediaType"`
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("invalid signature manifest: %w", err)
+	}
+	errs := []error{}
+	for _, layer := range m.Layers {
+		if layer.MediaType != cosignPayloadMediaType {
+			continue
+		}
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			errs = append(errs, fmt.Errorf("invalid signature of layer %s", layer.Digest))
+			continue
+		}
+		payload, err := fetch(layer.Digest)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(payload)
+		if "sha256:"+hex.EncodeToString(sum[:]) != layer.Digest {
+			errs = append(errs, fmt.Errorf("payload does not match digest %s", layer.Digest))
+			continue
+		}
+		if !verifySignature(keys, payload, signature) {
+			errs = append(errs, errors.New("signature is not made by any trusted key"))
+			continue
+		}
+		var simpleSigning struct {
+			Critical struct {
+				Image struct {
+					DockerManifestDigest string `json:"docker-manifest-digest"`
+				} `json:"image"`
+			} `json:"critical"`
+		}
+		if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+			errs = append(errs, fmt.Errorf("invalid signature payload: %w", err))
+		} else if signed := simpleSigning.Critical.Image.DockerManifestDigest; signed != digest {
+			errs = append(errs, fmt.Errorf("signature is made for %s", signed))
+		} else {
+			return nil
+		}
+	}
+	if len(errs) == 0 {
+		return errors.New("no signature found")
+	}
+	return errors.Join(errs...)
+}
+
+func verifySignature(keys []crypto.PublicKey, payload []byte, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, hash[:], signature) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil {
+				return true
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, payload, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package daemon_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+const testDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestImagePolicyCheck(t *testing.T) {
+	policy, err := daemon.NewImagePolicy(&daemon.ImagePolicyConfig{
+		AllowedRegistries:   []string{"docker.io", "ghcr.io"},
+		AllowedRepositories: []string{"ghcr.io/sath-run/*", "docker.io/library/*"},
+		Denied:              []string{"docker.io/library/*:latest", "ghcr.io/sath-run/legacy"},
+	})
+	checkErr(err)
+	tests := []struct {
+		ref   string
+		allow bool
+	}{
+		{"ubuntu:22.04", true},
+		{"docker.io/library/ubuntu:22.04", true},
+		{"ubuntu", false},
+		{"ubuntu:latest", false},
+		{"ghcr.io/sath-run/amber:22", true},
+		{"ghcr.io/sath-run/amber@" + testDigest, true},
+		{"ghcr.io/sath-run/legacy:1.0", false},
+		{"ghcr.io/someone/amber:22", false},
+		{"quay.io/sath-run/amber:22", false},
+		{"alice/miner:1.0", false},
+		{"Invalid//Ref", false},
+	}
+	for _, tt := range tests {
+		err := policy.Check(tt.ref)
+		if tt.allow && err != nil {
+			t.Errorf("expect %s to be allowed, got %v", tt.ref, err)
+		} else if !tt.allow && !errors.Is(err, daemon.ErrImagePolicy) {
+			t.Errorf("expect %s to violate policy, got %v", tt.ref, err)
+		}
+	}
+
+	policy, err = daemon.NewImagePolicy(&daemon.ImagePolicyConfig{RequireDigest: true})
+	checkErr(err)
+	if err := policy.Check("ubuntu:22.04"); !errors.Is(err, daemon.ErrImagePolicy) {
+		t.Errorf("expect error of image not pinned, got %v", err)
+	}
+	if err := policy.Check("ubuntu@" + testDigest); err != nil {
+		t.Errorf("expect pinned image to be allowed, got %v", err)
+	}
+}
+
+func TestNewImagePolicyKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErr(err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	checkErr(err)
+	file := filepath.Join(t.TempDir(), "cosign.pub")
+	checkErr(os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	policy, err := daemon.NewImagePolicy(&daemon.ImagePolicyConfig{PublicKeys: []string{file}})
+	checkErr(err)
+	if !policy.RequiresSignature() {
+		t.Errorf("expect signature to be required")
+	}
+
+	invalid := filepath.Join(t.TempDir(), "cosign.key")
+	checkErr(os.WriteFile(invalid, []byte("not a key"), 0600))
+	for _, config := range []daemon.ImagePolicyConfig{
+		{PublicKeys: []string{invalid}},
+		{PublicKeys: []string{filepath.Join(t.TempDir(), "missing.pub")}},
+		{Denied: []string{"ghcr.io/[sath"}},
+	} {
+		if _, err := daemon.NewImagePolicy(&config); err == nil {
+			t.Errorf("expect error for %+v", config)
+		}
+	}
+}
+
+// signedManifest creates a cosign signature manifest with a payload signed by key for digest
+func signedManifest(key *ecdsa.PrivateKey, digest string) ([]byte, map[string][]byte) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"ghcr.io/sath-run/amber"},`+
+		`"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, digest))
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	checkErr(err)
+	payloadDigest := "sha256:" + hex.EncodeToString(hash[:])
+	manifest, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"layers": []map[string]any{{
+			"mediaType": "application/vnd.dev.cosign.simplesigning.v1+json",
+			"digest":    payloadDigest,
+			"size":      len(payload),
+			"annotations": map[string]string{
+				"dev.cosignproject.cosign/signature": base64.StdEncoding.EncodeToString(signature),
+			},
+		}},
+	})
+	checkErr(err)
+	return manifest, map[string][]byte{payloadDigest: payload}
+}
+
+func TestVerifyCosignSignature(t *testing.T) {
+	trusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErr(err)
+	untrusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErr(err)
+	keys := []crypto.PublicKey{&trusted.PublicKey}
+	other := "sha256:" + hex.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		name   string
+		key    *ecdsa.PrivateKey
+		signed string
+		tamper bool
+		valid  bool
+	}{
+		{"trusted", trusted, testDigest, false, true},
+		{"untrusted key", untrusted, testDigest, false, false},
+		{"other image", trusted, other, false, false},
+		{"tampered payload", trusted, testDigest, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, blobs := signedManifest(tt.key, tt.signed)
+			fetch := func(digest string) ([]byte, error) {
+				blob, ok := blobs[digest]
+				if !ok {
+					return nil, fmt.Errorf("blob %s not found", digest)
+				}
+				if tt.tamper {
+					blob = append([]byte{' '}, blob...)
+				}
+				return blob, nil
+			}
+			err := daemon.VerifyCosignSignature(manifest, fetch, testDigest, keys)
+			if tt.valid && err != nil {
+				t.Errorf("expect signature to be valid, got %v", err)
+			} else if !tt.valid && err == nil {
+				t.Errorf("expect signature to be invalid")
+			}
+		})
+	}
+	if err := daemon.VerifyCosignSignature([]byte(`{"layers":[]}`), nil, testDigest, keys); err == nil {
+		t.Errorf("expect error without signatures")
+	}
+}
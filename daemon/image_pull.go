@@ -0,0 +1,82 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// ImagePullPolicy decides whether an image is pulled from registry, or the local one is used
+type ImagePullPolicy struct {
+	// pull every time, even if the image is present
+	Always bool
+	// refresh mutable tags pulled longer ago than this, 0 to never refresh a present image
+	RefreshAfter time.Duration
+}
+
+// ParseImagePullPolicy parses "always", "if-not-present", or an interval of refreshing tags, e.g. "24h"
+func ParseImagePullPolicy(s string) (ImagePullPolicy, error) {
+	switch s {
+	case "always":
+		return ImagePullPolicy{Always: true}, nil
+	case "if-not-present":
+		return ImagePullPolicy{}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return ImagePullPolicy{}, fmt.Errorf("invalid image pull policy: %s", s)
+	}
+	return ImagePullPolicy{RefreshAfter: d}, nil
+}
+
+// IsPinnedImage reports whether ref is pinned by digest, e.g. "ubuntu@sha256:...",
+// which always refers to the same content and never needs to be refreshed
+func IsPinnedImage(ref string) bool {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false
+	}
+	_, ok := named.(reference.Canonical)
+	return ok
+}
+
+// NeedsPull reports whether ref should be pulled, present is whether it is found locally,
+// and pulledAt is the last time it was pulled by engine, zero if never
+func (p ImagePullPolicy) NeedsPull(ref string, present bool, pulledAt time.Time, now time.Time) bool {
+	if !present {
+		return true
+	} else if IsPinnedImage(ref) {
+		return false
+	} else if p.Always {
+		return true
+	}
+	return p.RefreshAfter > 0 && now.Sub(pulledAt) >= p.RefreshAfter
+}
+
+// pullImage pulls ref by docker, and reports the overall progress by fn.
+// Errors reported in the stream of docker fail the pull.
+func pullImage(ctx context.Context, cli *client.Client, ref string, auth string, fn func(Progress)) error {
+	reader, err := cli.ImagePull(ctx, ref, image.PullOptions{
+		RegistryAuth: auth,
+	})
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	defer reader.Close()
+
+	progress := NewPullProgress()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		now := time.Now()
+		if err := progress.Update(scanner.Bytes(), now); err != nil {
+			return fmt.Errorf("fail to pull image %s: %w", ref, err)
+		}
+		fn(progress.Progress(now))
+	}
+	return contextErr(ctx, scanner.Err())
+}
@@ -0,0 +1,59 @@
+package daemon_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestImagePullPolicy(t *testing.T) {
+	now := time.Now()
+	pinned := "ghcr.io/sath-run/amber@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		policy   string
+		ref      string
+		present  bool
+		pulledAt time.Time
+		pull     bool
+	}{
+		{"always", "amber:22", false, time.Time{}, true},
+		{"always", "amber:22", true, now, true},
+		{"always", pinned, true, time.Time{}, false},
+		{"if-not-present", "amber:22", false, time.Time{}, true},
+		{"if-not-present", "amber:latest", true, time.Time{}, false},
+		{"if-not-present", pinned, false, time.Time{}, true},
+		{"24h", "amber:22", true, now.Add(-time.Hour), false},
+		{"24h", "amber:22", true, now.Add(-25 * time.Hour), true},
+		// present before engine ever pulled it
+		{"24h", "amber:22", true, time.Time{}, true},
+		{"24h", pinned, true, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		policy, err := daemon.ParseImagePullPolicy(tt.policy)
+		checkErr(err)
+		if pull := policy.NeedsPull(tt.ref, tt.present, tt.pulledAt, now); pull != tt.pull {
+			t.Errorf("%s %s present %v pulled at %v: expect pull %v, got %v", tt.policy, tt.ref, tt.present, tt.pulledAt, tt.pull, pull)
+		}
+	}
+	for _, s := range []string{"", "never", "-1h", "0s"} {
+		if _, err := daemon.ParseImagePullPolicy(s); err == nil {
+			t.Errorf("expect error for %q", s)
+		}
+	}
+}
+
+func TestIsPinnedImage(t *testing.T) {
+	for ref, pinned := range map[string]bool{
+		"ubuntu":            false,
+		"ubuntu:22.04":      false,
+		"localhost:5000/md": false,
+		"ubuntu@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae":       true,
+		"ubuntu:22.04@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae": true,
+		"ubuntu@sha256:2c26b4": false,
+	} {
+		if got := daemon.IsPinnedImage(ref); got != pinned {
+			t.Errorf("%s: expect pinned %v, got %v", ref, pinned, got)
+		}
+	}
+}
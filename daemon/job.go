@@ -1,27 +1,34 @@
 package daemon
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	pb "github.com/sath-run/engine/daemon/protobuf"
+	"github.com/sath-run/engine/meta"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/metadata"
 )
 
+var (
+	ErrJobTimeout = errors.New("job timeout")
+	ErrJobStalled = errors.New("job stalled")
+)
+
 type JobNotification struct {
 	Id      string
 	Message string
@@ -43,21 +50,51 @@ type Job struct {
 	metadata    *pb.JobGetResponse
 	container   *Container
 	rm          *ResourceManager
+	config      *SchedulerConfig
 	resourceDir string
+	logs        *jobLogs
+	gpuMonitor  *gpuMonitor
 
 	dir       string
 	stream    pb.Engine_NotifyExecStatusClient
-	streamMu  sync.Mutex
+	streamCtx context.Context
+	notifier  *notifier
 	err       error
 	queue     chan *Job
 	state     pb.EnumExecState
 	createdAt time.Time
 	outputs   []JobOutput
+	finished  chan struct{}
+
+	// unix nano time of the last output or progress of running task
+	lastActivity atomic.Int64
+	// unix milli time of the latest checkpoint saved
+	checkpointAt atomic.Int64
+	// whether the job is fetched ahead and waits for a container to free up, its downloads are throttled then
+	ahead atomic.Bool
+
+	pauseMu sync.Mutex
+	pause   jobPause
+	// serializes freezing and unfreezing the container, pauseMu is not held meanwhile
+	freezeMu sync.Mutex
+
+	// mu guards fields read by other goroutines for status report
+	mu       sync.Mutex
+	progress Progress
+	// local reference of the image, which may be pulled from a mirror instead of the registry of job
+	image string
+	// reference by which the container is created, pinned by digest if the signature of image is verified
+	runImage    string
+	completedAt time.Time
+	stages      []stageTiming
+	gpuStats    *pb.GpuStats
+	// peak gpu utilization in percent while the task was running
+	gpuPeakUtilization uint32
 
 	logger zerolog.Logger
 }
 
-func newJob(ctx context.Context, c *Connection, cli *client.Client, queue chan *Job, rm *ResourceManager, dir string, meta *pb.JobGetResponse) (*Job, error) {
+func newJob(ctx context.Context, c *Connection, cli *client.Client, queue chan *Job, rm *ResourceManager, config *SchedulerConfig, dir string, meta *pb.JobGetResponse) (*Job, error) {
 	if err := os.Mkdir(dir, os.ModePerm); err != nil {
 		return nil, err
 	}
@@ -71,20 +108,25 @@ func newJob(ctx context.Context, c *Connection, cli *client.Client, queue chan *
 		cli:       cli,
 		metadata:  meta,
 		rm:        rm,
+		config:    config,
 		state:     pb.EnumExecState_EES_INITIALIZED,
 		createdAt: time.Now(),
 		stream:    stream,
 		queue:     queue,
 		dir:       dir,
+		image:     meta.GetImage().GetUrl(),
+		finished:  make(chan struct{}),
 		logger:    log.With().Str("job", meta.JobId).Logger(),
 	}
+	job.streamCtx = ctx
+	job.notifier = newNotifier(job.sendNotification, config.NotifyInterval, config.NotifyBufferSize, job.logger)
 	if err := os.MkdirAll(job.dataDir(), os.ModePerm); err != nil {
 		return nil, err
 	}
 	if err := os.MkdirAll(job.outputDir(), os.ModePerm); err != nil {
 		return nil, err
 	}
-	if dir, err := filepath.Abs(filepath.Join(job.dir, "..", "resource_"+job.metadata.ResourceId)); err != nil {
+	if dir, err := resourceDir(filepath.Join(job.dir, ".."), job.metadata.ResourceId); err != nil {
 		return nil, err
 	} else {
 		job.resourceDir = dir
@@ -93,6 +135,48 @@ func newJob(ctx context.Context, c *Connection, cli *client.Client, queue chan *
 	return job, nil
 }
 
+type stageTiming struct {
+	state     pb.EnumExecState
+	startedAt time.Time
+}
+
+type JobStatus struct {
+	Id          string
+	State       pb.EnumExecState
+	Err         error
+	Progress    Progress
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	ContainerId string
+	Image       string
+	// latest stats of the gpu assigned to the job, nil if there is none
+	GpuStats           *pb.GpuStats
+	GpuPeakUtilization uint32
+	// paused by user or suspended by policy
+	Paused bool
+}
+
+func (job *Job) Status() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	status := JobStatus{
+		Id:                 job.metadata.JobId,
+		State:              job.state,
+		Err:                job.err,
+		Progress:           job.progress,
+		CreatedAt:          job.createdAt,
+		CompletedAt:        job.completedAt,
+		Image:              job.metadata.GetImage().GetUrl(),
+		GpuStats:           job.gpuStats,
+		GpuPeakUtilization: job.gpuPeakUtilization,
+		Paused:             job.isPaused(),
+	}
+	if ctn := job.container; ctn != nil {
+		status.ContainerId = ctn.id
+	}
+	return status
+}
+
 func (job *Job) dataDir() string {
 	return filepath.Join(job.dir, "data")
 }
@@ -101,20 +185,41 @@ func (job *Job) outputDir() string {
 	return filepath.Join(job.dir, "output")
 }
 
-func (job *Job) notifyStatusToRemote(notification JobNotification) error {
-	req := pb.ExecNotificationRequest{
-		State:   job.state,
+// logDir is where logs of this run of the job are kept, which outlive the job dir
+func (job *Job) logDir() string {
+	return filepath.Join(job.config.LogDir, job.metadata.JobId, job.logRun())
+}
+
+// logRun names the run of the job among those with the same id, later runs sort after earlier ones
+func (job *Job) logRun() string {
+	return strconv.FormatInt(job.createdAt.UnixNano(), 10)
+}
+
+func (job *Job) newNotificationRequest(notification JobNotification) *pb.ExecNotificationRequest {
+	// it is also called by other goroutines than the one running the job, e.g. when paused by user
+	job.mu.Lock()
+	state, err, logs, outputs := job.state, job.err, job.logs, job.outputs
+	job.mu.Unlock()
+	req := &pb.ExecNotificationRequest{
+		State:   state,
 		Id:      notification.Id,
 		Message: notification.Message,
 		Current: uint64(notification.Current),
 		Total:   uint64(notification.Total),
-		Flag:    notification.Flag,
+		Flag:    notification.Flag | job.pausedFlag(),
+
+		CheckpointAt: job.checkpointAt.Load(),
 	}
-	if job.err != nil {
-		req.Message = job.err.Error()
+	if err != nil {
+		req.Message = err.Error()
+		if logs != nil {
+			if tail := logs.stderrTail(); tail != "" {
+				req.Message += "\nstderr:\n" + tail
+			}
+		}
 		req.Flag |= uint64(pb.EnumExecFlag_EEF_ERROR)
 	} else if req.State == pb.EnumExecState_EES_SUCCESS {
-		for _, output := range job.outputs {
+		for _, output := range outputs {
 			req.Outputs = append(req.Outputs, &pb.ExecOutput{
 				Id:      output.Id,
 				Status:  output.Status,
@@ -123,46 +228,112 @@ func (job *Job) notifyStatusToRemote(notification JobNotification) error {
 			})
 		}
 	}
+	job.logger.Trace().Str("state", state.String()).Any("notification", notification).Send()
+	return req
+}
 
-	job.logger.Trace().Str("state", job.state.String()).Any("notification", notification).Send()
-	job.streamMu.Lock()
-	err := job.stream.Send(&req)
-	job.streamMu.Unlock()
-	return err
+// sendNotification sends req on the notification stream of job. A broken stream can not send anymore,
+// it is reopened once, e.g. after a transient network error. It is only called by notifier.
+func (job *Job) sendNotification(req *pb.ExecNotificationRequest) error {
+	err := job.stream.Send(req)
+	if err == nil {
+		return nil
+	}
+	stream, reopenErr := job.c.NotifyExecStatus(job.streamCtx)
+	if reopenErr != nil {
+		return errors.Join(err, reopenErr)
+	}
+	job.logger.Debug().Err(err).Msg("notification stream reopened")
+	job.stream = stream
+	return stream.Send(req)
+}
+
+// notifyStatusToRemote sends state transitions and results, which are never dropped
+func (job *Job) notifyStatusToRemote(notification JobNotification) error {
+	return job.notifier.notifyState(job.newNotificationRequest(notification))
+}
+
+// notifyProgressToRemote sends progress, pending progress with the same id is replaced
+func (job *Job) notifyProgressToRemote(notification JobNotification) error {
+	return job.notifier.notifyProgress(job.newNotificationRequest(notification))
+}
+
+// notifyLogToRemote sends an output line of task, which may be dropped if there are too many
+func (job *Job) notifyLogToRemote(line string) error {
+	return job.notifier.notifyLog(job.newNotificationRequest(JobNotification{Message: line}))
 }
 
 func (job *Job) setState(state pb.EnumExecState) {
+	job.mu.Lock()
 	job.state = state
+	job.stages = append(job.stages, stageTiming{state: state, startedAt: time.Now()})
+	job.mu.Unlock()
 	if state != pb.EnumExecState_EES_SUCCESS {
 		job.notifyStatusToRemote(JobNotification{})
 	}
 }
 
+// setErr records the error of the job, which is read by other goroutines, e.g. for status
+func (job *Job) setErr(err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.err = err
+}
+
+// joinErr adds err to the error of the job
+func (job *Job) joinErr(err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.err = errors.Join(job.err, err)
+}
+
 func (job *Job) handleCompletion() {
-	if err := job.notifyStatusToRemote(JobNotification{}); err != nil {
-		job.err = errors.Join(job.err, err)
+	job.notifyStatusToRemote(JobNotification{})
+	// wait for all pending notifications to be sent
+	if err := job.notifier.close(); err != nil {
+		job.joinErr(err)
 		job.logger.Warn().Err(err).Msg("err notify status")
 	}
 
 	// block and wait to close stream, error could be ignored
 	if _, err := job.stream.CloseAndRecv(); err != nil {
-		job.err = errors.Join(job.err, err)
+		job.joinErr(err)
 		job.logger.Warn().Err(err).Msg("err CloseAndRecv")
 	}
 
+	// logs are kept elsewhere until retention expires
 	if err := os.RemoveAll(job.dir); err != nil {
-		job.err = errors.Join(job.err, err)
+		job.joinErr(err)
 		job.logger.Warn().Err(err).Msg("err RemoveAll")
 	}
+	if job.err == nil {
+		job.removeCheckpoints()
+	}
+	job.mu.Lock()
+	job.completedAt = time.Now()
+	job.mu.Unlock()
+	job.logTimings()
+	close(job.finished)
+}
+
+// logTimings logs how long each stage of the job took, and the overhead of engine
+func (job *Job) logTimings() {
+	timings := job.timings()
+	event := job.logger.Info()
+	for state, d := range timings.Stages {
+		event = event.Dur(state.String(), d)
+	}
+	event.Dur("total", timings.Total).Dur("overhead", timings.Overhead).Msg("job timings")
 }
 
 func (job *Job) preprocess() {
 	var err error
 	defer func() {
-		job.err = err
+		job.setErr(err)
 		// notify scheduler
 		job.queue <- job
 	}()
+	job.waitResumed(context.Background())
 	if err = job.prepareImage(); err != nil {
 		return
 	}
@@ -184,13 +355,14 @@ func (job *Job) preprocess() {
 func (job *Job) run() {
 	var err error
 	defer func() {
-		job.err = err
+		job.setErr(err)
 		// notify scheduler
 		job.queue <- job
 	}()
 	if err = job.prepareContainer(); err != nil {
 		return
 	}
+	job.waitResumed(context.Background())
 	if err = job.runTask(); err != nil {
 		return
 	}
@@ -203,87 +375,167 @@ func (job *Job) run() {
 func (job *Job) postprocess() {
 	var err error
 	defer func() {
-		job.err = err
+		job.setErr(err)
 		// notify scheduler
 		job.queue <- job
 	}()
+	job.waitResumed(context.Background())
 	if err = job.processOutputs(); err != nil {
 		return
 	}
 	job.setState(pb.EnumExecState_EES_SUCCESS)
 }
 
+// contextErr returns the cause of ctx if it is done, otherwise err
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
 func (job *Job) prepareImage() error {
 	job.setState(pb.EnumExecState_EES_PREPARING_IMAGE)
+	ctx, cancel := job.stageContext("pulling image", job.config.ImagePullTimeout)
+	defer cancel()
 
-	reader, err := job.cli.ImagePull(context.Background(), job.metadata.Image.Url, image.PullOptions{
-		RegistryAuth: job.metadata.Image.Auth,
-	})
-
-	if err != nil {
+	ref := job.metadata.Image.Url
+	if err := job.config.imagePolicy.Check(ref); err != nil {
 		return err
 	}
-	defer reader.Close()
-
-	scanner := bufio.NewScanner(reader)
-
-	type Info struct {
-		Id             string `json:"id"`
-		Status         string `json:"status,omitempty"`
-		ProgressDetail struct {
-			Current int `json:"current"`
-			Total   int `json:"total"`
-		} `json:"progressDetail,omitempty"`
+	// the image may be pulled from mirrors, the local one is found by any of them
+	candidates, err := job.config.imageResolver.Resolve(ref)
+	if err != nil {
+		return err
 	}
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		var info Info
-		if err := json.Unmarshal([]byte(line), &info); err != nil {
-			job.logger.Debug().Err(err).Msg("unmarshal docker pull log")
-			continue
+	local, present := candidates[len(candidates)-1], false
+	for _, candidate := range candidates {
+		if _, _, err := job.cli.ImageInspectWithRaw(ctx, candidate); err == nil {
+			local, present = candidate, true
+			break
 		}
-		if info.Status == "Downloading" || info.Status == "Extracting" {
-			notification := JobNotification{
-				Id:      info.Id,
-				Message: info.Status,
-				Current: uint(info.ProgressDetail.Current),
-				Total:   uint(info.ProgressDetail.Total),
+	}
+	var pulledAt time.Time
+	record, err := meta.GetImage(local)
+	recorded := err == nil
+	if recorded {
+		pulledAt = record.PulledAt
+	}
+	presentRef := local
+	pulled := false
+	if job.config.imagePullPolicy.NeedsPull(local, present, pulledAt, time.Now()) {
+		errs := []error{}
+		for _, candidate := range candidates {
+			err := job.pullImage(ctx, candidate)
+			if err == nil {
+				local, pulled = candidate, true
+				break
 			}
-			err := job.notifyStatusToRemote(notification)
-			if err != nil {
-				job.logger.Err(err).Msg("fail to notify ")
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			} else if candidate != candidates[len(candidates)-1] {
+				job.logger.Warn().Err(err).Str("image", candidate).Msg("fail to pull image from mirror")
 			}
 		}
+		if !pulled && !present {
+			return contextErr(ctx, errors.Join(errs...))
+		} else if !pulled {
+			// e.g. the registry is unreachable, the local image is still usable
+			job.logger.Warn().Err(errors.Join(errs...)).Str("image", local).Msg("fail to refresh image, using the local one")
+		}
+	} else {
+		job.logger.Debug().Str("image", local).Msg("image is present, skip pulling")
+	}
+	job.mu.Lock()
+	job.image = local
+	job.mu.Unlock()
+	auth, err := registryAuth(local, ref, job.metadata.Image.Auth)
+	if err != nil {
+		return err
+	}
+	runImage, err := job.config.imagePolicy.Verify(ctx, job.cli, local, auth)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+	job.mu.Lock()
+	job.runImage = runImage
+	job.mu.Unlock()
+	// an image which is present but not pulled by engine belongs to user, it may be refreshed but is never
+	// recorded, so that it is never collected
+	owned := pulled && (recorded || !present || local != presentRef)
+	// the image is still usable if it can not be recorded, it is just never collected
+	if err := recordImage(ctx, job.cli, local, owned); err != nil {
+		job.logger.Warn().Err(err).Msg("fail to record image")
+	}
+	return nil
+}
+
+// pullImage pulls ref, the image of job or one resolved from it, and notifies the overall progress to remote
+func (job *Job) pullImage(ctx context.Context, ref string) error {
+	auth, err := registryAuth(ref, job.metadata.Image.Url, job.metadata.Image.Auth)
+	if err != nil {
+		return err
 	}
-	return scanner.Err()
+	return pullImage(ctx, job.cli, ref, auth, job.updateProgress)
+}
+
+// imageRef returns the local reference of the image of job, which may be pulled from a mirror
+func (job *Job) imageRef() string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.image
+}
+
+// runImageRef returns the reference by which the container of job is created
+func (job *Job) runImageRef() string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.runImage
 }
 
 func (job *Job) downloadResources() error {
 	job.setState(pb.EnumExecState_EES_DOWNLOADING_RESOURCES)
+	ctx, cancel := job.stageContext("downloading resources", job.config.DownloadTimeout)
+	defer cancel()
 	// sequentially download each resource file
 	// TODO: batch download and rate limit
 	for _, resource := range job.metadata.Resources {
-		if err := job.downloadFile(context.TODO(), resource.Path, job.resourceDir, resource.Req.Url); err != nil {
+		if err := job.downloadFile(ctx, resource.Path, job.resourceDir, resource.Req.Url); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (job *Job) downloadFile(ctx context.Context, path string, dir string, url string) error {
-	id := path
+// resourceDir returns the dir where resources of resourceId are kept, which is shared by jobs in dir
+func resourceDir(dir string, resourceId string) (string, error) {
+	return filepath.Abs(filepath.Join(dir, "resource_"+resourceId))
+}
+
+// filePath returns the path of a file of job in dir
+func filePath(dir string, path string) (string, error) {
 	// sanitize path in case it contains relative path like: "../.."
 	// which may hack the directory structure limitation in client-engine
 	path, err := filepath.Abs(filepath.Join("/", path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path), nil
+}
+
+func (job *Job) downloadFile(ctx context.Context, path string, dir string, url string) error {
+	id := path
+	path, err := filePath(dir, path)
 	if err != nil {
 		return err
 	}
-	path = filepath.Join(dir, path)
 
 	// make dir, error can be ignored
 	os.MkdirAll(filepath.Dir(path), os.ModePerm)
-	resp := job.rm.Download(ctx, path, url)
+	// the download is shared with other jobs, it is only cancelled once none of them waits for it
+	resp := job.rm.Download(path, url, job)
+	defer resp.detach(job)
 	progress := 0.0
 
 	// check for download progress every 500 ms
@@ -296,22 +548,24 @@ func (job *Job) downloadFile(ctx context.Context, path string, dir string, url s
 			newProgress := resp.Progress()
 			if newProgress-progress > 0.01 || newProgress == 1 {
 				progress = newProgress
-				if err := job.notifyStatusToRemote(JobNotification{
+				if err := job.notifyProgressToRemote(JobNotification{
 					Id:      id,
 					Current: uint(resp.Current()),
 					Total:   uint(resp.Total()),
 				}); err != nil {
-					resp.Cancel()
-					return nil
+					return err
 				}
 			}
 
 		case <-resp.Done:
 			// check for errors
 			if err := resp.Err(); err != nil {
-				return err
+				return contextErr(ctx, err)
 			}
 			return nil
+
+		case <-ctx.Done():
+			return context.Cause(ctx)
 		}
 	}
 }
@@ -324,7 +578,9 @@ func (job *Job) processResources() error {
 func (job *Job) downloadInputs() error {
 	job.setState(pb.EnumExecState_EES_DOWNLOADING_INPUTS)
 	files := job.metadata.Inputs
-	g, ctx := errgroup.WithContext(context.TODO())
+	ctx, cancel := job.stageContext("downloading inputs", job.config.DownloadTimeout)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
 
 	// TODO: limit bandwidth
 	for _, file := range files {
@@ -332,8 +588,13 @@ func (job *Job) downloadInputs() error {
 			return job.downloadFile(ctx, file.Path, job.dataDir(), file.Req.Url)
 		})
 	}
-	err := g.Wait()
-	return err
+	g.Go(func() error {
+		return job.downloadCheckpoint(ctx)
+	})
+	if err := g.Wait(); err != nil {
+		return contextErr(ctx, err)
+	}
+	return job.restoreCheckpoint()
 }
 
 func (job *Job) processInputs() error {
@@ -346,8 +607,19 @@ func (job *Job) prepareContainer() error {
 	job.setState(pb.EnumExecState_EES_PREPARING_CONTAINER)
 	ctn := job.container
 
-	// if container has not been created by docker, create one
-	if ctn.id == "" {
+	// a warm container may have exited since its last job, recreate it in that case
+	if ctn.id != "" {
+		if err := ctn.waitRunning(context.TODO()); err != nil {
+			ctn.logger.Warn().Err(err).Msg("container is not ready, recreating it")
+			if err := ctn.remove(context.TODO()); err != nil {
+				return err
+			}
+		}
+	}
+
+	// if container has not been created by docker, create one,
+	// a container checkpointed by docker is created once the task runs
+	if ctn.id == "" && !ctn.criu {
 		if err := ctn.init(context.TODO()); err != nil {
 			return err
 		}
@@ -364,38 +636,255 @@ func (job *Job) prepareContainer() error {
 	if err := mvDir(job.dataDir(), ctn.dataDir()); err != nil {
 		return err
 	}
+	// move restored checkpoint to container dir
+	if ctn.checkpointPath != "" {
+		if err := emptyDir(ctn.checkpointDir()); err != nil {
+			return err
+		}
+		if err := mvDir(job.checkpointDir(), ctn.checkpointDir()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 	return nil
 }
 
 func (job *Job) runTask() error {
 	job.setState(pb.EnumExecState_EES_RUNNING)
-	hijack, err := job.container.run(context.TODO(), job.metadata.Cmd)
+	logs, err := openJobLogs(job.logDir(), job.config.LogMaxSize, job.config.LogMaxFiles, job.config.LogTailLines)
 	if err != nil {
 		return err
 	}
+	defer logs.close()
+	job.mu.Lock()
+	job.logs = logs
+	// progress of previous stages, e.g. pulling image, does not apply to the task
+	job.progress = Progress{}
+	job.mu.Unlock()
+
+	timeout := job.config.RunTimeout
+	if job.metadata.MaxRuntime > 0 {
+		timeout = time.Duration(job.metadata.MaxRuntime) * time.Second
+	}
+	ctx, cancel := job.stageContext("running", timeout)
+	defer cancel()
+	ctx, stall := context.WithCancelCause(ctx)
+	defer stall(nil)
+
+	hijack, wait, err := job.startTask(ctx)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
 	defer hijack.Close()
 
-	time.Sleep(10 * time.Second)
-	scanner := bufio.NewScanner(hijack.Reader)
-	for scanner.Scan() {
-		// TODO: update progress
-		job.notifyStatusToRemote(JobNotification{
-			Message: scanner.Text(),
-		})
+	// the container can be frozen once the task is executing, until it exits
+	job.setExecuting(true)
+	defer job.setExecuting(false)
+	job.lastActivity.Store(time.Now().UnixNano())
+
+	// kill the task if it times out or stalls
+	copyDone := make(chan struct{})
+	defer close(copyDone)
+	go job.watchTask(ctx, stall, copyDone, func() {
+		// removing container kills the task and closes its output, container will be recreated for next job
+		if err := job.container.remove(context.Background()); err != nil {
+			job.logger.Warn().Err(err).Msg("fail to remove container")
+		}
+		hijack.Close()
+	})
+
+	// poll progress file in output dir until task exits
+	pollDone := make(chan struct{})
+	defer close(pollDone)
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if progress, ok := readProgressFile(job.container.outputDir()); ok {
+					job.updateProgress(progress)
+				}
+			case <-pollDone:
+				return
+			}
+		}
+	}()
+
+	if job.gpuMonitor != nil && job.container.gpu != nil && job.container.gpu.Model == pb.GpuModel_EGM_NVIDIA {
+		go job.reportGpuStats(pollDone)
+	}
+
+	if job.container.checkpointPath != "" {
+		// wait for the snapshot in progress, so that it is not left behind once the job completes
+		snapshotDone := make(chan struct{})
+		go func() {
+			defer close(snapshotDone)
+			job.snapshotCheckpoints(pollDone)
+		}()
+		defer func() { <-snapshotDone }()
 	}
-	if err := scanner.Err(); err != nil {
+
+	notifyStdout := func(line string) {
+		job.lastActivity.Store(time.Now().UnixNano())
+		if progress, ok := parseProgressLine(line); ok {
+			job.updateProgress(progress)
+			return
+		}
+		job.notifyLogToRemote(line)
+	}
+	notifyStderr := func(line string) {
+		job.lastActivity.Store(time.Now().UnixNano())
+		job.notifyLogToRemote(line)
+	}
+	stdout := logs.writer(LogStreamStdout, notifyStdout)
+	stderr := logs.writer(LogStreamStderr, notifyStderr)
+	_, err = stdcopy.StdCopy(stdout, stderr, hijack.Reader)
+	stdout.Flush()
+	stderr.Flush()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	} else if err != nil {
 		return err
 	}
+
+	code, err := wait()
+	if err != nil {
+		return contextErr(ctx, err)
+	} else if code != 0 {
+		return fmt.Errorf("task exited with code %d", code)
+	}
 	return nil
 }
 
+// watchTask calls kill once ctx is done, or once the task stalls in which case ctx is canceled
+// by stall first. It returns without killing the task once done is closed.
+func (job *Job) watchTask(ctx context.Context, stall context.CancelCauseFunc, done <-chan struct{}, kill func()) {
+	var check <-chan time.Time
+	if job.config.StallTimeout > 0 {
+		t := time.NewTicker(min(job.config.StallTimeout/10, 10*time.Second))
+		defer t.Stop()
+		check = t.C
+	}
+	for {
+		select {
+		case <-check:
+			if err := job.stalled(); err == nil {
+				continue
+			} else {
+				stall(err)
+			}
+		case <-ctx.Done():
+			select {
+			case <-done:
+				// ctx is canceled once the task exits
+				return
+			default:
+			}
+		case <-done:
+			return
+		}
+		job.logger.Warn().Err(context.Cause(ctx)).Msg("killing task")
+		kill()
+		return
+	}
+}
+
+// stalled returns ErrJobStalled if the task has produced no output nor progress for StallTimeout
+func (job *Job) stalled() error {
+	if job.isPaused() {
+		// a paused task makes no progress, the stall period starts over once it is resumed
+		job.lastActivity.Store(time.Now().UnixNano())
+		return nil
+	}
+	idle := time.Since(time.Unix(0, job.lastActivity.Load()))
+	if idle < job.config.StallTimeout {
+		return nil
+	}
+	return fmt.Errorf("%w: no output nor progress for %s", ErrJobStalled, idle.Round(time.Second))
+}
+
+// startTask runs the task, as the main process of container if it is checkpointed by docker, or else
+// executed in the running container. It returns the output of task, and a function waiting for its exit code.
+func (job *Job) startTask(ctx context.Context) (*types.HijackedResponse, func() (int, error), error) {
+	ctn := job.container
+	if !ctn.criu {
+		execId, hijack, err := ctn.run(ctx, job.metadata.Cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		return hijack, func() (int, error) { return ctn.exitCode(ctx, execId) }, nil
+	}
+	if id := job.latestCriuCheckpoint(); id != "" {
+		hijack, wait, err := ctn.runMain(ctx, job.metadata.Cmd, job.criuDir(), id)
+		if err == nil {
+			job.logger.Info().Str("checkpoint", id).Msg("task restored from checkpoint")
+			return hijack, wait, nil
+		}
+		job.logger.Warn().Err(err).Str("checkpoint", id).Msg("fail to restore task, starting it over")
+	}
+	return ctn.runMain(ctx, job.metadata.Cmd, "", "")
+}
+
+// reportGpuStats attaches the stats of the assigned gpu to notifications until done is closed
+func (job *Job) reportGpuStats(done <-chan struct{}) {
+	uuid := job.container.gpu.Uuid
+	ticker := time.NewTicker(job.config.GpuStatsInterval)
+	defer ticker.Stop()
+	var last int64
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			job.mu.Lock()
+			unused := job.gpuStats != nil && job.gpuPeakUtilization == 0
+			job.mu.Unlock()
+			if unused {
+				job.logger.Warn().Str("gpu", uuid).Msg("gpu is assigned to the job but never used")
+			}
+			return
+		}
+		stats := job.gpuMonitor.get(uuid)
+		if stats == nil || stats.Timestamp == last {
+			continue
+		}
+		last = stats.Timestamp
+		job.mu.Lock()
+		job.gpuStats = stats
+		job.gpuPeakUtilization = max(job.gpuPeakUtilization, stats.Utilization)
+		job.mu.Unlock()
+		req := job.newNotificationRequest(JobNotification{})
+		req.GpuStats = []*pb.GpuStats{stats}
+		job.notifier.notifyGpuStats(req)
+	}
+}
+
+// updateProgress records the progress reported by the task and notifies it to remote if changed
+func (job *Job) updateProgress(progress Progress) {
+	job.mu.Lock()
+	if job.progress == progress {
+		job.mu.Unlock()
+		return
+	}
+	job.progress = progress
+	job.mu.Unlock()
+	job.lastActivity.Store(time.Now().UnixNano())
+	job.notifyProgressToRemote(JobNotification{
+		Message: progress.Message,
+		Current: uint(progress.Current),
+		Total:   uint(progress.Total),
+	})
+}
+
 func (job *Job) processOutputs() error {
 	job.setState(pb.EnumExecState_EES_PROCESSING_OUPUTS)
-	job.outputs = make([]JobOutput, len(job.metadata.Outputs))
+	// outputs are recorded once all of them are processed, they are only notified once the job succeeds
+	outputs := make([]JobOutput, len(job.metadata.Outputs))
 
-	g, ctx := errgroup.WithContext(context.TODO())
+	ctx, cancel := job.stageContext("uploading outputs", job.config.UploadTimeout)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
 	for i, output := range job.metadata.Outputs {
-		job.outputs[i] = JobOutput{
+		outputs[i] = JobOutput{
 			Id: output.Id,
 		}
 		g.Go(func() (err error) {
@@ -404,62 +893,85 @@ func (job *Job) processOutputs() error {
 
 			defer func() {
 				if err != nil {
-					job.outputs[i].Status = pb.ExecOutputStatus_EOS_ERROR
-					job.outputs[i].Message = err.Error()
+					outputs[i].Status = pb.ExecOutputStatus_EOS_ERROR
+					outputs[i].Message = err.Error()
 				}
 			}()
 
+			if output.Req != nil {
+				// upload output file to url
+				return job.uploadFile(ctx, path, output.Req)
+			}
+
+			// if output request is not specified, return file content
 			data, err := os.Open(path)
 			if err != nil {
 				return err
 			}
 			defer data.Close()
-
-			if output.Req == nil {
-				// if output request is not specified, return file content
-				if fs, err := data.Stat(); err != nil {
-					return err
-				} else {
-					// TODO: limit total file size
-					fileSizeInKB := float64(fs.Size()) / 1024
-					if fileSizeInKB > 128 {
-						return fmt.Errorf(
-							"file %s is too large, size limit is 128K, actual size is %.2fKB",
-							output.Path,
-							fileSizeInKB)
-					}
-				}
-				if bytes, err := io.ReadAll(data); err != nil {
-					return err
-				} else {
-					job.outputs[i].Content = bytes
-				}
+			if fs, err := data.Stat(); err != nil {
+				return err
 			} else {
-				// upload output file to url
-				var (
-					req  *http.Request
-					resp *http.Response
-				)
-				req, err = http.NewRequestWithContext(ctx, output.Req.Method, output.Req.Url, data)
-				if err != nil {
-					return
-				}
-				for _, header := range output.Req.Headers {
-					req.Header.Set(header.Name, header.Value)
-				}
-				resp, err = http.DefaultClient.Do(req)
-				if err != nil {
-					return
-				} else if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-					data, _ := io.ReadAll(resp.Body)
-					resp.Body.Close()
-					return fmt.Errorf("fail to upload data, stats: %d, data: %s", resp.StatusCode, string(data))
+				// TODO: limit total file size
+				fileSizeInKB := float64(fs.Size()) / 1024
+				if fileSizeInKB > 128 {
+					return fmt.Errorf(
+						"file %s is too large, size limit is 128K, actual size is %.2fKB",
+						output.Path,
+						fileSizeInKB)
 				}
 			}
+			if bytes, err := io.ReadAll(data); err != nil {
+				return err
+			} else {
+				outputs[i].Content = bytes
+			}
 			return
 		})
 	}
-	return g.Wait()
+	err := g.Wait()
+	job.mu.Lock()
+	job.outputs = outputs
+	job.mu.Unlock()
+	return contextErr(ctx, err)
+}
+
+// uploadFile uploads the file at path by fileReq, it makes no progress while the job is paused
+func (job *Job) uploadFile(ctx context.Context, path string, fileReq *pb.FileRequest) error {
+	data, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	// the body is closed by the request, so its size is taken in advance
+	var size int64
+	if fs, err := data.Stat(); err == nil {
+		size = fs.Size()
+	}
+	body := &pausedReader{ctx: ctx, job: job, r: data}
+	req, err := http.NewRequestWithContext(ctx, fileReq.Method, fileReq.Url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for _, header := range fileReq.Headers {
+		req.Header.Set(header.Name, header.Value)
+	}
+	start := time.Now()
+	pausedBefore := job.pausedFor()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("fail to upload data, stats: %d, data: %s", resp.StatusCode, string(data))
+	}
+	if job.pausedFor() == pausedBefore {
+		uploadBandwidth.Record(size, time.Since(start))
+	}
+	return nil
 }
 
 func emptyDir(dir string) error {
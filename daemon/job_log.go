@@ -0,0 +1,567 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type LogStream string
+
+const (
+	LogStreamStdout LogStream = "stdout"
+	LogStreamStderr LogStream = "stderr"
+)
+
+// max length of a single log line, longer output without line break is split
+const maxLogLineSize = 64 * 1024
+
+// jobLogs keeps the output of a job's task in rotating files under the job dir.
+// Each line is stored as "<RFC3339Nano timestamp> <text>".
+type jobLogs struct {
+	dir    string
+	stdout *rotatingFile
+	stderr *rotatingFile
+	tail   *tailBuffer
+}
+
+func openJobLogs(dir string, maxSize int64, maxFiles int, tailLines int) (*jobLogs, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	stdout, err := openRotatingFile(logFilePath(dir, LogStreamStdout), maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := openRotatingFile(logFilePath(dir, LogStreamStderr), maxSize, maxFiles)
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	return &jobLogs{
+		dir:    dir,
+		stdout: stdout,
+		stderr: stderr,
+		tail:   newTailBuffer(tailLines),
+	}, nil
+}
+
+func logFilePath(dir string, stream LogStream) string {
+	return filepath.Join(dir, string(stream)+".log")
+}
+
+// writer returns a writer for the given stream, onLine is called for each complete line
+func (logs *jobLogs) writer(stream LogStream, onLine func(line string)) *lineWriter {
+	file := logs.stdout
+	if stream == LogStreamStderr {
+		file = logs.stderr
+	}
+	return &lineWriter{
+		fn: func(line string) {
+			now := time.Now()
+			fmt.Fprintf(file, "%s %s\n", now.UTC().Format(time.RFC3339Nano), line)
+			if stream == LogStreamStderr {
+				logs.tail.Add(line)
+			}
+			if onLine != nil {
+				onLine(line)
+			}
+		},
+	}
+}
+
+// stderrTail returns the last lines written to stderr
+func (logs *jobLogs) stderrTail() string {
+	return strings.Join(logs.tail.Lines(), "\n")
+}
+
+func (logs *jobLogs) close() error {
+	err1 := logs.stdout.Close()
+	err2 := logs.stderr.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+type lineWriter struct {
+	buf []byte
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 || i > maxLogLineSize {
+			if len(w.buf) >= maxLogLineSize {
+				w.fn(string(w.buf[:maxLogLineSize]))
+				w.buf = w.buf[maxLogLineSize:]
+				continue
+			}
+			break
+		}
+		w.fn(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits the remaining output which is not terminated by a line break
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// rotatingFile is a size-limited log file. When the file exceeds maxSize, it is
+// renamed to path.1 (path.1 to path.2 and so on), and at most maxFiles files are kept.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		file:     file,
+		size:     stat.Size(),
+	}, nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxFiles > 1 {
+		for i := f.maxFiles - 2; i >= 1; i-- {
+			// error can be ignored if the file does not exist
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	f.file = file
+	f.size = 0
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// tailBuffer keeps the last n lines written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{lines: make([]string, n)}
+}
+
+func (b *tailBuffer) Add(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.lines) == 0 {
+		return
+	}
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *tailBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return append([]string{}, b.lines[:b.next]...)
+	}
+	return append(append([]string{}, b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+type LogOptions struct {
+	Follow bool
+	// number of lines to show from the end of the logs, negative value for all lines
+	Tail    int
+	Since   time.Time
+	Streams []LogStream
+}
+
+type LogEntry struct {
+	Stream LogStream `json:"stream"`
+	Time   time.Time `json:"time"`
+	Line   string    `json:"line"`
+}
+
+func parseLogEntry(stream LogStream, text string) (LogEntry, bool) {
+	text = strings.TrimSuffix(text, "\n")
+	ts, line, found := strings.Cut(text, " ")
+	if !found {
+		return LogEntry{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return LogEntry{}, false
+	}
+	return LogEntry{Stream: stream, Time: t, Line: line}, true
+}
+
+// rotatedLogFiles returns rotated files of a log file, from the oldest to the newest
+func rotatedLogFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	type rotated struct {
+		path  string
+		index int
+	}
+	files := []rotated{}
+	for _, match := range matches {
+		index, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil {
+			continue
+		}
+		files = append(files, rotated{path: match, index: index})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].index > files[j].index
+	})
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.path
+	}
+	return paths
+}
+
+// logFollower reads new entries of a log file as they are written,
+// and keeps reading from the new file when the log file is rotated
+type logFollower struct {
+	path    string
+	stream  LogStream
+	file    *os.File
+	reader  *bufio.Reader
+	partial string
+}
+
+func (f *logFollower) read() ([]LogEntry, error) {
+	if f.file == nil {
+		file, err := os.Open(f.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		f.file = file
+		f.reader = bufio.NewReader(file)
+		f.partial = ""
+	}
+	entries := []LogEntry{}
+	for {
+		text, err := f.reader.ReadString('\n')
+		if err == io.EOF {
+			f.partial += text
+			break
+		} else if err != nil {
+			return entries, err
+		}
+		if entry, ok := parseLogEntry(f.stream, f.partial+text); ok {
+			entries = append(entries, entry)
+		}
+		f.partial = ""
+	}
+
+	// if log file has been rotated, continue with the new one
+	if stat, err := os.Stat(f.path); err == nil {
+		if current, err := f.file.Stat(); err == nil && !os.SameFile(stat, current) {
+			f.close()
+			more, err := f.read()
+			return append(entries, more...), err
+		}
+	}
+	return entries, nil
+}
+
+func (f *logFollower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// readJobLogs reads logs from dir and calls fn for each entry. If options.Follow is set,
+// it keeps waiting for new entries until ctx is canceled or finished is closed.
+func readJobLogs(ctx context.Context, dir string, options *LogOptions, finished <-chan struct{}, fn func(LogEntry) error) error {
+	entries := []LogEntry{}
+	followers := []*logFollower{}
+	defer func() {
+		for _, f := range followers {
+			f.close()
+		}
+	}()
+	for _, stream := range options.Streams {
+		path := logFilePath(dir, stream)
+		for _, rotated := range rotatedLogFiles(path) {
+			f := logFollower{path: rotated, stream: stream}
+			lines, err := f.read()
+			f.close()
+			if err != nil {
+				return err
+			}
+			entries = append(entries, lines...)
+		}
+		f := &logFollower{path: path, stream: stream}
+		followers = append(followers, f)
+		lines, err := f.read()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, lines...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if !options.Since.IsZero() {
+		i := sort.Search(len(entries), func(i int) bool {
+			return !entries[i].Time.Before(options.Since)
+		})
+		entries = entries[i:]
+	}
+	if options.Tail >= 0 && options.Tail < len(entries) {
+		entries = entries[len(entries)-options.Tail:]
+	}
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if !options.Follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		done := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-finished:
+			// read what is left and stop
+			done = true
+		case <-ticker.C:
+		}
+		for _, f := range followers {
+			lines, err := f.read()
+			if err != nil {
+				return err
+			}
+			for _, entry := range lines {
+				if entry.Time.Before(options.Since) {
+					continue
+				}
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// logRetention removes logs of completed jobs once retention expires. Logs of a job are kept
+// as <dir>/<job id>/<run>, so that a job which runs again, e.g. when it is reassigned, does not
+// overwrite logs of its earlier run.
+type logRetention struct {
+	dir       string
+	retention time.Duration
+
+	mu sync.Mutex
+	// pending removals by job id
+	timers map[string]*time.Timer
+}
+
+func newLogRetention(dir string, retention time.Duration) *logRetention {
+	return &logRetention{dir: dir, retention: retention, timers: map[string]*time.Timer{}}
+}
+
+// reuse cancels the pending removal of logs of a job which runs again,
+// logs of its earlier runs are removed along with those of the new run
+func (r *logRetention) reuse(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if timer := r.timers[id]; timer != nil {
+		timer.Stop()
+		delete(r.timers, id)
+	}
+}
+
+// expire removes logs of run and of earlier runs of the job once retention expires
+func (r *logRetention) expire(id string, run string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if timer := r.timers[id]; timer != nil {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(r.retention, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.timers[id] != timer {
+			// the job runs again
+			return
+		}
+		delete(r.timers, id)
+		removeJobLogs(filepath.Join(r.dir, id), func(name string, _ time.Time) bool {
+			return compareRuns(name, run) <= 0
+		})
+	})
+	r.timers[id] = timer
+}
+
+// compareRuns compares names of runs, which are unix nano times of their creation
+func compareRuns(a string, b string) int {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// removeJobLogs removes runs in the log dir of a job for which remove returns true,
+// remove is given the name of run and the last time its logs were written.
+// The dir of job is removed if no run is left.
+func removeJobLogs(dir string, remove func(run string, modTime time.Time) bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !remove(entry.Name(), lastModified(path)) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("fail to remove job logs")
+		}
+	}
+	// it fails if any run is left
+	os.Remove(dir)
+}
+
+// lastModified returns the latest modification time of dir and files in it
+func lastModified(dir string) time.Time {
+	var t time.Time
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+		return nil
+	})
+	return t
+}
+
+// pruneJobLogs removes logs which have not been written for retention, e.g. when the engine
+// is restarted before they expire
+func pruneJobLogs(dir string, retention time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		removeJobLogs(filepath.Join(dir, entry.Name()), func(_ string, modTime time.Time) bool {
+			return time.Since(modTime) >= retention
+		})
+	}
+}
+
+// latestJobLogs returns the log dir of the latest run of a job, "" if there is none
+func latestJobLogs(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	latest := ""
+	for _, entry := range entries {
+		if entry.IsDir() && (latest == "" || compareRuns(entry.Name(), latest) > 0) {
+			latest = entry.Name()
+		}
+	}
+	if latest == "" {
+		return ""
+	}
+	return filepath.Join(dir, latest)
+}
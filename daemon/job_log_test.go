@@ -0,0 +1,305 @@
+package daemon_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestLineWriter(t *testing.T) {
+	long := strings.Repeat("x", daemon.MaxLogLineSize)
+	tests := []struct {
+		writes []string
+		// lines emitted by writes, and those by flush
+		lines   []string
+		flushed []string
+	}{
+		{[]string{"a\nb\n"}, []string{"a", "b"}, nil},
+		// partial lines are joined until a line break
+		{[]string{"a", "b\nc"}, []string{"ab"}, []string{"c"}},
+		{[]string{"a", "", "b", "\n"}, []string{"ab"}, nil},
+		{[]string{"a\r\n\n"}, []string{"a", ""}, nil},
+		{[]string{"a\r"}, nil, []string{"a"}},
+		// long output without line break is split
+		{[]string{long + "yz\n"}, []string{long, "yz"}, nil},
+		{[]string{long[:10], long[10:] + "y"}, []string{long}, []string{"y"}},
+	}
+	for i, tt := range tests {
+		lines := []string{}
+		w := daemon.NewLineWriter(func(line string) {
+			lines = append(lines, line)
+		})
+		for _, s := range tt.writes {
+			if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+				t.Fatalf("%d: write %d bytes, err %v", i, n, err)
+			}
+		}
+		if !slices.Equal(lines, append([]string{}, tt.lines...)) {
+			t.Errorf("%d: expect lines %q, got %q", i, tt.lines, lines)
+		}
+		lines = []string{}
+		w.Flush()
+		if !slices.Equal(lines, append([]string{}, tt.flushed...)) {
+			t.Errorf("%d: expect flushed lines %q, got %q", i, tt.flushed, lines)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		maxSize  int64
+		maxFiles int
+		writes   []string
+		// content of the file and rotated ones, "" for those which do not exist
+		files []string
+	}{
+		{10, 3, []string{"1111\n", "2222\n"}, []string{"1111\n2222\n", "", ""}},
+		{10, 3, []string{"1111\n", "2222\n", "3\n"}, []string{"3\n", "1111\n2222\n", ""}},
+		// the oldest file is removed
+		{10, 3, []string{"11111\n", "22222\n", "33333\n", "44444\n"}, []string{"44444\n", "33333\n", "22222\n", ""}},
+		// a write larger than max size is kept whole
+		{4, 2, []string{"111111\n", "2\n"}, []string{"2\n", "111111\n", ""}},
+		// only the current file is kept
+		{10, 1, []string{"11111\n", "22222\n"}, []string{"22222\n", ""}},
+		// never rotated
+		{0, 3, []string{"11111\n", "22222\n"}, []string{"11111\n22222\n", ""}},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(t.TempDir(), "stdout.log")
+		f, err := daemon.OpenRotatingFile(path, tt.maxSize, tt.maxFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tt.writes {
+			if _, err := f.Write([]byte(s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for j, expect := range tt.files {
+			name := path
+			if j > 0 {
+				name = fmt.Sprintf("%s.%d", path, j)
+			}
+			content, err := os.ReadFile(name)
+			if expect == "" && !os.IsNotExist(err) {
+				t.Errorf("%d: expect no file %s, got %q", i, filepath.Base(name), content)
+			} else if expect != "" && string(content) != expect {
+				t.Errorf("%d: expect %s to be %q, got %q, err %v", i, filepath.Base(name), expect, content, err)
+			}
+		}
+	}
+}
+
+func TestRotatingFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdout.log")
+	checkErr(os.WriteFile(path, []byte("11111\n"), 0644))
+	// the size of an existing file counts
+	f, err := daemon.OpenRotatingFile(path, 10, 2)
+	checkErr(err)
+	_, err = f.Write([]byte("22222\n"))
+	checkErr(err)
+	checkErr(f.Close())
+	if _, err := f.Write([]byte("3\n")); err == nil {
+		t.Error("expect error writing to a closed file")
+	}
+	for name, expect := range map[string]string{path: "22222\n", path + ".1": "11111\n"} {
+		if content, _ := os.ReadFile(name); string(content) != expect {
+			t.Errorf("expect %s to be %q, got %q", filepath.Base(name), expect, content)
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		size  int
+		added int
+		lines []string
+	}{
+		{3, 0, []string{}},
+		{3, 2, []string{"0", "1"}},
+		{3, 3, []string{"0", "1", "2"}},
+		{3, 4, []string{"1", "2", "3"}},
+		{3, 7, []string{"4", "5", "6"}},
+		{0, 2, []string{}},
+	}
+	for i, tt := range tests {
+		b := daemon.NewTailBuffer(tt.size)
+		for j := 0; j < tt.added; j++ {
+			b.Add(fmt.Sprint(j))
+		}
+		if lines := b.Lines(); !slices.Equal(lines, tt.lines) {
+			t.Errorf("%d: expect %q, got %q", i, tt.lines, lines)
+		}
+	}
+}
+
+var logStart = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// logLines formats lines as written to log files, the n-th line is written n seconds after logStart
+func logLines(lines map[int]string) string {
+	keys := []int{}
+	for k := range lines {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s\n", logStart.Add(time.Duration(k)*time.Second).Format(time.RFC3339Nano), lines[k])
+	}
+	return b.String()
+}
+
+// writeJobLogs writes log files of stdout and stderr into a new dir
+func writeJobLogs(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"stdout.log.2": logLines(map[int]string{0: "out 0"}),
+		"stdout.log.1": logLines(map[int]string{2: "out 2"}),
+		"stdout.log":   logLines(map[int]string{4: "out 4", 5: "out 5"}) + "malformed line\n",
+		"stderr.log":   logLines(map[int]string{1: "err 1", 3: "err 3", 6: "err 6"}),
+	}
+	for name, content := range files {
+		checkErr(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func readLines(ctx context.Context, dir string, options *daemon.LogOptions, finished <-chan struct{}) ([]string, error) {
+	lines := []string{}
+	err := daemon.ReadJobLogs(ctx, dir, options, finished, func(entry daemon.LogEntry) error {
+		lines = append(lines, entry.Line)
+		return nil
+	})
+	return lines, err
+}
+
+func TestReadJobLogs(t *testing.T) {
+	dir := writeJobLogs(t)
+	all := []daemon.LogStream{daemon.LogStreamStdout, daemon.LogStreamStderr}
+	tests := []struct {
+		options daemon.LogOptions
+		lines   []string
+	}{
+		// stdout and stderr are merged by time, rotated files included
+		{daemon.LogOptions{Tail: -1, Streams: all}, []string{"out 0", "err 1", "out 2", "err 3", "out 4", "out 5", "err 6"}},
+		{daemon.LogOptions{Tail: 3, Streams: all}, []string{"out 4", "out 5", "err 6"}},
+		{daemon.LogOptions{Tail: 0, Streams: all}, []string{}},
+		{daemon.LogOptions{Tail: 100, Streams: all}, []string{"out 0", "err 1", "out 2", "err 3", "out 4", "out 5", "err 6"}},
+		{daemon.LogOptions{Tail: -1, Streams: []daemon.LogStream{daemon.LogStreamStderr}}, []string{"err 1", "err 3", "err 6"}},
+		{daemon.LogOptions{Tail: 2, Streams: []daemon.LogStream{daemon.LogStreamStdout}}, []string{"out 4", "out 5"}},
+		{daemon.LogOptions{Tail: -1, Since: logStart.Add(3 * time.Second), Streams: all}, []string{"err 3", "out 4", "out 5", "err 6"}},
+		{daemon.LogOptions{Tail: 1, Since: logStart.Add(3 * time.Second), Streams: all}, []string{"err 6"}},
+	}
+	for i, tt := range tests {
+		lines, err := readLines(context.Background(), dir, &tt.options, nil)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !slices.Equal(lines, tt.lines) {
+			t.Errorf("%d: expect %q, got %q", i, tt.lines, lines)
+		}
+	}
+	// no logs are written yet
+	lines, err := readLines(context.Background(), t.TempDir(), &daemon.LogOptions{Tail: -1, Streams: all}, nil)
+	if err != nil || len(lines) != 0 {
+		t.Errorf("expect no lines of empty dir, got %q, err %v", lines, err)
+	}
+}
+
+func TestReadJobLogsFollow(t *testing.T) {
+	dir := writeJobLogs(t)
+	finished := make(chan struct{})
+	type result struct {
+		lines []string
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		lines, err := readLines(context.Background(), dir, &daemon.LogOptions{
+			Follow:  true,
+			Tail:    1,
+			Streams: []daemon.LogStream{daemon.LogStreamStdout, daemon.LogStreamStderr},
+		}, finished)
+		done <- result{lines, err}
+	}()
+	appendLog := func(name string, content string) {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		checkErr(err)
+		_, err = f.WriteString(content)
+		checkErr(err)
+		checkErr(f.Close())
+	}
+	time.Sleep(300 * time.Millisecond)
+	// a line is written in two parts
+	line := logLines(map[int]string{7: "out 7"})
+	appendLog("stdout.log", line[:10])
+	time.Sleep(300 * time.Millisecond)
+	appendLog("stdout.log", line[10:])
+	time.Sleep(300 * time.Millisecond)
+	// the file is rotated
+	checkErr(os.Rename(filepath.Join(dir, "stdout.log"), filepath.Join(dir, "stdout.log.1")))
+	appendLog("stdout.log", logLines(map[int]string{8: "out 8"}))
+	time.Sleep(300 * time.Millisecond)
+	appendLog("stderr.log", logLines(map[int]string{9: "err 9"}))
+	close(finished)
+
+	select {
+	case r := <-done:
+		expect := []string{"err 6", "out 7", "out 8", "err 9"}
+		if r.err != nil || !slices.Equal(r.lines, expect) {
+			t.Errorf("expect %q, got %q, err %v", expect, r.lines, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("following logs does not stop once finished")
+	}
+}
+
+func TestPruneJobLogs(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	runs := []struct {
+		path string
+		old  bool
+	}{
+		{"a/100", true},
+		{"a/200", false},
+		{"b/100", true},
+		{"c/300", false},
+	}
+	for _, run := range runs {
+		path := filepath.Join(dir, run.path)
+		checkErr(os.MkdirAll(path, os.ModePerm))
+		checkErr(os.WriteFile(filepath.Join(path, "stdout.log"), nil, 0644))
+		if run.old {
+			checkErr(os.Chtimes(filepath.Join(path, "stdout.log"), old, old))
+			checkErr(os.Chtimes(path, old, old))
+		}
+	}
+	daemon.PruneJobLogs(dir, 24*time.Hour)
+	for _, run := range runs {
+		_, err := os.Stat(filepath.Join(dir, run.path))
+		if run.old != os.IsNotExist(err) {
+			t.Errorf("expect %s removed %v, got err %v", run.path, run.old, err)
+		}
+	}
+	// dirs of jobs without runs are removed
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("expect dir of job without runs removed, got err %v", err)
+	}
+	if latest := daemon.LatestJobLogs(filepath.Join(dir, "a")); latest != filepath.Join(dir, "a/200") {
+		t.Errorf("expect latest run a/200, got %s", latest)
+	}
+	if latest := daemon.LatestJobLogs(filepath.Join(dir, "b")); latest != "" {
+		t.Errorf("expect no run of b, got %s", latest)
+	}
+}
@@ -0,0 +1,221 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+// jobPause tracks whether a job is paused by user or suspended by policy. While paused,
+// transfers of the job are blocked, and the container of its task is frozen.
+type jobPause struct {
+	byUser    bool
+	byPolicy  bool
+	since     time.Time
+	total     time.Duration
+	resumed   chan struct{}
+	executing bool
+	// whether the container is frozen, it is only changed with freezeMu held
+	frozen bool
+	// the container is also frozen while its checkpoint is being snapshotted
+	snapshotting bool
+}
+
+func (p *jobPause) paused() bool {
+	return p.byUser || p.byPolicy
+}
+
+// Pause pauses the job by user until Resume is called
+func (job *Job) Pause() error {
+	return job.updatePause(func(p *jobPause) { p.byUser = true })
+}
+
+func (job *Job) Resume() error {
+	return job.updatePause(func(p *jobPause) { p.byUser = false })
+}
+
+// suspend pauses or resumes the job by policy, a job paused by user keeps paused after resumed by policy
+func (job *Job) suspend(suspend bool) error {
+	return job.updatePause(func(p *jobPause) { p.byPolicy = suspend })
+}
+
+func (job *Job) updatePause(update func(p *jobPause)) error {
+	job.pauseMu.Lock()
+	p := &job.pause
+	was := p.paused()
+	update(p)
+	paused := p.paused()
+	if was == paused {
+		job.pauseMu.Unlock()
+		return nil
+	}
+	if paused {
+		p.since = time.Now()
+		p.resumed = make(chan struct{})
+	} else {
+		p.total += time.Since(p.since)
+		close(p.resumed)
+		p.resumed = nil
+	}
+	byUser := p.byUser
+	job.pauseMu.Unlock()
+	err := job.syncFreeze()
+
+	if paused {
+		job.logger.Info().Bool("byUser", byUser).Msg("job paused")
+	} else {
+		job.logger.Info().Msg("job resumed")
+	}
+	job.notifyStatusToRemote(JobNotification{})
+	return err
+}
+
+// syncFreeze freezes the container while the task is executing and the job is paused or snapshotted.
+// It is called after either changes. Docker may take long, so pauseMu, which status of the job depends on,
+// is not held meanwhile, calls of docker are serialized by freezeMu instead.
+func (job *Job) syncFreeze() error {
+	job.freezeMu.Lock()
+	defer job.freezeMu.Unlock()
+	job.pauseMu.Lock()
+	p := &job.pause
+	freeze := (p.paused() || p.snapshotting) && p.executing
+	frozen := p.frozen
+	job.pauseMu.Unlock()
+	if freeze == frozen {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var err error
+	if freeze {
+		err = job.container.pause(ctx)
+	} else {
+		err = job.container.unpause(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("fail to freeze or unfreeze container: %w", err)
+	}
+	job.pauseMu.Lock()
+	p.frozen = freeze
+	job.pauseMu.Unlock()
+	return nil
+}
+
+// setExecuting marks whether the task is executing in the container, which can only be frozen meanwhile
+func (job *Job) setExecuting(executing bool) {
+	job.pauseMu.Lock()
+	job.pause.executing = executing
+	job.pauseMu.Unlock()
+	if err := job.syncFreeze(); err != nil {
+		job.logger.Warn().Err(err).Send()
+	}
+}
+
+// frozen runs fn while the container is frozen, so that files written by the task do not change meanwhile
+func (job *Job) frozen(fn func() error) error {
+	job.pauseMu.Lock()
+	job.pause.snapshotting = true
+	job.pauseMu.Unlock()
+	err := job.syncFreeze()
+	if err == nil {
+		err = fn()
+	}
+	job.pauseMu.Lock()
+	job.pause.snapshotting = false
+	job.pauseMu.Unlock()
+	return errors.Join(err, job.syncFreeze())
+}
+
+func (job *Job) isPaused() bool {
+	job.pauseMu.Lock()
+	defer job.pauseMu.Unlock()
+	return job.pause.paused()
+}
+
+// bandwidthLimit implements throttled, downloads of jobs fetched ahead are throttled
+func (job *Job) bandwidthLimit() int64 {
+	if job.ahead.Load() {
+		return job.config.LookaheadBandwidth
+	}
+	return 0
+}
+
+// pausedFor returns how long the job has been paused in total
+func (job *Job) pausedFor() time.Duration {
+	job.pauseMu.Lock()
+	defer job.pauseMu.Unlock()
+	total := job.pause.total
+	if job.pause.paused() {
+		total += time.Since(job.pause.since)
+	}
+	return total
+}
+
+// waitResumed blocks while the job is paused
+func (job *Job) waitResumed(ctx context.Context) error {
+	job.pauseMu.Lock()
+	resumed := job.pause.resumed
+	job.pauseMu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// stageContext returns a context which is canceled with ErrJobTimeout after the stage runs
+// for timeout, time while the job is paused does not count
+func (job *Job) stageContext(stage string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	start := job.pausedFor()
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		var extended time.Duration
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			if paused := job.pausedFor() - start; paused > extended {
+				timer.Reset(paused - extended)
+				extended = paused
+				continue
+			}
+			cancel(fmt.Errorf("%w: %s took more than %s", ErrJobTimeout, stage, timeout))
+			return
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// pausedFlag returns EEF_PAUSED if the job is paused, it is attached to notifications of the job
+func (job *Job) pausedFlag() uint64 {
+	if job.isPaused() {
+		return uint64(pb.EnumExecFlag_EEF_PAUSED)
+	}
+	return 0
+}
+
+// pausedReader blocks reading while the job is paused, so that uploads make no progress
+type pausedReader struct {
+	ctx context.Context
+	job *Job
+	r   io.Reader
+}
+
+func (r *pausedReader) Read(p []byte) (int, error) {
+	if err := r.job.waitResumed(r.ctx); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,93 @@
+package daemon_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+// doneWithin returns how long it takes ctx to be done, or false if it is not done within max
+func doneWithin(ctx context.Context, max time.Duration) (time.Duration, bool) {
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return time.Since(start), true
+	case <-time.After(max):
+		return max, false
+	}
+}
+
+func TestStageContext(t *testing.T) {
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{})
+	ctx, cancel := job.StageContext("downloading", 100*time.Millisecond)
+	defer cancel()
+	if d, ok := doneWithin(ctx, time.Second); !ok || d < 90*time.Millisecond {
+		t.Fatalf("expect timeout after 100ms, got %v %v", d, ok)
+	}
+	if err := context.Cause(ctx); !errors.Is(err, daemon.ErrJobTimeout) || err.Error() != "job timeout: downloading took more than 100ms" {
+		t.Errorf("expect %v, got %v", daemon.ErrJobTimeout, err)
+	}
+
+	// canceled before timeout
+	ctx, cancel = job.StageContext("downloading", time.Hour)
+	cancel()
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestStageContextPaused(t *testing.T) {
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{})
+	ctx, cancel := job.StageContext("uploading", 200*time.Millisecond)
+	defer cancel()
+	time.Sleep(100 * time.Millisecond)
+	// time while paused does not count
+	checkErr(job.Pause())
+	if d, ok := doneWithin(ctx, 300*time.Millisecond); ok {
+		t.Fatalf("expect no timeout while paused, got timeout after %v", d)
+	}
+	checkErr(job.Resume())
+	if d, ok := doneWithin(ctx, time.Second); !ok || d < 80*time.Millisecond {
+		t.Fatalf("expect timeout after 100ms more, got %v %v", d, ok)
+	}
+	if err := context.Cause(ctx); !errors.Is(err, daemon.ErrJobTimeout) {
+		t.Errorf("expect %v, got %v", daemon.ErrJobTimeout, err)
+	}
+
+	// paused before the stage starts
+	checkErr(job.Pause())
+	ctx, cancel = job.StageContext("uploading", 100*time.Millisecond)
+	defer cancel()
+	if d, ok := doneWithin(ctx, 300*time.Millisecond); ok {
+		t.Fatalf("expect no timeout while paused, got timeout after %v", d)
+	}
+	checkErr(job.Resume())
+	if _, ok := doneWithin(ctx, time.Second); !ok {
+		t.Fatal("expect timeout after resumed")
+	}
+}
+
+// status and notifications read the job while it is paused by user, they must not race with stages
+// of the job, which is checked by go test -race
+func TestPauseWhileFailing(t *testing.T) {
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			job.Fail(errors.New("stage failed"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		checkErr(job.Pause())
+		job.Status()
+		checkErr(job.Resume())
+	}
+	<-done
+	if status := job.Status(); status.Err == nil || status.Err.Error() != "stage failed" {
+		t.Errorf("expect error of job, got %v", status.Err)
+	}
+}
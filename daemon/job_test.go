@@ -1,5 +1,105 @@
 package daemon_test
 
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+// watchTask watches a task which stalls after 100ms without output, it returns a channel receiving
+// the cause of killing the task, and a function to stop watching
+func watchTask(job *daemon.Job, timeout time.Duration) (<-chan error, func()) {
+	ctx, cancel := job.StageContext("running", timeout)
+	ctx, stall := context.WithCancelCause(ctx)
+	killed := make(chan error, 1)
+	done := make(chan struct{})
+	go job.WatchTask(ctx, stall, done, func() {
+		killed <- context.Cause(ctx)
+	})
+	return killed, func() {
+		close(done)
+		stall(nil)
+		cancel()
+	}
+}
+
+func TestWatchTaskStalled(t *testing.T) {
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{StallTimeout: 100 * time.Millisecond})
+	killed, stop := watchTask(job, time.Hour)
+	defer stop()
+	// output keeps the task alive
+	for i := 0; i < 10; i++ {
+		time.Sleep(30 * time.Millisecond)
+		job.Touch()
+	}
+	select {
+	case err := <-killed:
+		t.Fatalf("expect task alive while it writes output, got killed by %v", err)
+	default:
+	}
+	select {
+	case err := <-killed:
+		if !errors.Is(err, daemon.ErrJobStalled) {
+			t.Errorf("expect %v, got %v", daemon.ErrJobStalled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect task killed once it stalls")
+	}
+}
+
+func TestWatchTaskPaused(t *testing.T) {
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{StallTimeout: 100 * time.Millisecond})
+	checkErr(job.Pause())
+	killed, stop := watchTask(job, time.Hour)
+	defer stop()
+	// a paused task makes no output, but it is not stalled
+	select {
+	case err := <-killed:
+		t.Fatalf("expect paused task alive, got killed by %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	checkErr(job.Resume())
+	start := time.Now()
+	select {
+	case err := <-killed:
+		if d := time.Since(start); !errors.Is(err, daemon.ErrJobStalled) || d < 80*time.Millisecond {
+			t.Errorf("expect %v 100ms after resumed, got %v after %v", daemon.ErrJobStalled, err, d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect task killed once it stalls after resumed")
+	}
+}
+
+func TestWatchTaskTimeout(t *testing.T) {
+	// stall detection is disabled
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{})
+	killed, stop := watchTask(job, 100*time.Millisecond)
+	defer stop()
+	select {
+	case err := <-killed:
+		if !errors.Is(err, daemon.ErrJobTimeout) {
+			t.Errorf("expect %v, got %v", daemon.ErrJobTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect task killed once it times out")
+	}
+}
+
+func TestWatchTaskDone(t *testing.T) {
+	job := daemon.NewTestJob(&daemon.SchedulerConfig{StallTimeout: 50 * time.Millisecond})
+	killed, stop := watchTask(job, 50*time.Millisecond)
+	// the task exits before it stalls or times out
+	stop()
+	select {
+	case err := <-killed:
+		t.Fatalf("expect exited task not killed, got killed by %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
 // func TestFileUpload(t *testing.T) {
 // 	err := core.Init(&core.Config{
 // 		GrpcAddress: "localhost:50051",
@@ -0,0 +1,258 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+var ErrNotifierClosed = errors.New("notifier closed")
+
+// max size of message in a batch of log lines
+const maxNotificationBatchSize = 64 * 1024
+
+// max attempts of sending a state transition, others are sent once
+const maxNotifyAttempts = 4
+
+// notifier sends notifications of a job to remote in a separate goroutine.
+// State transitions are always delivered in order, progress updates with the same id
+// are coalesced, only the latest gpu stats are kept, and log lines are batched into a bounded buffer which drops the oldest
+// lines when it is full. Progress and log lines are sent at most once per interval, they are never coalesced
+// across a state transition, so that the server receives each of them on the same side of the transition as it happens.
+// A failed state transition is retried with backoff, a failure of others is only logged,
+// and later notifications are sent regardless of earlier failures.
+type notifier struct {
+	send     func(*pb.ExecNotificationRequest) error
+	interval time.Duration
+	maxLines int
+	logger   zerolog.Logger
+
+	mu            sync.Mutex
+	critical      []stateNotification
+	progress      map[string]*pb.ExecNotificationRequest
+	progressOrder []string
+	gpuStats      *pb.ExecNotificationRequest
+	lines         []*pb.ExecNotificationRequest
+	dropped       int
+	closing       bool
+	// the first state transition which fails to be sent after all attempts
+	err error
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// stateNotification is a state transition, with the notifications pending before it, which are sent ahead of it
+type stateNotification struct {
+	before []*pb.ExecNotificationRequest
+	req    *pb.ExecNotificationRequest
+}
+
+func newNotifier(send func(*pb.ExecNotificationRequest) error, interval time.Duration, maxLines int, logger zerolog.Logger) *notifier {
+	n := &notifier{
+		send:     send,
+		interval: interval,
+		maxLines: maxLines,
+		logger:   logger,
+		progress: map[string]*pb.ExecNotificationRequest{},
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go n.loop()
+	return n
+}
+
+// notifyState enqueues a notification which is never dropped, nor coalesced,
+// it returns the error of an earlier one which failed to be sent
+func (n *notifier) notifyState(req *pb.ExecNotificationRequest) error {
+	return n.enqueue(func() {
+		n.critical = append(n.critical, stateNotification{before: n.takePending(), req: req})
+	})
+}
+
+// notifyProgress enqueues a progress notification, replacing any pending one with the same id
+func (n *notifier) notifyProgress(req *pb.ExecNotificationRequest) error {
+	return n.enqueue(func() {
+		if _, ok := n.progress[req.Id]; !ok {
+			n.progressOrder = append(n.progressOrder, req.Id)
+		}
+		n.progress[req.Id] = req
+	})
+}
+
+// notifyGpuStats enqueues gpu stats, replacing any pending ones
+func (n *notifier) notifyGpuStats(req *pb.ExecNotificationRequest) error {
+	return n.enqueue(func() {
+		n.gpuStats = req
+	})
+}
+
+// notifyLog enqueues a log line, the oldest line is dropped if buffer is full
+func (n *notifier) notifyLog(req *pb.ExecNotificationRequest) error {
+	return n.enqueue(func() {
+		if len(n.lines) >= n.maxLines {
+			n.lines = n.lines[1:]
+			n.dropped++
+		}
+		n.lines = append(n.lines, req)
+	})
+}
+
+func (n *notifier) enqueue(fn func()) error {
+	n.mu.Lock()
+	if n.closing {
+		n.mu.Unlock()
+		return ErrNotifierClosed
+	}
+	fn()
+	err := n.err
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// close sends all pending notifications and stops the notifier,
+// it returns the first error of sending state transitions if any
+func (n *notifier) close() error {
+	n.mu.Lock()
+	n.closing = true
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	<-n.done
+	return n.err
+}
+
+func (n *notifier) loop() {
+	defer close(n.done)
+	var lastFlush time.Time
+	for {
+		n.mu.Lock()
+		closing := n.closing
+		urgent := len(n.critical) > 0 || closing
+		pending := len(n.lines) > 0 || len(n.progress) > 0 || n.gpuStats != nil
+		n.mu.Unlock()
+
+		if urgent || (pending && time.Since(lastFlush) >= n.interval) {
+			n.flush()
+			lastFlush = time.Now()
+			if closing {
+				return
+			}
+			continue
+		}
+
+		var wait <-chan time.Time
+		if pending {
+			wait = time.After(n.interval - time.Since(lastFlush))
+		}
+		select {
+		case <-n.wake:
+		case <-wait:
+		}
+	}
+}
+
+// takePending takes log lines, progress and gpu stats pending, it is called with mu held
+func (n *notifier) takePending() []*pb.ExecNotificationRequest {
+	reqs := batchLogLines(n.lines, n.dropped)
+	for _, id := range n.progressOrder {
+		reqs = append(reqs, n.progress[id])
+	}
+	if n.gpuStats != nil {
+		reqs = append(reqs, n.gpuStats)
+	}
+	n.lines, n.dropped, n.gpuStats = nil, 0, nil
+	n.progress, n.progressOrder = map[string]*pb.ExecNotificationRequest{}, nil
+	return reqs
+}
+
+// flush sends notifications in the order they happen, each state transition follows the output before it
+func (n *notifier) flush() {
+	n.mu.Lock()
+	critical := n.critical
+	n.critical = nil
+	pending := n.takePending()
+	n.mu.Unlock()
+
+	for _, state := range critical {
+		n.sendAll(state.before)
+		req := state.req
+		if err := n.sendCritical(req); err != nil {
+			n.logger.Warn().Err(err).Str("state", req.State.String()).Msg("fail to send notification")
+			n.mu.Lock()
+			if n.err == nil {
+				n.err = err
+			}
+			n.mu.Unlock()
+		}
+	}
+	n.sendAll(pending)
+}
+
+// sendAll sends reqs once, a failure is only logged
+func (n *notifier) sendAll(reqs []*pb.ExecNotificationRequest) {
+	for _, req := range reqs {
+		if err := n.send(req); err != nil {
+			n.logger.Debug().Err(err).Msg("fail to send notification")
+		}
+	}
+}
+
+// sendCritical sends req, and retries with backoff if it fails
+func (n *notifier) sendCritical(req *pb.ExecNotificationRequest) error {
+	var err error
+	for attempt := 0; attempt < maxNotifyAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(n.interval << (attempt - 1))
+		}
+		if err = n.send(req); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// batchLogLines joins log lines into as few notifications as possible
+func batchLogLines(lines []*pb.ExecNotificationRequest, dropped int) []*pb.ExecNotificationRequest {
+	reqs := []*pb.ExecNotificationRequest{}
+	var (
+		batch *pb.ExecNotificationRequest
+		sb    strings.Builder
+	)
+	if dropped > 0 {
+		fmt.Fprintf(&sb, "[%d lines dropped]", dropped)
+	}
+	for _, line := range lines {
+		if batch != nil && sb.Len()+len(line.Message) >= maxNotificationBatchSize {
+			batch.Message = sb.String()
+			reqs = append(reqs, batch)
+			batch = nil
+			sb.Reset()
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(line.Message)
+		// the latest state is used for the whole batch
+		batch = &pb.ExecNotificationRequest{
+			State: line.State,
+			Flag:  line.Flag,
+		}
+	}
+	if batch != nil {
+		batch.Message = sb.String()
+		reqs = append(reqs, batch)
+	}
+	return reqs
+}
@@ -0,0 +1,173 @@
+package daemon_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+)
+
+// sender records notifications sent, and fails those for which fail returns true
+type sender struct {
+	mu   sync.Mutex
+	sent []string
+	fail func(req *pb.ExecNotificationRequest) bool
+	// if set, the first send blocks until it is closed, started is closed once the first send begins
+	release chan struct{}
+	started chan struct{}
+}
+
+func (s *sender) send(req *pb.ExecNotificationRequest) error {
+	s.mu.Lock()
+	first := len(s.sent) == 0
+	desc := req.Message
+	if req.Id != "" {
+		desc = req.Id + "=" + desc
+	}
+	failed := s.fail != nil && s.fail(req)
+	if failed {
+		desc += " (failed)"
+	}
+	s.sent = append(s.sent, desc)
+	s.mu.Unlock()
+	if first && s.release != nil {
+		close(s.started)
+		<-s.release
+	}
+	if failed {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (s *sender) notifications() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sent...)
+}
+
+func TestNotifier(t *testing.T) {
+	s := &sender{release: make(chan struct{}), started: make(chan struct{})}
+	n := daemon.NewNotifier(s.send, time.Hour, 3)
+	checkErr(n.NotifyState(&pb.ExecNotificationRequest{Message: "state 1"}))
+	// the rest is queued while the first notification is being sent
+	<-s.started
+	checkErr(n.NotifyLog(&pb.ExecNotificationRequest{Message: "line 1"}))
+	checkErr(n.NotifyProgress(&pb.ExecNotificationRequest{Id: "a", Message: "10"}))
+	checkErr(n.NotifyGpuStats(&pb.ExecNotificationRequest{Message: "gpu 1"}))
+	checkErr(n.NotifyState(&pb.ExecNotificationRequest{Message: "state 2"}))
+	checkErr(n.NotifyProgress(&pb.ExecNotificationRequest{Id: "b", Message: "20"}))
+	checkErr(n.NotifyProgress(&pb.ExecNotificationRequest{Id: "a", Message: "30"}))
+	for i := 2; i <= 5; i++ {
+		checkErr(n.NotifyLog(&pb.ExecNotificationRequest{Message: fmt.Sprintf("line %d", i)}))
+	}
+	checkErr(n.NotifyGpuStats(&pb.ExecNotificationRequest{Message: "gpu 2"}))
+	checkErr(n.NotifyState(&pb.ExecNotificationRequest{Message: "state 3"}))
+	close(s.release)
+	checkErr(n.Close())
+
+	expect := []string{
+		"state 1",
+		// notifications before a state transition are sent ahead of it, they are not coalesced with later ones
+		"line 1",
+		"a=10",
+		"gpu 1",
+		"state 2",
+		// the oldest lines are dropped, the rest are batched
+		"[1 lines dropped]\nline 3\nline 4\nline 5",
+		// progress is coalesced by id, in the order ids first appear
+		"b=20",
+		"a=30",
+		// only the latest gpu stats are kept
+		"gpu 2",
+		// state transitions are never dropped
+		"state 3",
+	}
+	if sent := s.notifications(); !slices.Equal(sent, expect) {
+		t.Errorf("expect %q, got %q", expect, sent)
+	}
+	if err := n.NotifyState(&pb.ExecNotificationRequest{}); !errors.Is(err, daemon.ErrNotifierClosed) {
+		t.Errorf("expect %v after close, got %v", daemon.ErrNotifierClosed, err)
+	}
+}
+
+func TestNotifierInterval(t *testing.T) {
+	s := &sender{}
+	n := daemon.NewNotifier(s.send, 200*time.Millisecond, 100)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		checkErr(n.NotifyProgress(&pb.ExecNotificationRequest{Id: "a", Message: fmt.Sprint(i)}))
+		time.Sleep(50 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	checkErr(n.Close())
+	sent := s.notifications()
+	// the first is sent at once, then at most once per interval, and the last one on close
+	if max := int(elapsed/(200*time.Millisecond)) + 2; len(sent) > max {
+		t.Errorf("expect at most %d notifications, got %q", max, sent)
+	}
+	if len(sent) == 0 || sent[len(sent)-1] != "a=9" {
+		t.Errorf("expect the latest progress sent last, got %q", sent)
+	}
+}
+
+func TestNotifierBatch(t *testing.T) {
+	s := &sender{release: make(chan struct{}), started: make(chan struct{})}
+	n := daemon.NewNotifier(s.send, time.Hour, 100)
+	checkErr(n.NotifyState(&pb.ExecNotificationRequest{Message: "state"}))
+	<-s.started
+	line := strings.Repeat("x", 30*1024)
+	for i := 0; i < 5; i++ {
+		checkErr(n.NotifyLog(&pb.ExecNotificationRequest{Message: line}))
+	}
+	close(s.release)
+	checkErr(n.Close())
+	sent := s.notifications()
+	// lines are batched within 64 KiB
+	batches := []int{}
+	for _, message := range sent[1:] {
+		batches = append(batches, strings.Count(message, line))
+	}
+	if expect := []int{2, 2, 1}; !slices.Equal(batches, expect) {
+		t.Errorf("expect batches of %v lines, got %v", expect, batches)
+	}
+}
+
+func TestNotifierRetry(t *testing.T) {
+	failures := map[string]int{"state 1": 2, "state 2": 100, "line": 1}
+	s := &sender{fail: func(req *pb.ExecNotificationRequest) bool {
+		failures[req.Message]--
+		return failures[req.Message] >= 0
+	}}
+	n := daemon.NewNotifier(s.send, 10*time.Millisecond, 100)
+	checkErr(n.NotifyLog(&pb.ExecNotificationRequest{Message: "line"}))
+	time.Sleep(50 * time.Millisecond)
+	// a failure of output lines is not retried nor reported
+	checkErr(n.NotifyState(&pb.ExecNotificationRequest{Message: "state 1"}))
+	time.Sleep(100 * time.Millisecond)
+	checkErr(n.NotifyState(&pb.ExecNotificationRequest{Message: "state 2"}))
+	time.Sleep(200 * time.Millisecond)
+	// later notifications are still sent after one fails
+	err := n.NotifyState(&pb.ExecNotificationRequest{Message: "state 3"})
+	if err == nil {
+		t.Error("expect the failure of state 2 reported")
+	}
+	if err := n.Close(); err == nil {
+		t.Error("expect the failure of state 2 reported on close")
+	}
+	expect := []string{
+		"line (failed)",
+		"state 1 (failed)", "state 1 (failed)", "state 1",
+		"state 2 (failed)", "state 2 (failed)", "state 2 (failed)", "state 2 (failed)",
+		"state 3",
+	}
+	if sent := s.notifications(); !slices.Equal(sent, expect) {
+		t.Errorf("expect %q, got %q", expect, sent)
+	}
+}
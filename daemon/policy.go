@@ -0,0 +1,263 @@
+package daemon
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// temperatures have to drop this much below their max before jobs are resumed
+const temperatureMargin = 5
+
+type PolicyConfig struct {
+	// interval of evaluating policies
+	Interval time.Duration
+	// time windows in local time when jobs may run, e.g. "22:00-07:00", empty to run at any time
+	RunWindows []string
+	// the host is not idle if processes other than jobs use more cpu than this percentage, 0 to disable
+	IdleCpu float64
+	// the host is not idle until all interactive sessions are idle for this period, 0 to disable
+	IdleSession time.Duration
+	// only run jobs on AC power
+	RequireAC bool
+	// max temperatures in celsius, jobs back off when exceeded, 0 to disable
+	MaxCpuTemperature float64
+	MaxGpuTemperature float64
+	// rules whose denial also suspends running jobs, otherwise only fetching new jobs stops
+	Suspend []string
+}
+
+func (config PolicyConfig) withDefaults() PolicyConfig {
+	if config.Interval <= 0 {
+		config.Interval = 30 * time.Second
+	}
+	return config
+}
+
+// PolicyVerdict is the decision of a rule about whether jobs may run
+type PolicyVerdict struct {
+	Rule  string
+	Allow bool
+	// running jobs are suspended, only set if not allowed
+	Suspend bool
+	Reason  string
+}
+
+type policyRule interface {
+	name() string
+	evaluate(state *HostState) (bool, string)
+}
+
+// Policy decides whether the host is available for jobs, by rules configured by the volunteer.
+// It is not safe for concurrent use since some rules keep state between evaluations.
+type Policy struct {
+	rules   []policyRule
+	suspend map[string]bool
+}
+
+func NewPolicy(config *PolicyConfig) (*Policy, error) {
+	policy := &Policy{suspend: map[string]bool{}}
+	if len(config.RunWindows) > 0 {
+		rule := &runWindowRule{}
+		for _, s := range config.RunWindows {
+			w, err := parseTimeWindow(s)
+			if err != nil {
+				return nil, err
+			}
+			rule.windows = append(rule.windows, w)
+		}
+		policy.rules = append(policy.rules, rule)
+	}
+	if config.IdleCpu > 0 || config.IdleSession > 0 {
+		policy.rules = append(policy.rules, &idleRule{cpu: config.IdleCpu, session: config.IdleSession})
+	}
+	if config.RequireAC {
+		policy.rules = append(policy.rules, &powerRule{})
+	}
+	if config.MaxCpuTemperature > 0 || config.MaxGpuTemperature > 0 {
+		policy.rules = append(policy.rules, &temperatureRule{maxCpu: config.MaxCpuTemperature, maxGpu: config.MaxGpuTemperature})
+	}
+	for _, name := range config.Suspend {
+		if !slices.Contains([]string{"run-window", "idle", "power", "temperature"}, name) {
+			return nil, fmt.Errorf("unknown policy rule %q", name)
+		}
+		policy.suspend[name] = true
+	}
+	return policy, nil
+}
+
+// Empty reports whether no rule is configured, so that jobs may always run
+func (policy *Policy) Empty() bool {
+	return len(policy.rules) == 0
+}
+
+func (policy *Policy) Evaluate(state *HostState) []PolicyVerdict {
+	verdicts := make([]PolicyVerdict, 0, len(policy.rules))
+	for _, rule := range policy.rules {
+		allow, reason := rule.evaluate(state)
+		verdicts = append(verdicts, PolicyVerdict{
+			Rule:    rule.name(),
+			Allow:   allow,
+			Suspend: !allow && policy.suspend[rule.name()],
+			Reason:  reason,
+		})
+	}
+	return verdicts
+}
+
+// timeWindow is a period of day in minutes, it wraps around midnight if end is before start
+type timeWindow struct {
+	start, end int
+}
+
+func parseTimeWindow(s string) (timeWindow, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("invalid run window %q, expect e.g. 22:00-07:00", s)
+	}
+	var w timeWindow
+	for _, part := range []struct {
+		s string
+		m *int
+	}{{start, &w.start}, {end, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.s))
+		if err != nil {
+			return timeWindow{}, fmt.Errorf("invalid run window %q, expect e.g. 22:00-07:00", s)
+		}
+		*part.m = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return timeWindow{}, fmt.Errorf("empty run window %q", s)
+	}
+	return w, nil
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+func (w timeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+type runWindowRule struct {
+	windows []timeWindow
+}
+
+func (rule *runWindowRule) name() string {
+	return "run-window"
+}
+
+func (rule *runWindowRule) evaluate(state *HostState) (bool, string) {
+	names := []string{}
+	for _, w := range rule.windows {
+		if w.contains(state.Time) {
+			return true, "inside run window " + w.String()
+		}
+		names = append(names, w.String())
+	}
+	return false, "outside run windows " + strings.Join(names, ", ")
+}
+
+type idleRule struct {
+	cpu     float64
+	session time.Duration
+}
+
+func (rule *idleRule) name() string {
+	return "idle"
+}
+
+func (rule *idleRule) evaluate(state *HostState) (bool, string) {
+	reasons := []string{}
+	if rule.cpu > 0 {
+		if state.CpuUsage < 0 {
+			reasons = append(reasons, "cpu usage unknown")
+		} else if state.CpuUsage > rule.cpu {
+			return false, fmt.Sprintf("cpu is %.0f%% used by other processes", state.CpuUsage)
+		} else {
+			reasons = append(reasons, fmt.Sprintf("cpu is %.0f%% used by other processes", state.CpuUsage))
+		}
+	}
+	if rule.session > 0 {
+		if state.SessionsErr != nil {
+			reasons = append(reasons, "sessions unknown: "+state.SessionsErr.Error())
+		} else if len(state.Sessions) == 0 {
+			reasons = append(reasons, "no interactive session")
+		}
+		for _, s := range state.Sessions {
+			if !s.Idle {
+				return false, fmt.Sprintf("user %s is active", s.User)
+			}
+			if idle := state.Time.Sub(s.IdleSince); idle < rule.session {
+				return false, fmt.Sprintf("user %s is idle for only %s", s.User, fmtDuration(idle))
+			}
+		}
+		if len(state.Sessions) > 0 {
+			reasons = append(reasons, "all sessions are idle")
+		}
+	}
+	return true, strings.Join(reasons, ", ")
+}
+
+type powerRule struct{}
+
+func (rule *powerRule) name() string {
+	return "power"
+}
+
+func (rule *powerRule) evaluate(state *HostState) (bool, string) {
+	if state.PowerErr != nil {
+		return true, "power state unknown: " + state.PowerErr.Error()
+	} else if !state.OnAC {
+		return false, "running on battery"
+	}
+	return true, "on AC power"
+}
+
+// temperatureRule denies jobs once a temperature exceeds its max, and keeps denying
+// until temperatures drop by a margin, so that jobs do not flap around the max
+type temperatureRule struct {
+	maxCpu float64
+	maxGpu float64
+	hot    bool
+}
+
+func (rule *temperatureRule) name() string {
+	return "temperature"
+}
+
+func (rule *temperatureRule) evaluate(state *HostState) (bool, string) {
+	margin := 0.0
+	if rule.hot {
+		margin = temperatureMargin
+	}
+	reasons := []string{}
+	hot := false
+	for _, t := range []struct {
+		device  string
+		current float64
+		max     float64
+	}{
+		{"cpu", state.CpuTemperature, rule.maxCpu},
+		{"gpu", state.GpuTemperature, rule.maxGpu},
+	} {
+		if t.max <= 0 || t.current <= 0 {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%s is %.0f°C, max %.0f°C", t.device, t.current, t.max))
+		if t.current >= t.max-margin {
+			hot = true
+		}
+	}
+	rule.hot = hot
+	if len(reasons) == 0 {
+		return true, "temperature unknown"
+	}
+	return !hot, strings.Join(reasons, ", ")
+}
@@ -0,0 +1,190 @@
+package daemon_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-06-10 "+clock, time.Local)
+	checkErr(err)
+	return t
+}
+
+func TestPolicyRunWindows(t *testing.T) {
+	policy, err := daemon.NewPolicy(&daemon.PolicyConfig{RunWindows: []string{"22:00-07:00", "12:00-13:30"}})
+	checkErr(err)
+	tests := []struct {
+		clock string
+		allow bool
+	}{
+		{"21:59", false},
+		{"22:00", true},
+		{"00:00", true},
+		{"06:59", true},
+		{"07:00", false},
+		{"12:30", true},
+		{"13:30", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clock, func(t *testing.T) {
+			verdicts := policy.Evaluate(&daemon.HostState{Time: at(tt.clock)})
+			if len(verdicts) != 1 || verdicts[0].Rule != "run-window" {
+				t.Fatalf("expect a verdict of run-window, got %+v", verdicts)
+			}
+			if verdicts[0].Allow != tt.allow {
+				t.Errorf("expect allow %v, got %+v", tt.allow, verdicts[0])
+			}
+		})
+	}
+}
+
+func TestPolicyIdle(t *testing.T) {
+	policy, err := daemon.NewPolicy(&daemon.PolicyConfig{IdleCpu: 20, IdleSession: 10 * time.Minute, Suspend: []string{"idle"}})
+	checkErr(err)
+	now := at("15:00")
+	tests := []struct {
+		name  string
+		state daemon.HostState
+		allow bool
+	}{
+		{"idle", daemon.HostState{CpuUsage: 5}, true},
+		{"cpu busy", daemon.HostState{CpuUsage: 45}, false},
+		{"cpu unknown", daemon.HostState{CpuUsage: -1}, true},
+		{"user active", daemon.HostState{Sessions: []daemon.Session{{User: "alice"}}}, false},
+		{"user just left", daemon.HostState{Sessions: []daemon.Session{{User: "alice", Idle: true, IdleSince: now.Add(-time.Minute)}}}, false},
+		{"user away", daemon.HostState{Sessions: []daemon.Session{{User: "alice", Idle: true, IdleSince: now.Add(-time.Hour)}}}, true},
+		{"sessions unknown", daemon.HostState{SessionsErr: errors.New("loginctl not found")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.state.Time = now
+			v := policy.Evaluate(&tt.state)[0]
+			if v.Rule != "idle" || v.Allow != tt.allow || v.Suspend == tt.allow {
+				t.Errorf("expect allow %v and suspend %v, got %+v", tt.allow, !tt.allow, v)
+			}
+		})
+	}
+}
+
+func TestPolicyTemperature(t *testing.T) {
+	policy, err := daemon.NewPolicy(&daemon.PolicyConfig{MaxCpuTemperature: 85, MaxGpuTemperature: 80, RequireAC: true})
+	checkErr(err)
+	// jobs are denied once too hot, and allowed again only after cooling down by a margin
+	steps := []struct {
+		cpu, gpu float64
+		allow    bool
+	}{
+		{70, 60, true},
+		{84, 79, true},
+		{86, 70, false},
+		{82, 70, false},
+		{79, 70, true},
+		{70, 81, false},
+		{70, 76, false},
+		{70, 74, true},
+	}
+	for i, step := range steps {
+		verdicts := policy.Evaluate(&daemon.HostState{OnAC: true, CpuTemperature: step.cpu, GpuTemperature: step.gpu})
+		if len(verdicts) != 2 || verdicts[0].Rule != "power" || !verdicts[0].Allow {
+			t.Fatalf("expect power to be allowed, got %+v", verdicts)
+		}
+		if v := verdicts[1]; v.Rule != "temperature" || v.Allow != step.allow || v.Suspend {
+			t.Errorf("step %d: expect allow %v, got %+v", i, step.allow, v)
+		}
+	}
+}
+
+func TestNewPolicyInvalid(t *testing.T) {
+	for _, config := range []daemon.PolicyConfig{
+		{RunWindows: []string{"22:00"}},
+		{RunWindows: []string{"10pm-7am"}},
+		{RunWindows: []string{"08:00-08:00"}},
+		{Suspend: []string{"weekend"}},
+	} {
+		if _, err := daemon.NewPolicy(&config); err == nil {
+			t.Errorf("expect error for %+v", config)
+		}
+	}
+	policy, err := daemon.NewPolicy(&daemon.PolicyConfig{})
+	checkErr(err)
+	if !policy.Empty() {
+		t.Errorf("expect empty policy")
+	}
+}
+
+func TestHostSensor(t *testing.T) {
+	loginctl := map[string]string{
+		"loginctl list-sessions":   "loginctl/list-sessions.txt",
+		"loginctl show-session 2":  "loginctl/session-2.txt",
+		"loginctl show-session c1": "loginctl/session-c1.txt",
+		"loginctl show-session 14": "loginctl/session-14.txt",
+	}
+	tests := []struct {
+		host     string
+		outputs  map[string]string
+		onAC     bool
+		cpuTemp  float64
+		sessions []daemon.Session
+	}{
+		{
+			host:    "ubuntu-22.04-workstation",
+			outputs: loginctl,
+			onAC:    true,
+			cpuTemp: 47,
+			sessions: []daemon.Session{
+				{Id: "2", User: "alice", Idle: true, IdleSince: time.UnixMicro(1718000000000000)},
+				{Id: "14", User: "bob"},
+			},
+		},
+		{
+			host:    "thinkpad-x1-laptop",
+			onAC:    false,
+			cpuTemp: 88,
+		},
+		{
+			host: "rocky-9-server",
+			onAC: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			sensor := &daemon.HostSensor{
+				Probe:          recordedProbe(filepath.Join("testdata", "hosts", tt.host), tt.outputs),
+				GpuTemperature: func() float64 { return 64 },
+			}
+			state := sensor.Read(context.Background())
+			if state.PowerErr != nil || state.OnAC != tt.onAC {
+				t.Errorf("expect on AC %v, got %v, err %v", tt.onAC, state.OnAC, state.PowerErr)
+			}
+			if state.CpuTemperature != tt.cpuTemp || state.GpuTemperature != 64 {
+				t.Errorf("expect cpu %v°C and gpu 64°C, got %v°C and %v°C", tt.cpuTemp, state.CpuTemperature, state.GpuTemperature)
+			}
+			if state.CpuUsage >= 0 {
+				t.Errorf("expect cpu usage not sampled, got %v", state.CpuUsage)
+			}
+			if tt.outputs == nil {
+				if state.SessionsErr == nil {
+					t.Errorf("expect error of sessions without loginctl")
+				}
+				return
+			}
+			if state.SessionsErr != nil {
+				t.Fatal(state.SessionsErr)
+			}
+			if len(state.Sessions) != len(tt.sessions) {
+				t.Fatalf("expect sessions %+v, got %+v", tt.sessions, state.Sessions)
+			}
+			for i, s := range tt.sessions {
+				if got := state.Sessions[i]; got.Id != s.Id || got.User != s.User || got.Idle != s.Idle || !got.IdleSince.Equal(s.IdleSince) {
+					t.Errorf("expect session %+v, got %+v", s, got)
+				}
+			}
+		})
+	}
+}
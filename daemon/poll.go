@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// PollResult is the outcome of polling a job from server
+type PollResult struct {
+	Err error
+	// whether a job is returned
+	Job bool
+	// when the server asks to poll again, 0 if not specified
+	RetryAfter time.Duration
+}
+
+// PollBackoff decides intervals of polling jobs from server, so that nodes neither poll in lockstep
+// nor keep polling a failing or idle server at the same rate
+type PollBackoff struct {
+	// interval after the first answer of no job, and the unit of backoff after errors
+	Base time.Duration
+	// max interval of backoff
+	Max time.Duration
+	// returns a random number in [0, 1), rand.Float64 if nil
+	Rand func() float64
+
+	failures int
+	empties  int
+}
+
+func (b *PollBackoff) random() float64 {
+	if b.Rand != nil {
+		return b.Rand()
+	}
+	return rand.Float64()
+}
+
+// exponential returns Base * 2^(n-1), up to Max
+func (b *PollBackoff) exponential(n int) time.Duration {
+	d := b.Base
+	for i := 1; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	return min(d, b.Max)
+}
+
+// Next returns how long to wait before polling again after result.
+// Errors back off exponentially with jitter, repeated answers of no job lengthen the interval,
+// and a returned job is followed by polling again at once. A retry-after of server is always honored.
+func (b *PollBackoff) Next(result PollResult) time.Duration {
+	var d time.Duration
+	switch {
+	case result.Err != nil:
+		b.failures++
+		b.empties = 0
+		// equal jitter: half of the backoff is kept, the other half is random
+		backoff := b.exponential(b.failures)
+		d = backoff/2 + time.Duration(b.random()*float64(backoff/2))
+	case !result.Job:
+		b.failures = 0
+		b.empties++
+		d = time.Duration(float64(b.exponential(b.empties)) * (0.8 + 0.4*b.random()))
+	default:
+		b.failures, b.empties = 0, 0
+	}
+	if result.RetryAfter > 0 {
+		// a little jitter still spreads nodes told to retry at the same time
+		d = result.RetryAfter + time.Duration(b.random()*float64(result.RetryAfter/10))
+	}
+	return d
+}
+
+// First returns a random delay of the first poll within Base, so that nodes started together do not poll in lockstep
+func (b *PollBackoff) First() time.Duration {
+	return time.Duration(b.random() * float64(b.Base))
+}
+
+// retryAfter reads the "retry-after" metadata of server in seconds, 0 if not set
+func retryAfter(md metadata.MD) time.Duration {
+	values := md.Get("retry-after")
+	if len(values) == 0 {
+		return 0
+	}
+	seconds, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// resetTimer resets t to fire after d, draining its channel if it has fired but not been received
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,55 @@
+package daemon_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestPollBackoff(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+	tests := []struct {
+		result daemon.PollResult
+		// intervals with the min and max random number
+		min time.Duration
+		max time.Duration
+	}{
+		{daemon.PollResult{}, 24 * time.Second, 36 * time.Second},
+		{daemon.PollResult{}, 48 * time.Second, 72 * time.Second},
+		{daemon.PollResult{}, 96 * time.Second, 144 * time.Second},
+		// a job is followed by polling at once
+		{daemon.PollResult{Job: true}, 0, 0},
+		{daemon.PollResult{}, 24 * time.Second, 36 * time.Second},
+		{daemon.PollResult{Err: errUnavailable}, 15 * time.Second, 30 * time.Second},
+		{daemon.PollResult{Err: errUnavailable}, 30 * time.Second, 60 * time.Second},
+		{daemon.PollResult{Err: errUnavailable}, 60 * time.Second, 120 * time.Second},
+		{daemon.PollResult{Err: errUnavailable}, 2 * time.Minute, 4 * time.Minute},
+		{daemon.PollResult{Err: errUnavailable}, 2*time.Minute + 30*time.Second, 5 * time.Minute},
+		{daemon.PollResult{Err: errUnavailable}, 2*time.Minute + 30*time.Second, 5 * time.Minute},
+		// retry-after of server is honored
+		{daemon.PollResult{Err: errUnavailable, RetryAfter: 20 * time.Second}, 20 * time.Second, 22 * time.Second},
+		{daemon.PollResult{RetryAfter: 10 * time.Minute}, 10 * time.Minute, 11 * time.Minute},
+		{daemon.PollResult{Job: true, RetryAfter: 10 * time.Second}, 10 * time.Second, 11 * time.Second},
+	}
+	low := daemon.PollBackoff{Base: 30 * time.Second, Max: 5 * time.Minute, Rand: func() float64 { return 0 }}
+	high := daemon.PollBackoff{Base: 30 * time.Second, Max: 5 * time.Minute, Rand: func() float64 { return 1 }}
+	for i, tt := range tests {
+		if d := low.Next(tt.result); d != tt.min {
+			t.Errorf("%d: expect min interval %v, got %v", i, tt.min, d)
+		}
+		if d := high.Next(tt.result); d != tt.max {
+			t.Errorf("%d: expect max interval %v, got %v", i, tt.max, d)
+		}
+	}
+}
+
+func TestPollBackoffFirst(t *testing.T) {
+	b := daemon.PollBackoff{Base: 30 * time.Second, Max: 5 * time.Minute}
+	for i := 0; i < 100; i++ {
+		if d := b.First(); d < 0 || d >= b.Base {
+			t.Fatalf("expect first poll within %v, got %v", b.Base, d)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PrefetchConfig decides what is fetched while the host is idle, so that jobs of new workloads
+// do not wait for images and resources to download
+type PrefetchConfig struct {
+	Disabled bool
+	// prefetching starts once no job has run for this period
+	Idle time.Duration
+	// default interval of requesting hints of server, unless the server specifies one
+	Interval time.Duration
+	// images prefetched besides those hinted by server
+	Images []string
+	// resources prefetched besides those hinted by server, in the form of "<resource id>:<path>=<url>"
+	Resources []string
+	// max bandwidth in bytes/s of prefetching resources, 0 for unlimited.
+	// Images are pulled by docker which can not be throttled, they are pulled one at a time.
+	Bandwidth int64
+	// min free disk space in bytes kept by prefetching
+	MinFreeDisk int64
+}
+
+func (config PrefetchConfig) withDefaults() PrefetchConfig {
+	if config.Idle <= 0 {
+		config.Idle = time.Minute
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Minute
+	}
+	if config.MinFreeDisk <= 0 {
+		config.MinFreeDisk = 10 * 1024 * 1024 * 1024
+	}
+	return config
+}
+
+// ParsePrefetchResource parses a resource to prefetch in the form of "<resource id>:<path>=<url>"
+func ParsePrefetchResource(s string) (*pb.PrefetchResource, error) {
+	id, rest, ok := strings.Cut(s, ":")
+	path, url, ok2 := strings.Cut(rest, "=")
+	if !ok || !ok2 || id == "" || path == "" || url == "" {
+		return nil, fmt.Errorf("invalid prefetch resource %q, expect <resource id>:<path>=<url>", s)
+	}
+	return &pb.PrefetchResource{
+		ResourceId: id,
+		Resources:  []*pb.JobResource{{Path: path, Req: &pb.FileRequest{Url: url}}},
+	}, nil
+}
+
+const (
+	PrefetchPending  = "pending"
+	PrefetchFetching = "fetching"
+	PrefetchDone     = "done"
+	PrefetchSkipped  = "skipped"
+	PrefetchFailed   = "failed"
+)
+
+// PrefetchItem is an image or a resource to prefetch
+type PrefetchItem struct {
+	// "image" or "resource"
+	Kind string
+	// reference of image, or "<resource id>:<path>" of resource
+	Name     string
+	State    string
+	Progress Progress
+	// why the item is skipped or failed
+	Reason string
+}
+
+type PrefetchStatus struct {
+	// whether prefetching is in progress
+	Active bool
+	Items  []PrefetchItem
+}
+
+// prefetcher fetches images and resources hinted by server or configured locally while the host is idle
+type prefetcher struct {
+	scheduler *Scheduler
+	config    PrefetchConfig
+	resources []*pb.PrefetchResource
+	// whether the host has been idle long enough, it is set by scheduler
+	idle atomic.Bool
+	// held while prefetching
+	running sync.Mutex
+	logger  zerolog.Logger
+
+	mu      sync.Mutex
+	hint    *pb.PrefetchHintResponse
+	hintAt  time.Time
+	refresh time.Duration
+	status  PrefetchStatus
+	// items which failed to prefetch by kind and name, they are retried after an interval
+	failed map[string]PrefetchItem
+	// time the failed items are retried
+	retryAt time.Time
+}
+
+func newPrefetcher(scheduler *Scheduler, config PrefetchConfig) (*prefetcher, error) {
+	p := &prefetcher{
+		scheduler: scheduler,
+		config:    config,
+		refresh:   config.Interval,
+		failed:    map[string]PrefetchItem{},
+		logger:    log.With().Str("component", "prefetcher").Logger(),
+	}
+	for _, s := range config.Resources {
+		resource, err := ParsePrefetchResource(s)
+		if err != nil {
+			return nil, err
+		}
+		p.resources = append(p.resources, resource)
+	}
+	return p, nil
+}
+
+// isPaused implements pausable, downloads of prefetching pause once the host is busy
+func (p *prefetcher) isPaused() bool {
+	return !p.idle.Load() || len(p.scheduler.allJobs()) > 0
+}
+
+// bandwidthLimit implements throttled
+func (p *prefetcher) bandwidthLimit() int64 {
+	return p.config.Bandwidth
+}
+
+func (p *prefetcher) Status() PrefetchStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := p.status
+	status.Items = append([]PrefetchItem{}, p.status.Items...)
+	return status
+}
+
+func (p *prefetcher) update(i int, fn func(item *PrefetchItem)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.status.Items[i])
+}
+
+// fetchHint requests the hint of server if it is due, the previous hint is kept if it fails
+func (p *prefetcher) fetchHint() *pb.PrefetchHintResponse {
+	p.mu.Lock()
+	due := time.Since(p.hintAt) >= p.refresh
+	p.mu.Unlock()
+	if due {
+		c := p.scheduler.c
+		ctx, cancel := context.WithTimeout(c.AppendToOutgoingContext(context.Background(), nil), 10*time.Second)
+		defer cancel()
+		hint, err := c.GetPrefetchHint(ctx, &pb.PrefetchHintRequest{})
+		p.mu.Lock()
+		switch {
+		case status.Code(err) == codes.Unimplemented:
+			// servers which do not give hints, only the local list is prefetched
+			p.hintAt = time.Now()
+		case err != nil:
+			p.logger.Warn().Err(err).Msg("fail to get prefetch hint")
+		default:
+			p.hint, p.hintAt, p.refresh = hint, time.Now(), p.config.Interval
+			if hint.RefreshAfter > 0 {
+				p.refresh = time.Duration(hint.RefreshAfter) * time.Second
+			}
+		}
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hint
+}
+
+// run prefetches images then resources in order, until all are fetched or the host is busy
+func (p *prefetcher) run() {
+	if !p.running.TryLock() {
+		return
+	}
+	defer p.running.Unlock()
+
+	images := []*pb.Image{}
+	for _, ref := range p.config.Images {
+		images = append(images, &pb.Image{Url: ref})
+	}
+	resources := p.resources
+	if hint := p.fetchHint(); hint != nil {
+		images = slices.Concat(hint.Images, images)
+		resources = slices.Concat(hint.Resources, resources)
+	}
+	type task struct {
+		image    *pb.Image
+		id       string
+		resource *pb.JobResource
+	}
+	tasks := []task{}
+	items := []PrefetchItem{}
+	seen := map[string]bool{}
+	for _, image := range images {
+		if !seen["image:"+image.Url] {
+			seen["image:"+image.Url] = true
+			tasks = append(tasks, task{image: image})
+			items = append(items, PrefetchItem{Kind: "image", Name: image.Url, State: PrefetchPending})
+		}
+	}
+	for _, group := range resources {
+		for _, resource := range group.Resources {
+			name := group.ResourceId + ":" + resource.Path
+			if !seen["resource:"+name] && resource.Req != nil {
+				seen["resource:"+name] = true
+				tasks = append(tasks, task{id: group.ResourceId, resource: resource})
+				items = append(items, PrefetchItem{Kind: "resource", Name: name, State: PrefetchPending})
+			}
+		}
+	}
+	if len(tasks) == 0 {
+		return
+	}
+	p.mu.Lock()
+	if time.Now().After(p.retryAt) {
+		clear(p.failed)
+	}
+	for i := range items {
+		if failed, ok := p.failed[items[i].Kind+":"+items[i].Name]; ok {
+			items[i] = failed
+		}
+	}
+	p.status = PrefetchStatus{Active: true, Items: items}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.status.Active = false
+		p.mu.Unlock()
+	}()
+
+	for i, t := range tasks {
+		if p.isPaused() {
+			// the rest are prefetched once the host is idle again
+			return
+		}
+		if p.Status().Items[i].State == PrefetchFailed {
+			continue
+		}
+		p.update(i, func(item *PrefetchItem) { item.State = PrefetchFetching })
+		var (
+			done bool
+			err  error
+		)
+		if t.image != nil {
+			done, err = p.prefetchImage(i, t.image)
+		} else {
+			done, err = p.prefetchResource(i, t.id, t.resource)
+		}
+		paused := p.isPaused()
+		p.update(i, func(item *PrefetchItem) {
+			switch {
+			case err != nil && paused:
+				item.State = PrefetchPending
+			case err != nil:
+				item.State, item.Reason = PrefetchFailed, err.Error()
+				p.logger.Warn().Err(err).Str(item.Kind, item.Name).Msg("fail to prefetch")
+				if len(p.failed) == 0 {
+					p.retryAt = time.Now().Add(p.config.Interval)
+				}
+				p.failed[item.Kind+":"+item.Name] = *item
+			case done:
+				item.State = PrefetchDone
+			}
+		})
+	}
+}
+
+// skip marks item i as skipped for reason
+func (p *prefetcher) skip(i int, reason string) (bool, error) {
+	p.update(i, func(item *PrefetchItem) {
+		item.State, item.Reason = PrefetchSkipped, reason
+	})
+	return false, nil
+}
+
+// prefetchImage pulls image unless any reference it resolves to is present, it returns whether the image is present
+func (p *prefetcher) prefetchImage(i int, image *pb.Image) (bool, error) {
+	scheduler := p.scheduler
+	config := scheduler.config
+	if err := config.imagePolicy.Check(image.Url); err != nil {
+		return p.skip(i, err.Error())
+	}
+	candidates, err := config.imageResolver.Resolve(image.Url)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.ImagePullTimeout)
+	defer cancel()
+	for _, candidate := range candidates {
+		if _, _, err := scheduler.cli.ImageInspectWithRaw(ctx, candidate); err == nil {
+			return true, nil
+		}
+	}
+	if config.ImageDiskBudget > 0 {
+		images, err := scheduler.Images(ctx)
+		if err != nil {
+			return false, err
+		}
+		if ImagesSize(images) >= config.ImageDiskBudget {
+			return p.skip(i, "image disk budget is used up")
+		}
+	}
+	if p.scheduler.diskSpaceLow(p.config.MinFreeDisk) {
+		return p.skip(i, "disk space is low")
+	}
+
+	// docker can not pause pulling, it is cancelled once the host is busy
+	go func() {
+		for !p.isPaused() {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	errs := []error{}
+	for _, candidate := range candidates {
+		auth, err := registryAuth(candidate, image.Url, image.Auth)
+		if err == nil {
+			err = pullImage(ctx, scheduler.cli, candidate, auth, func(progress Progress) {
+				p.update(i, func(item *PrefetchItem) { item.Progress = progress })
+			})
+		}
+		if err == nil {
+			// prefetched images count as used now, so that they are not collected before jobs use them
+			return true, recordImage(ctx, scheduler.cli, candidate, true)
+		} else if ctx.Err() != nil {
+			return false, err
+		}
+		errs = append(errs, err)
+	}
+	return false, errors.Join(errs...)
+}
+
+// prefetchResource downloads a resource of resource id unless it is present, it returns whether the resource is present.
+// The download is shared with jobs which use the resource meanwhile.
+func (p *prefetcher) prefetchResource(i int, id string, resource *pb.JobResource) (bool, error) {
+	dir, err := resourceDir(p.scheduler.dir, id)
+	if err != nil {
+		return false, err
+	}
+	dst, err := filePath(dir, resource.Path)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return true, nil
+	}
+	if p.scheduler.diskSpaceLow(p.config.MinFreeDisk) {
+		return p.skip(i, "disk space is low")
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return false, err
+	}
+	// the download pauses instead of being cancelled once the host is busy, since jobs may wait for it
+	dld := p.scheduler.rm.Download(dst, resource.Req.Url, p)
+	defer dld.detach(p)
+	t := time.NewTicker(500 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.update(i, func(item *PrefetchItem) {
+				item.Progress = Progress{
+					Current: uint64(dld.Current()),
+					Total:   uint64(max(dld.Total(), 0)),
+				}
+			})
+		case <-dld.Done:
+			if err := dld.Err(); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+}
+
+// prefetch starts prefetching if the host has been idle long enough, it is called periodically in loop of scheduler
+func (scheduler *Scheduler) prefetch() {
+	p := scheduler.prefetcher
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if scheduler.status != StatusRunning || !scheduler.available || scheduler.suspended ||
+		len(scheduler.pendingJobs) > 0 || len(scheduler.allJobs()) > 0 {
+		scheduler.busyAt = now
+	}
+	idle := now.Sub(scheduler.busyAt) >= p.config.Idle
+	p.idle.Store(idle)
+	if idle {
+		go p.run()
+	}
+}
+
+// PrefetchStatus returns what is prefetched, empty if prefetching is disabled
+func (scheduler *Scheduler) PrefetchStatus() PrefetchStatus {
+	if scheduler.prefetcher == nil {
+		return PrefetchStatus{Items: []PrefetchItem{}}
+	}
+	return scheduler.prefetcher.Status()
+}
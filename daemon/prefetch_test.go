@@ -0,0 +1,33 @@
+package daemon_test
+
+import (
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestParsePrefetchResource(t *testing.T) {
+	tests := []struct {
+		s    string
+		id   string
+		path string
+		url  string
+	}{
+		{"amber22:params/ff14SB.dat=https://cdn.sath.run/amber22/ff14SB.dat", "amber22", "params/ff14SB.dat", "https://cdn.sath.run/amber22/ff14SB.dat"},
+		// urls may contain ':' and '='
+		{"md:model.bin=http://mirror.lab:8080/get?file=model.bin", "md", "model.bin", "http://mirror.lab:8080/get?file=model.bin"},
+	}
+	for _, tt := range tests {
+		resource, err := daemon.ParsePrefetchResource(tt.s)
+		checkErr(err)
+		if resource.ResourceId != tt.id || len(resource.Resources) != 1 ||
+			resource.Resources[0].Path != tt.path || resource.Resources[0].Req.Url != tt.url {
+			t.Errorf("%s: unexpected %v", tt.s, resource)
+		}
+	}
+	for _, s := range []string{"", "amber22", "amber22:ff14SB.dat", ":ff14SB.dat=https://cdn.sath.run/ff14SB.dat", "amber22:=https://cdn.sath.run"} {
+		if _, err := daemon.ParsePrefetchResource(s); err == nil {
+			t.Errorf("expect error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Workloads report progress either by printing a line to stdout like:
+//
+//	::sath-progress 40/100 optional message
+//	::sath-progress 40% optional message
+//
+// or by writing the same content without prefix followed by a line break, e.g. "40/100\n", to
+// progressFileName inside the output bind of the container. A line without line break is
+// taken as partially written and ignored.
+const (
+	progressLinePrefix = "::sath-progress "
+	progressFileName   = ".sath-progress"
+)
+
+type Progress struct {
+	Current uint64
+	Total   uint64
+	Message string
+}
+
+// Percent returns progress in percentage, or 0 if total is unknown
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return float64(p.Current) / float64(p.Total) * 100
+}
+
+// parseProgressLine parses a stdout line of progress protocol
+func parseProgressLine(line string) (Progress, bool) {
+	if !strings.HasPrefix(line, progressLinePrefix) {
+		return Progress{}, false
+	}
+	return parseProgress(strings.TrimPrefix(line, progressLinePrefix))
+}
+
+// parseProgress parses "<current>/<total> [message]" or "<percent>% [message]"
+func parseProgress(text string) (Progress, bool) {
+	value, message, _ := strings.Cut(strings.TrimSpace(text), " ")
+	progress := Progress{Message: strings.TrimSpace(message)}
+	if percent, found := strings.CutSuffix(value, "%"); found {
+		p, err := strconv.ParseFloat(percent, 64)
+		// NaN is rejected as well
+		if err != nil || !(p >= 0 && p <= 100) {
+			return Progress{}, false
+		}
+		// keep 2 decimal places of percentage
+		progress.Current = uint64(p * 100)
+		progress.Total = 10000
+		return progress, true
+	}
+	current, total, found := strings.Cut(value, "/")
+	if !found {
+		return Progress{}, false
+	}
+	var err error
+	if progress.Current, err = strconv.ParseUint(current, 10, 64); err != nil {
+		return Progress{}, false
+	}
+	if progress.Total, err = strconv.ParseUint(total, 10, 64); err != nil {
+		return Progress{}, false
+	}
+	if progress.Current > progress.Total {
+		return Progress{}, false
+	}
+	return progress, true
+}
+
+// readProgressFile reads progress from the progress file in dir, if any
+func readProgressFile(dir string) (Progress, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, progressFileName))
+	if err != nil {
+		return Progress{}, false
+	}
+	// only the first line is used
+	line, _, found := strings.Cut(string(data), "\n")
+	if !found {
+		return Progress{}, false
+	}
+	return parseProgress(line)
+}
@@ -0,0 +1,108 @@
+package daemon_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		ok       bool
+		progress daemon.Progress
+	}{
+		{"::sath-progress 40/100", true, daemon.Progress{Current: 40, Total: 100}},
+		{"::sath-progress 40/100 training epoch 4", true, daemon.Progress{Current: 40, Total: 100, Message: "training epoch 4"}},
+		{"::sath-progress   7/7   done ", true, daemon.Progress{Current: 7, Total: 7, Message: "done"}},
+		{"::sath-progress 0/0", true, daemon.Progress{}},
+		{"::sath-progress 40%", true, daemon.Progress{Current: 4000, Total: 10000}},
+		{"::sath-progress 12.345% step", true, daemon.Progress{Current: 1234, Total: 10000, Message: "step"}},
+		{"::sath-progress 100%", true, daemon.Progress{Current: 10000, Total: 10000}},
+		{"::sath-progress 0%", true, daemon.Progress{Current: 0, Total: 10000}},
+		// not a progress line
+		{"40/100", false, daemon.Progress{}},
+		{"::sath-progress", false, daemon.Progress{}},
+		{" ::sath-progress 40/100", false, daemon.Progress{}},
+		// malformed
+		{"::sath-progress ", false, daemon.Progress{}},
+		{"::sath-progress 40", false, daemon.Progress{}},
+		{"::sath-progress 40/", false, daemon.Progress{}},
+		{"::sath-progress /100", false, daemon.Progress{}},
+		{"::sath-progress -1/100", false, daemon.Progress{}},
+		{"::sath-progress 1.5/100", false, daemon.Progress{}},
+		{"::sath-progress a/b", false, daemon.Progress{}},
+		{"::sath-progress %", false, daemon.Progress{}},
+		{"::sath-progress forty%", false, daemon.Progress{}},
+		// out of range
+		{"::sath-progress 101/100", false, daemon.Progress{}},
+		{"::sath-progress 100.5%", false, daemon.Progress{}},
+		{"::sath-progress 250%", false, daemon.Progress{}},
+		{"::sath-progress -5%", false, daemon.Progress{}},
+		{"::sath-progress NaN%", false, daemon.Progress{}},
+		{"::sath-progress Inf%", false, daemon.Progress{}},
+	}
+	for _, tt := range tests {
+		progress, ok := daemon.ParseProgressLine(tt.line)
+		if ok != tt.ok || progress != tt.progress {
+			t.Errorf("%q: expect %v %+v, got %v %+v", tt.line, tt.ok, tt.progress, ok, progress)
+		}
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	tests := []struct {
+		progress daemon.Progress
+		percent  float64
+	}{
+		{daemon.Progress{Current: 40, Total: 100}, 40},
+		{daemon.Progress{Current: 1, Total: 8}, 12.5},
+		{daemon.Progress{Current: 5}, 0},
+	}
+	for _, tt := range tests {
+		if percent := tt.progress.Percent(); percent != tt.percent {
+			t.Errorf("%+v: expect %v, got %v", tt.progress, tt.percent, percent)
+		}
+	}
+}
+
+func TestReadProgressFile(t *testing.T) {
+	tests := []struct {
+		content  *string
+		ok       bool
+		progress daemon.Progress
+	}{
+		{nil, false, daemon.Progress{}},
+		{ptr("40/100\n"), true, daemon.Progress{Current: 40, Total: 100}},
+		{ptr("40% loading\r\n"), true, daemon.Progress{Current: 4000, Total: 10000, Message: "loading"}},
+		// only the first line is used
+		{ptr("40/100 a\n50/100 b\n"), true, daemon.Progress{Current: 40, Total: 100, Message: "a"}},
+		{ptr("40/100\nmalformed\n"), true, daemon.Progress{Current: 40, Total: 100}},
+		// partially written
+		{ptr(""), false, daemon.Progress{}},
+		{ptr("4"), false, daemon.Progress{}},
+		{ptr("5/10"), false, daemon.Progress{}},
+		{ptr("40/100"), false, daemon.Progress{}},
+		// malformed or out of range
+		{ptr("\n40/100\n"), false, daemon.Progress{}},
+		{ptr("::sath-progress 40/100\n"), false, daemon.Progress{}},
+		{ptr("120/100\n"), false, daemon.Progress{}},
+		{ptr("101%\n"), false, daemon.Progress{}},
+	}
+	for i, tt := range tests {
+		dir := t.TempDir()
+		if tt.content != nil {
+			checkErr(os.WriteFile(filepath.Join(dir, daemon.ProgressFileName), []byte(*tt.content), 0644))
+		}
+		progress, ok := daemon.ReadProgressFile(dir)
+		if ok != tt.ok || progress != tt.progress {
+			t.Errorf("%d: expect %v %+v, got %v %+v", i, tt.ok, tt.progress, ok, progress)
+		}
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// pullMessage is a message in the JSON stream of docker pull
+type pullMessage struct {
+	Id             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+type layerProgress struct {
+	total      int64
+	downloaded int64
+	extracted  int64
+}
+
+// PullProgress aggregates progress of layers in the JSON stream of docker pull into
+// a total of bytes downloaded and extracted for the image
+type PullProgress struct {
+	layers map[string]*layerProgress
+	// time of the first byte, the rate is estimated since then
+	start   time.Time
+	started bool
+}
+
+func NewPullProgress() *PullProgress {
+	return &PullProgress{
+		layers: map[string]*layerProgress{},
+	}
+}
+
+// Update applies a line of the pull stream, it returns an error if the line reports one.
+// Lines which are not JSON are ignored.
+func (p *PullProgress) Update(line []byte, now time.Time) error {
+	var msg pullMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil
+	}
+	if msg.Error != "" || msg.ErrorDetail.Message != "" {
+		message := msg.ErrorDetail.Message
+		if message == "" {
+			message = msg.Error
+		}
+		return errors.New(message)
+	}
+	if msg.Id == "" {
+		// e.g. "Pulling from library/ubuntu" or "Digest: sha256:..."
+		return nil
+	}
+	layer := p.layers[msg.Id]
+	if layer == nil && msg.Status != "Pulling fs layer" && msg.Status != "Waiting" &&
+		msg.Status != "Downloading" && msg.Status != "Extracting" {
+		// lines of the tag, or layers already present which take no time
+		return nil
+	} else if layer == nil {
+		layer = &layerProgress{}
+		p.layers[msg.Id] = layer
+	}
+	switch msg.Status {
+	case "Downloading":
+		layer.total = max(layer.total, msg.ProgressDetail.Total)
+		layer.downloaded = msg.ProgressDetail.Current
+	case "Download complete", "Verifying Checksum":
+		layer.downloaded = layer.total
+	case "Extracting":
+		layer.total = max(layer.total, msg.ProgressDetail.Total)
+		layer.downloaded = layer.total
+		layer.extracted = msg.ProgressDetail.Current
+	case "Pull complete":
+		layer.downloaded = layer.total
+		layer.extracted = layer.total
+	}
+	if current, _ := p.bytes(); !p.started && current > 0 {
+		p.started = true
+		p.start = now
+	}
+	return nil
+}
+
+// bytes returns bytes downloaded plus extracted, and twice the total size of layers known so far
+func (p *PullProgress) bytes() (current int64, total int64) {
+	for _, layer := range p.layers {
+		current += layer.downloaded + layer.extracted
+		total += 2 * layer.total
+	}
+	return current, total
+}
+
+// Progress returns the overall progress of pulling, with sizes and the estimated time left in message
+func (p *PullProgress) Progress(now time.Time) Progress {
+	current, total := p.bytes()
+	var downloaded, size int64
+	for _, layer := range p.layers {
+		downloaded += layer.downloaded
+		size += layer.total
+	}
+	message := fmt.Sprintf("pulling image %s/%s", fmtBytes(downloaded), fmtBytes(size))
+	if eta, ok := p.ETA(now); ok {
+		message += ", " + fmtDuration(eta) + " left"
+	}
+	return Progress{
+		Current: uint64(current),
+		Total:   uint64(total),
+		Message: message,
+	}
+}
+
+// ETA estimates the time left by the average rate since the first byte, false if it can not be estimated yet
+func (p *PullProgress) ETA(now time.Time) (time.Duration, bool) {
+	current, total := p.bytes()
+	elapsed := now.Sub(p.start)
+	if !p.started || current == 0 || elapsed < time.Second {
+		return 0, false
+	}
+	rate := float64(current) / elapsed.Seconds()
+	return time.Duration(float64(total-current) / rate * float64(time.Second)), true
+}
+
+func fmtBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+}
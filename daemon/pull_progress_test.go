@@ -0,0 +1,84 @@
+package daemon_test
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+const mb = 1 << 20
+
+// readPull feeds a recorded pull stream to progress, a line per second from start.
+// It stops after n lines if n > 0, and returns the error of the stream.
+func readPull(file string, progress *daemon.PullProgress, start time.Time, n int) error {
+	f, err := os.Open("testdata/pull/" + file)
+	checkErr(err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for i := 0; scanner.Scan() && (n <= 0 || i < n); i++ {
+		if err := progress.Update(scanner.Bytes(), start.Add(time.Duration(i)*time.Second)); err != nil {
+			return err
+		}
+	}
+	checkErr(scanner.Err())
+	return nil
+}
+
+func TestPullProgress(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		lines   int
+		current uint64
+		total   uint64
+	}{
+		// layers already present are not counted
+		{3, 0, 0},
+		{6, mb / 2, 4 * mb},
+		{10, 3 * mb, 20 * mb},
+		{12, 5 * mb, 20 * mb},
+		{16, 16 * mb, 20 * mb},
+		{0, 20 * mb, 20 * mb},
+	}
+	for _, tt := range tests {
+		progress := daemon.NewPullProgress()
+		checkErr(readPull("ubuntu.jsonl", progress, start, tt.lines))
+		p := progress.Progress(start.Add(time.Minute))
+		if p.Current != tt.current || p.Total != tt.total {
+			t.Errorf("after %d lines: expect %d/%d, got %d/%d", tt.lines, tt.current, tt.total, p.Current, p.Total)
+		}
+	}
+}
+
+func TestPullProgressETA(t *testing.T) {
+	start := time.Now()
+	progress := daemon.NewPullProgress()
+	checkErr(readPull("ubuntu.jsonl", progress, start, 10))
+	// the first byte arrives by the 6th line, 3MB of 20MB are done 4 seconds later
+	now := start.Add(9 * time.Second)
+	eta, ok := progress.ETA(now)
+	if expect := 17 * 4 * time.Second / 3; !ok || (eta-expect).Abs() > time.Millisecond {
+		t.Errorf("expect %v left, got %v %v", expect, eta, ok)
+	}
+	if p := progress.Progress(now); !strings.Contains(p.Message, "3.0MB/10.0MB") || !strings.Contains(p.Message, "left") {
+		t.Errorf("unexpected message %q", p.Message)
+	}
+
+	progress = daemon.NewPullProgress()
+	checkErr(readPull("ubuntu.jsonl", progress, start, 5))
+	if _, ok := progress.ETA(start.Add(time.Minute)); ok {
+		t.Errorf("expect no estimation before the first byte")
+	}
+}
+
+func TestPullProgressError(t *testing.T) {
+	err := readPull("unauthorized.jsonl", daemon.NewPullProgress(), time.Now(), 0)
+	if err == nil || err.Error() != "unauthorized: authentication required" {
+		t.Errorf("expect unauthorized error, got %v", err)
+	}
+	progress := daemon.NewPullProgress()
+	checkErr(progress.Update([]byte("not json"), time.Now()))
+}
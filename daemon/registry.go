@@ -0,0 +1,156 @@
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// max size of manifests and signature payloads read from registry
+const maxRegistryBlobSize = 4 * 1024 * 1024
+
+// registryClient reads manifests and blobs of a repository by the registry HTTP API v2,
+// it is only used for metadata of images, which are pulled by docker
+type registryClient struct {
+	client *http.Client
+	// base url of the registry, e.g. https://registry-1.docker.io
+	base string
+	repo string
+	auth *registry.AuthConfig
+	// bearer token obtained from the auth server of registry
+	token string
+}
+
+func newRegistryClient(named reference.Named, encodedAuth string) (*registryClient, error) {
+	auth, err := registry.DecodeAuthConfig(encodedAuth)
+	if err != nil {
+		return nil, err
+	}
+	if auth.Username == "" && auth.Auth != "" {
+		// auth is base64 of "username:password"
+		if data, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+			auth.Username, auth.Password, _ = strings.Cut(string(data), ":")
+		}
+	}
+	domain := reference.Domain(named)
+	if domain == "docker.io" {
+		domain = "registry-1.docker.io"
+	}
+	return &registryClient{
+		client: http.DefaultClient,
+		base:   "https://" + domain,
+		repo:   reference.Path(named),
+		auth:   auth,
+		token:  auth.RegistryToken,
+	}, nil
+}
+
+// manifest returns the manifest of a tag or digest, nil if it does not exist
+func (c *registryClient) manifest(ctx context.Context, tagOrDigest string) ([]byte, error) {
+	return c.get(ctx, "/manifests/"+tagOrDigest,
+		"application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json")
+}
+
+// blob returns the content of a blob, nil if it does not exist
+func (c *registryClient) blob(ctx context.Context, digest string) ([]byte, error) {
+	return c.get(ctx, "/blobs/"+digest, "")
+}
+
+func (c *registryClient) get(ctx context.Context, path string, accept string) ([]byte, error) {
+	u := c.base + "/v2/" + c.repo + path
+	for retried := false; ; retried = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		} else if c.auth.Username != "" {
+			req.SetBasicAuth(c.auth.Username, c.auth.Password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxRegistryBlobSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && !retried:
+			if err := c.authorize(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+		case resp.StatusCode == http.StatusNotFound:
+			return nil, nil
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("registry responds %s for %s", resp.Status, u)
+		default:
+			return data, nil
+		}
+	}
+}
+
+// authorize obtains a bearer token as challenged by registry, e.g.
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/ubuntu:pull"
+func (c *registryClient) authorize(ctx context.Context, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("unsupported auth challenge of registry: %s", challenge)
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+	if values["realm"] == "" {
+		return fmt.Errorf("no realm in auth challenge of registry: %s", challenge)
+	}
+	query := url.Values{}
+	query.Set("service", values["service"])
+	if scope := values["scope"]; scope != "" {
+		query.Set("scope", scope)
+	} else {
+		query.Set("scope", "repository:"+c.repo+":pull")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, values["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.auth.Username != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry auth server responds %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	return nil
+}
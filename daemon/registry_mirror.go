@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/sath-run/engine/constants"
+	"github.com/sath-run/engine/meta"
+)
+
+// imageRule replaces the prefix of fully qualified repository names, e.g.
+// "docker.io" => "mirror.lab:5000" maps docker.io/library/ubuntu to mirror.lab:5000/library/ubuntu
+type imageRule struct {
+	from string
+	to   string
+}
+
+func parseImageRule(s string) (imageRule, error) {
+	from, to, ok := strings.Cut(s, "=")
+	rule := imageRule{
+		from: strings.TrimSuffix(strings.TrimSpace(from), "/"),
+		to:   strings.TrimSuffix(strings.TrimSpace(to), "/"),
+	}
+	if !ok || rule.from == "" || rule.to == "" || strings.ContainsAny(rule.from+rule.to, "@ ") {
+		return imageRule{}, fmt.Errorf("invalid image rule %q, expect <registry or repository>=<registry or repository>", s)
+	}
+	return rule, nil
+}
+
+// apply returns the repository name mapped by rule, false if rule does not match name
+func (rule imageRule) apply(name string) (string, bool) {
+	if name == rule.from {
+		return rule.to, true
+	} else if rest, ok := strings.CutPrefix(name, rule.from+"/"); ok {
+		return rule.to + "/" + rest, true
+	}
+	return "", false
+}
+
+// ImageResolver resolves references of images to where they are pulled from
+type ImageResolver struct {
+	// mirrors are tried in order before the registry itself
+	mirrors []imageRule
+	// rewrites always apply, the original registry is never used
+	rewrites []imageRule
+}
+
+// NewImageResolver parses rules of mirrors and rewrites in the form of "docker.io=mirror.lab:5000",
+// a rule may also map a repository prefix, e.g. "ghcr.io/sath-run=harbor.lab/sath-run"
+func NewImageResolver(mirrors []string, rewrites []string) (*ImageResolver, error) {
+	resolver := &ImageResolver{}
+	for _, s := range mirrors {
+		rule, err := parseImageRule(s)
+		if err != nil {
+			return nil, err
+		}
+		resolver.mirrors = append(resolver.mirrors, rule)
+	}
+	for _, s := range rewrites {
+		rule, err := parseImageRule(s)
+		if err != nil {
+			return nil, err
+		}
+		resolver.rewrites = append(resolver.rewrites, rule)
+	}
+	return resolver, nil
+}
+
+// Resolve returns references ref may be pulled by, in the order they should be tried.
+// The first rewrite matching ref applies, then every mirror matching it is tried before the registry itself.
+// References not changed by any rule are returned as they are.
+func (r *ImageResolver) Resolve(ref string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %s: %w", ref, err)
+	}
+	name := named.Name()
+	for _, rule := range r.rewrites {
+		if rewritten, ok := rule.apply(name); ok {
+			name = rewritten
+			break
+		}
+	}
+	names := []string{}
+	for _, rule := range r.mirrors {
+		if mirror, ok := rule.apply(name); ok {
+			names = append(names, mirror)
+		}
+	}
+	names = append(names, name)
+
+	refs := []string{}
+	for _, n := range names {
+		if n == named.Name() {
+			refs = append(refs, ref)
+			continue
+		}
+		resolved, err := withName(named, n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image reference %s resolved from %s: %w", n, ref, err)
+		}
+		refs = append(refs, resolved)
+	}
+	return refs, nil
+}
+
+// withName returns the reference of the same tag and digest as named in repository name
+func withName(named reference.Named, name string) (string, error) {
+	resolved, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", err
+	} else if resolved.Name() != name && reference.FamiliarName(resolved) != name {
+		// e.g. "mirror.lab:5000" alone is parsed as repository "mirror.lab" with tag "5000"
+		return "", fmt.Errorf("not a repository name")
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		if resolved, err = reference.WithTag(resolved, tagged.Tag()); err != nil {
+			return "", err
+		}
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		if resolved, err = reference.WithDigest(resolved, canonical.Digest()); err != nil {
+			return "", err
+		}
+	}
+	return reference.FamiliarString(resolved), nil
+}
+
+// registryAuth returns the encoded auth for pulling ref, which is resolved from image of job.
+// The auth provided with job only applies to the registry of image, otherwise the credential
+// stored for the registry of ref is used, empty if there is none.
+func registryAuth(ref string, image string, auth string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	domain := reference.Domain(named)
+	if auth != "" {
+		if original, err := reference.ParseNormalizedNamed(image); err == nil && reference.Domain(original) == domain {
+			return auth, nil
+		}
+	}
+	credential, err := meta.GetRegistryCredential(domain)
+	if errors.Is(err, constants.ErrNil) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      credential.Username,
+		Password:      credential.Password,
+		IdentityToken: credential.IdentityToken,
+		ServerAddress: credential.Registry,
+	})
+}
@@ -0,0 +1,56 @@
+package daemon_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestImageResolver(t *testing.T) {
+	digest := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	resolver, err := daemon.NewImageResolver(
+		[]string{"docker.io=mirror.lab:5000", "docker.io/library=harbor.lab/dockerhub", "ghcr.io=mirror.lab:5001"},
+		[]string{"quay.io/sath-run=registry.lab/sath-run", "ghcr.io/sath-run/amber=ghcr.io/sath-run/amber-lab"},
+	)
+	checkErr(err)
+	tests := []struct {
+		ref  string
+		refs []string
+	}{
+		{"ubuntu:22.04", []string{"mirror.lab:5000/library/ubuntu:22.04", "harbor.lab/dockerhub/ubuntu:22.04", "ubuntu:22.04"}},
+		{"nvidia/cuda", []string{"mirror.lab:5000/nvidia/cuda", "nvidia/cuda"}},
+		{"ubuntu@" + digest, []string{"mirror.lab:5000/library/ubuntu@" + digest, "harbor.lab/dockerhub/ubuntu@" + digest, "ubuntu@" + digest}},
+		// rewritten references are never pulled from the original registry
+		{"quay.io/sath-run/md:1.0", []string{"registry.lab/sath-run/md:1.0"}},
+		// mirrors apply to rewritten references
+		{"ghcr.io/sath-run/amber:22", []string{"mirror.lab:5001/sath-run/amber-lab:22", "ghcr.io/sath-run/amber-lab:22"}},
+		// prefixes only match whole path components
+		{"ghcr.io/sath-run/amberlite:1", []string{"mirror.lab:5001/sath-run/amberlite:1", "ghcr.io/sath-run/amberlite:1"}},
+		{"localhost:5000/md", []string{"localhost:5000/md"}},
+	}
+	for _, tt := range tests {
+		refs, err := resolver.Resolve(tt.ref)
+		checkErr(err)
+		if !slices.Equal(refs, tt.refs) {
+			t.Errorf("%s: expect %v, got %v", tt.ref, tt.refs, refs)
+		}
+	}
+	if _, err := resolver.Resolve("Invalid:ref:"); err == nil {
+		t.Errorf("expect error for invalid reference")
+	}
+}
+
+func TestImageResolverInvalidRules(t *testing.T) {
+	for _, rule := range []string{"docker.io", "=mirror.lab", "docker.io=", "ubuntu@sha256:2c26=mirror.lab"} {
+		if _, err := daemon.NewImageResolver([]string{rule}, nil); err == nil {
+			t.Errorf("expect error for mirror %q", rule)
+		}
+	}
+	// a registry alone can not replace a repository
+	resolver, err := daemon.NewImageResolver(nil, []string{"docker.io/library/ubuntu=mirror.lab:5000"})
+	checkErr(err)
+	if _, err := resolver.Resolve("ubuntu:22.04"); err == nil {
+		t.Errorf("expect error for a reference rewritten to a registry")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/cavaliergopher/grab/v3"
 	"github.com/rs/zerolog"
@@ -21,19 +22,36 @@ func NewResourceManager() *ResourceManager {
 	}
 }
 
-func (rm *ResourceManager) Download(ctx context.Context, dst string, url string) *Downloader {
+// pausable is a job which may be paused
+type pausable interface {
+	isPaused() bool
+}
+
+// throttled is a waiter of downloads which limits their bandwidth,
+// a download is only throttled while all of its waiters are throttled
+type throttled interface {
+	bandwidthLimit() int64
+}
+
+// Download downloads url to dst for job, the same dst is only downloaded once.
+// The job should be detached from the downloader once it stops waiting, e.g. when its own context is done,
+// the download is cancelled once no job waits for it anymore.
+func (rm *ResourceManager) Download(dst string, url string, job pausable) *Downloader {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
-	if downloader, ok := rm.downloaders[dst]; ok {
-		return downloader
-	} else {
+	downloader, ok := rm.downloaders[dst]
+	if ok && downloader.failed() {
+		// e.g. the download was cancelled once all of its jobs stopped waiting, it starts over for others
+		ok = false
+	}
+	if !ok || !downloader.attach(job) {
 		// TODO: clean up downloader after some period of time
-		downloader := newDownloader(ctx, dst, url)
+		downloader = newDownloader(dst, url)
+		downloader.attach(job)
 		rm.downloaders[dst] = downloader
-		return downloader
 	}
-
+	return downloader
 }
 
 type Downloader struct {
@@ -41,22 +59,40 @@ type Downloader struct {
 	err    error
 	logger zerolog.Logger
 	Done   chan struct{}
+
+	mu sync.Mutex
+	// jobs waiting for the download, it is paused while all of them are paused
+	jobs      map[pausable]int
+	wasPaused bool
+	// cancels the download once no job waits for it
+	cancel    context.CancelFunc
+	abandoned bool
+
+	// bytes read since the current bandwidth limit applies
+	throttleStart time.Time
+	throttleBytes int64
+	throttleLimit int64
 }
 
-func newDownloader(ctx context.Context, dst string, url string) *Downloader {
+func newDownloader(dst string, url string) *Downloader {
+	// the download is shared by jobs, it is owned by the downloader rather than by any of them
+	ctx, cancel := context.WithCancel(context.Background())
 	tmp := dst + ".sath_tmp"
 	client := grab.NewClient()
 	req, _ := grab.NewRequest(tmp, url)
 
-	// start download
-	resp := client.Do(req)
-
 	dld := &Downloader{
-		resp:   resp,
 		err:    nil,
 		logger: log.With().Str("component", "resource_manager").Str("dst", dst).Logger(),
 		Done:   make(chan struct{}),
+		jobs:   map[pausable]int{},
+		cancel: cancel,
 	}
+	req.RateLimiter = dld
+
+	// start download
+	resp := client.Do(req)
+	dld.resp = resp
 
 	dld.logger.Trace().Msg("downloader started")
 
@@ -70,13 +106,127 @@ func newDownloader(ctx context.Context, dst string, url string) *Downloader {
 			dld.err = err
 		} else if err := os.Rename(tmp, dst); err != nil {
 			dld.err = err
+		} else if !resp.DidResume && !dld.everPaused() {
+			downloadBandwidth.Record(resp.BytesComplete(), resp.Duration())
 		}
+		cancel()
 		close(dld.Done)
 	}()
 
 	return dld
 }
 
+// attach adds job to those waiting for the download, it returns false if the download is already abandoned
+func (dld *Downloader) attach(job pausable) bool {
+	dld.mu.Lock()
+	defer dld.mu.Unlock()
+	if dld.abandoned {
+		return false
+	}
+	dld.jobs[job]++
+	return true
+}
+
+// detach removes job from those waiting for the download, which is cancelled once no job waits for it
+func (dld *Downloader) detach(job pausable) {
+	dld.mu.Lock()
+	defer dld.mu.Unlock()
+	if dld.jobs[job]--; dld.jobs[job] <= 0 {
+		delete(dld.jobs, job)
+	}
+	if len(dld.jobs) == 0 {
+		dld.abandoned = true
+		dld.cancel()
+	}
+}
+
+// paused reports whether all jobs waiting for the download are paused
+func (dld *Downloader) paused() bool {
+	dld.mu.Lock()
+	defer dld.mu.Unlock()
+	if len(dld.jobs) == 0 {
+		return false
+	}
+	for job := range dld.jobs {
+		if !job.isPaused() {
+			return false
+		}
+	}
+	dld.wasPaused = true
+	return true
+}
+
+// everPaused reports whether the download has been paused, its duration does not reflect bandwidth then
+func (dld *Downloader) everPaused() bool {
+	dld.mu.Lock()
+	defer dld.mu.Unlock()
+	return dld.wasPaused
+}
+
+// limit returns the bandwidth limit in bytes/s of the download, 0 if it is not throttled
+func (dld *Downloader) limit() int64 {
+	dld.mu.Lock()
+	defer dld.mu.Unlock()
+	var limit int64
+	for job := range dld.jobs {
+		t, ok := job.(throttled)
+		if !ok || t.bandwidthLimit() <= 0 {
+			return 0
+		}
+		limit = max(limit, t.bandwidthLimit())
+	}
+	return limit
+}
+
+// throttle returns how long to wait before reading n more bytes within limit
+func (dld *Downloader) throttle(limit int64, n int) time.Duration {
+	dld.mu.Lock()
+	defer dld.mu.Unlock()
+	now := time.Now()
+	if limit != dld.throttleLimit {
+		dld.throttleStart, dld.throttleBytes, dld.throttleLimit = now, 0, limit
+	}
+	dld.throttleBytes += int64(n)
+	if limit <= 0 {
+		return 0
+	}
+	expected := time.Duration(float64(dld.throttleBytes) / float64(limit) * float64(time.Second))
+	return dld.throttleStart.Add(expected).Sub(now)
+}
+
+// WaitN implements grab.RateLimiter, it blocks the download while paused, and throttles it if limited
+func (dld *Downloader) WaitN(ctx context.Context, n int) error {
+	for dld.paused() {
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if wait := dld.throttle(dld.limit(), n); wait > 0 {
+		// the duration of a throttled download does not reflect bandwidth either
+		dld.mu.Lock()
+		dld.wasPaused = true
+		dld.mu.Unlock()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// failed reports whether the download has finished with an error
+func (dld *Downloader) failed() bool {
+	select {
+	case <-dld.Done:
+		return dld.err != nil
+	default:
+		return false
+	}
+}
+
 func (dld *Downloader) Total() int64 {
 	return dld.resp.Size()
 }
@@ -92,7 +242,3 @@ func (dld *Downloader) Progress() float64 {
 func (dld *Downloader) Err() error {
 	return dld.err
 }
-
-func (dld *Downloader) Cancel() error {
-	return dld.resp.Cancel()
-}
@@ -0,0 +1,104 @@
+package daemon_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+// halfServer serves content, the second half of which is only sent once release is closed
+func halfServer(content string, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write([]byte(content[:len(content)/2]))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(content[len(content)/2:]))
+	}))
+}
+
+func waitStarted(t *testing.T, dld *daemon.Downloader) {
+	t.Helper()
+	for start := time.Now(); dld.Current() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("download does not start")
+		}
+	}
+}
+
+func TestDownloadShared(t *testing.T) {
+	release := make(chan struct{})
+	server := halfServer("0123456789", release)
+	defer server.Close()
+	dst := filepath.Join(t.TempDir(), "input.txt")
+	rm := daemon.NewResourceManager()
+	a, b := daemon.NewTestJob(&daemon.SchedulerConfig{}), daemon.NewTestJob(&daemon.SchedulerConfig{})
+	dld := rm.Download(dst, server.URL, a)
+	if other := rm.Download(dst, server.URL, b); other != dld {
+		t.Fatal("expect the same dst downloaded once")
+	}
+	waitStarted(t, dld)
+
+	// e.g. a times out, the download goes on for b
+	dld.Detach(a)
+	select {
+	case <-dld.Done:
+		t.Fatalf("expect download going on, got %v", dld.Err())
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-dld.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download does not finish")
+	}
+	dld.Detach(b)
+	if err := dld.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "0123456789" {
+		t.Errorf("expect file downloaded, got %q %v", data, err)
+	}
+}
+
+func TestDownloadAbandoned(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := halfServer("0123456789", release)
+	defer server.Close()
+	dst := filepath.Join(t.TempDir(), "input.txt")
+	rm := daemon.NewResourceManager()
+	a, b := daemon.NewTestJob(&daemon.SchedulerConfig{}), daemon.NewTestJob(&daemon.SchedulerConfig{})
+	dld := rm.Download(dst, server.URL, a)
+	waitStarted(t, dld)
+
+	// cancelled once no job waits for it
+	dld.Detach(a)
+	select {
+	case <-dld.Done:
+		if dld.Err() == nil {
+			t.Error("expect download cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download is not cancelled")
+	}
+	// it starts over for a later job
+	if other := rm.Download(dst, server.URL, b); other == dld {
+		t.Error("expect download started over")
+	} else {
+		other.Detach(b)
+	}
+}
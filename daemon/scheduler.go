@@ -2,9 +2,14 @@ package daemon
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/client"
@@ -12,13 +17,20 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	pb "github.com/sath-run/engine/daemon/protobuf"
+	"github.com/sath-run/engine/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 var (
-	ErrUnautherized = errors.New("unautherized")
-	ErrNoJob        = errors.New("no job")
-	ErrActionBusy   = errors.New("action busy")
-	ErrNoUser       = errors.New("no user")
+	ErrUnautherized   = errors.New("unautherized")
+	ErrNoJob          = errors.New("no job")
+	ErrActionBusy     = errors.New("action busy")
+	ErrNoUser         = errors.New("no user")
+	ErrJobNotFound    = errors.New("job not found")
+	ErrAmbiguousJobId = errors.New("job id matches more than one job")
+	ErrNoCapacity     = errors.New("no free capacity")
+	ErrNoImage        = errors.New("no image")
 )
 
 type Status int
@@ -47,8 +59,143 @@ type Volume struct {
 	Output string
 }
 
+type SchedulerConfig struct {
+	// interval of fetching new jobs from server, it backs off after errors and answers of no job
+	JobInterval time.Duration
+	// max number of jobs fetched by each request, e.g. for workloads of many short tasks
+	JobBatchSize int
+	// max interval of fetching new jobs when backing off
+	JobMaxInterval time.Duration
+	// max size in bytes of a job log file before it gets rotated
+	LogMaxSize int64
+	// max number of log files kept for each output stream of a job
+	LogMaxFiles int
+	// dir where logs of jobs are kept by job id and run, it survives restarts of engine
+	LogDir string
+	// how long the logs of a job are kept after its completion
+	LogRetention time.Duration
+	// number of trailing stderr lines attached to the failure message of a job
+	LogTailLines int
+	// min interval between notifications of progress and output lines of a job
+	NotifyInterval time.Duration
+	// max number of output lines buffered for notification, older lines are dropped when exceeded
+	NotifyBufferSize int
+	// max time of each stage of a job
+	ImagePullTimeout time.Duration
+	DownloadTimeout  time.Duration
+	RunTimeout       time.Duration
+	UploadTimeout    time.Duration
+	// a running job fails if it produces no output nor progress for this period, 0 to disable
+	StallTimeout time.Duration
+	// interval of sampling gpu stats, which are attached to notifications of running jobs
+	GpuStatsInterval time.Duration
+	// rules deciding when the host is available for jobs
+	Policy PolicyConfig
+	// dir where checkpoints of jobs are kept locally, it should survive restarts of engine
+	CheckpointDir string
+	// default interval of snapshotting checkpoints, if not specified by job
+	CheckpointInterval time.Duration
+	// how long local checkpoints of unfinished jobs are kept
+	CheckpointRetention time.Duration
+	// max size in bytes of images pulled by engine, least recently used ones are removed when exceeded, 0 to keep all
+	ImageDiskBudget int64
+	// interval of removing images exceeding the budget
+	ImageGCInterval time.Duration
+	// when images are pulled: "always", "if-not-present", or an interval of refreshing tags, e.g. "24h"
+	ImagePull       string
+	imagePullPolicy ImagePullPolicy
+	// rules restricting images which jobs may run
+	ImagePolicy ImagePolicyConfig
+	imagePolicy *ImagePolicy
+	// rules mapping registries to mirrors which are tried before them, e.g. "docker.io=mirror.lab:5000"
+	RegistryMirrors []string
+	// rules rewriting registries or repositories of images, the original ones are never used
+	ImageRewrites []string
+	imageResolver *ImageResolver
+	// what is fetched while the host is idle
+	Prefetch PrefetchConfig
+	// number of jobs fetched and prepared ahead, which wait for containers to free up
+	Lookahead int
+	// max bandwidth in bytes/s of downloading files of jobs ahead, 0 for unlimited
+	LookaheadBandwidth int64
+	// no job is fetched ahead if free disk space in bytes is less than this
+	LookaheadMinFreeDisk int64
+	// jobs are only polled, even if server assigns jobs by stream
+	PollOnly bool
+}
+
+func (config SchedulerConfig) withDefaults() *SchedulerConfig {
+	if config.JobInterval <= 0 {
+		config.JobInterval = 30 * time.Second
+	}
+	if config.JobBatchSize <= 0 {
+		config.JobBatchSize = 1
+	}
+	if config.JobMaxInterval < config.JobInterval {
+		config.JobMaxInterval = max(10*time.Minute, config.JobInterval)
+	}
+	if config.LogMaxSize <= 0 {
+		config.LogMaxSize = 10 * 1024 * 1024
+	}
+	if config.LogMaxFiles <= 0 {
+		config.LogMaxFiles = 3
+	}
+	if config.LogRetention <= 0 {
+		config.LogRetention = 24 * time.Hour
+	}
+	if config.LogTailLines <= 0 {
+		config.LogTailLines = 20
+	}
+	if config.NotifyInterval <= 0 {
+		config.NotifyInterval = 500 * time.Millisecond
+	}
+	if config.NotifyBufferSize <= 0 {
+		config.NotifyBufferSize = 1000
+	}
+	if config.ImagePullTimeout <= 0 {
+		config.ImagePullTimeout = 30 * time.Minute
+	}
+	if config.DownloadTimeout <= 0 {
+		config.DownloadTimeout = time.Hour
+	}
+	if config.RunTimeout <= 0 {
+		config.RunTimeout = 24 * time.Hour
+	}
+	if config.UploadTimeout <= 0 {
+		config.UploadTimeout = time.Hour
+	}
+	if config.GpuStatsInterval <= 0 {
+		config.GpuStatsInterval = 10 * time.Second
+	}
+	if config.LogDir == "" {
+		config.LogDir = filepath.Join(utils.SathHome, "logs")
+	}
+	if config.CheckpointDir == "" {
+		config.CheckpointDir = filepath.Join(utils.SathHome, "checkpoints")
+	}
+	if config.CheckpointInterval <= 0 {
+		config.CheckpointInterval = 30 * time.Minute
+	}
+	if config.CheckpointRetention <= 0 {
+		config.CheckpointRetention = 7 * 24 * time.Hour
+	}
+	if config.ImageGCInterval <= 0 {
+		config.ImageGCInterval = time.Hour
+	}
+	if config.ImagePull == "" {
+		config.ImagePull = "24h"
+	}
+	config.Policy = config.Policy.withDefaults()
+	config.Prefetch = config.Prefetch.withDefaults()
+	if config.LookaheadMinFreeDisk <= 0 {
+		config.LookaheadMinFreeDisk = 10 * 1024 * 1024 * 1024
+	}
+	return &config
+}
+
 type Scheduler struct {
 	c           *Connection
+	config      *SchedulerConfig
 	cli         *client.Client
 	rm          *ResourceManager
 	dir         string
@@ -58,12 +205,51 @@ type Scheduler struct {
 	actionLock  sync.Mutex
 	fetchLock   sync.Mutex
 	pendingJobs map[*Job]bool
-	logger      zerolog.Logger
+	// jobs which are not attached to containers yet, either being prepared or pending
+	ahead map[*Job]bool
+	// jobs fetched but not yet received by loop
+	fetched atomic.Int32
+	// decides when jobs are polled again, it is only used in loop
+	poll     PollBackoff
+	pollChan chan PollResult
+	// no job is polled before this time, even if a job completes, e.g. when backing off from errors
+	backoffUntil time.Time
+	// keeps the stream of job assignment connected, nil if jobs are only polled
+	assigner *assigner
+	// jobs assigned by server, which are admitted or rejected in loop
+	assignChan chan *pb.JobGetResponse
+	gpus       *gpuAllocator
+	gpuMonitor atomic.Pointer[gpuMonitor]
+	policy     *Policy
+	sensor     *HostSensor
+	policyLock sync.Mutex
+	policyChan chan []PolicyVerdict
+	// whether new jobs are fetched and whether running jobs are suspended, as decided by policy
+	available bool
+	suspended bool
+	// fetches images and resources while idle, nil if disabled
+	prefetcher *prefetcher
+	// last time the host was found busy, prefetching starts once it has been idle long enough
+	busyAt time.Time
+	// verdicts of the last evaluation of policy
+	verdicts   []PolicyVerdict
+	verdictsMu sync.Mutex
+	jobs       map[string]*Job
+	jobsMu     sync.Mutex
+	// whether docker supports checkpointing processes of containers
+	criu bool
+	// timings of recently completed jobs
+	benchmark jobBenchmark
+	// removes logs of completed jobs once retention expires
+	logRetention *logRetention
+	// images are pruned by one at a time
+	pruneLock sync.Mutex
+	logger    zerolog.Logger
 	// containers
 	containers []*Container
 }
 
-func NewScheduler(ctx context.Context, c *Connection, dir string, jobInterval time.Duration) (*Scheduler, error) {
+func NewScheduler(ctx context.Context, c *Connection, dir string, config *SchedulerConfig) (*Scheduler, error) {
 	docker, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return nil, err
@@ -72,23 +258,124 @@ func NewScheduler(ctx context.Context, c *Connection, dir string, jobInterval ti
 	if err := stopCurrentRunningContainers(ctx, docker); err != nil {
 		return nil, err
 	}
-	scheduler := Scheduler{
-		c:           c,
-		cli:         docker,
-		rm:          NewResourceManager(),
-		dir:         dir,
-		status:      StatusPaused,
-		jobChan:     make(chan *Job, 8),
-		containers:  []*Container{},
-		pendingJobs: map[*Job]bool{},
-		logger:      log.With().Str("component", "scheduler").Logger(),
+	gpuInfo := CollectSystemInfo(ctx, []Collector{&GpuCollector{Probe: HostProbe()}}).Gpu
+	if gpuInfo.Err != "" {
+		log.Debug().Str("err", gpuInfo.Err).Msg("no gpu detected")
+	}
+	scheduler, err := newScheduler(c, docker, dir, config, gpuInfo)
+	if err != nil {
+		return nil, err
+	}
+	config = scheduler.config
+	if err := os.MkdirAll(config.CheckpointDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	pruneCheckpoints(config.CheckpointDir, config.CheckpointRetention)
+	pruneJobLogs(config.LogDir, config.LogRetention)
+	// docker checkpoint is only available with experimental features enabled
+	if info, err := docker.Info(ctx); err == nil {
+		scheduler.criu = info.ExperimentalBuild
+	}
+	if !config.PollOnly {
+		scheduler.assigner = newAssigner(scheduler)
+		go scheduler.assigner.run()
+	}
+	scheduler.startGpuMonitor()
+	go scheduler.collectImages(config.ImageGCInterval)
+	go scheduler.loop(scheduler.config.JobInterval)
+	return scheduler, nil
+}
+
+// newScheduler creates a paused scheduler of gpus in gpuInfo, nothing is started until its loop runs
+func newScheduler(c *Connection, docker *client.Client, dir string, config *SchedulerConfig, gpuInfo *pb.GpuInfo) (*Scheduler, error) {
+	if config == nil {
+		config = &SchedulerConfig{}
+	}
+	config = config.withDefaults()
+	policy, err := NewPolicy(&config.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if config.imagePullPolicy, err = ParseImagePullPolicy(config.ImagePull); err != nil {
+		return nil, err
+	}
+	if config.imagePolicy, err = NewImagePolicy(&config.ImagePolicy); err != nil {
+		return nil, err
+	}
+	if config.imageResolver, err = NewImageResolver(config.RegistryMirrors, config.ImageRewrites); err != nil {
+		return nil, err
+	}
+	scheduler := &Scheduler{
+		c:            c,
+		config:       config,
+		cli:          docker,
+		rm:           NewResourceManager(),
+		dir:          dir,
+		status:       StatusPaused,
+		jobChan:      make(chan *Job, 8),
+		containers:   []*Container{},
+		pendingJobs:  map[*Job]bool{},
+		ahead:        map[*Job]bool{},
+		poll:         PollBackoff{Base: config.JobInterval, Max: config.JobMaxInterval},
+		pollChan:     make(chan PollResult, 1),
+		assignChan:   make(chan *pb.JobGetResponse),
+		jobs:         map[string]*Job{},
+		gpus:         newGpuAllocator(gpuDevices(gpuInfo)),
+		policy:       policy,
+		policyChan:   make(chan []PolicyVerdict, 1),
+		available:    policy.Empty(),
+		busyAt:       time.Now(),
+		logRetention: newLogRetention(config.LogDir, config.LogRetention),
+		logger:       log.With().Str("component", "scheduler").Logger(),
+	}
+	scheduler.sensor = &HostSensor{
+		Probe: HostProbe(),
+		GpuTemperature: func() float64 {
+			if monitor := scheduler.gpuMonitor.Load(); monitor != nil {
+				return monitor.temperature()
+			}
+			return 0
+		},
+	}
+	if config.Policy.IdleCpu > 0 {
+		scheduler.sensor.CpuWindow = time.Second
+	}
+	if !config.Prefetch.Disabled {
+		if scheduler.prefetcher, err = newPrefetcher(scheduler, config.Prefetch); err != nil {
+			return nil, err
+		}
+	}
+	return scheduler, nil
+}
+
+// startGpuMonitor starts sampling gpu stats if there is any nvidia gpu and it is not started yet,
+// it keeps sampling as long as the scheduler lives
+func (scheduler *Scheduler) startGpuMonitor() {
+	if !scheduler.gpus.hasMatching(&pb.GpuConf{Model: []pb.GpuModel{pb.GpuModel_EGM_NVIDIA}}) {
+		return
+	}
+	monitor := newGpuMonitor(scheduler.config.GpuStatsInterval, func() ([]*pb.GpuStats, error) {
+		return CollectGpuStats(HostProbe())
+	})
+	if scheduler.gpuMonitor.CompareAndSwap(nil, monitor) {
+		go monitor.run(context.Background())
+	}
+}
+
+// UpdateGpus updates gpus which can be assigned to jobs, e.g. when an external gpu is plugged in
+func (scheduler *Scheduler) UpdateGpus(info *pb.GpuInfo) {
+	added, removed := scheduler.gpus.update(gpuDevices(info))
+	if len(added) > 0 || len(removed) > 0 {
+		scheduler.logger.Info().Strs("added", added).Strs("removed", removed).Msg("gpus changed")
 	}
-	go scheduler.loop(jobInterval)
-	return &scheduler, nil
+	scheduler.startGpuMonitor()
 }
 
 func (scheduler *Scheduler) loop(jobInterval time.Duration) {
+	pollTimer := time.NewTimer(scheduler.poll.First())
 	ticker := time.NewTicker(jobInterval)
+	policyTicker := time.NewTicker(scheduler.config.Policy.Interval)
+	scheduler.evaluatePolicy()
 
 	// main logic of scheduler, note that each code inside the for loop should be noneblock
 	for {
@@ -96,7 +383,11 @@ func (scheduler *Scheduler) loop(jobInterval time.Duration) {
 		case job := <-scheduler.jobChan:
 			if job.err != nil {
 				job.logger.Info().Err(job.err).Str("state", job.state.String()).Send()
-				go job.handleCompletion()
+				if job.state == pb.EnumExecState_EES_INITIALIZED {
+					scheduler.fetched.Add(-1)
+				}
+				delete(scheduler.ahead, job)
+				scheduler.completeJob(job)
 				if job.container != nil {
 					scheduler.rescheduleContainer(job.container)
 				}
@@ -104,9 +395,15 @@ func (scheduler *Scheduler) loop(jobInterval time.Duration) {
 			}
 			switch job.state {
 			case pb.EnumExecState_EES_INITIALIZED:
+				scheduler.fetched.Add(-1)
+				scheduler.ahead[job] = true
+				scheduler.updateLookahead()
 				go job.preprocess()
 			case pb.EnumExecState_EES_QUEUING:
-				if job.container == nil {
+				if job.container == nil && scheduler.suspended {
+					// jobs do not start while suspended, they are scheduled once resumed
+					scheduler.pendingJobs[job] = true
+				} else if job.container == nil {
 					scheduler.attachContainerForJob(job)
 				}
 				if job.container != nil {
@@ -118,18 +415,39 @@ func (scheduler *Scheduler) loop(jobInterval time.Duration) {
 				scheduler.rescheduleContainer(job.container)
 			case pb.EnumExecState_EES_SUCCESS:
 				job.logger.Info().Msg("succeed")
-				go job.handleCompletion()
+				scheduler.completeJob(job)
 			default:
-				job.err = errors.New("unexpected job state")
+				job.setErr(errors.New("unexpected job state"))
 				job.logger.Fatal().Str("state", job.state.String()).Err(job.err).Send()
 				scheduler.jobChan <- job
 			}
-		case <-ticker.C:
+		case <-pollTimer.C:
 			scheduler.fetchNewJob()
+			// poll again at the base interval, unless the result of polling decides otherwise
+			pollTimer.Reset(jobInterval)
+		case result := <-scheduler.pollChan:
+			d := scheduler.poll.Next(result)
+			scheduler.backoffUntil = time.Time{}
+			if result.Err != nil || result.RetryAfter > 0 || !result.Job {
+				// e.g. a completed job does not poll again while the server has no job
+				scheduler.backoffUntil = time.Now().Add(d)
+			}
+			resetTimer(pollTimer, d)
+		case res := <-scheduler.assignChan:
+			scheduler.admitJobs(res)
+		case <-ticker.C:
+			scheduler.prefetch()
+		case <-policyTicker.C:
+			scheduler.evaluatePolicy()
+		case verdicts := <-scheduler.policyChan:
+			scheduler.applyPolicy(verdicts)
 		case <-scheduler.closeChan:
+			pollTimer.Stop()
 			ticker.Stop()
+			policyTicker.Stop()
 			return
 		}
+		scheduler.advertise()
 	}
 }
 
@@ -157,33 +475,73 @@ func (scheduler *Scheduler) Pause() {
 	scheduler.performAction(ActionPause)
 }
 
-func (scheduler *Scheduler) fetchNewJob() {
-	if scheduler.status != StatusRunning {
-		return
-	}
-	runningJobs := 0
+// jobSlots returns the number of jobs which may run at the same time, and the number of those running
+func (scheduler *Scheduler) jobSlots() (int, int) {
+	running := 0
 	for _, container := range scheduler.containers {
 		if container.currentJob != nil {
-			runningJobs++
+			running++
 		}
 	}
-	if runningJobs > 1 {
-		// TODO: instead of checking number of running jobs, it's better to check system resources
+	// TODO: instead of checking number of running jobs, it's better to check system resources
+	return max(2, scheduler.gpus.count()), running
+}
+
+// updateLookahead throttles downloads of jobs which will wait for containers to free up,
+// so that they do not slow down jobs about to run. The earliest fetched jobs run first.
+func (scheduler *Scheduler) updateLookahead() {
+	slots, running := scheduler.jobSlots()
+	jobs := sortedByCreation(scheduler.ahead)
+	for i, job := range jobs {
+		job.ahead.Store(i >= slots-running)
+	}
+}
+
+// sortedByCreation returns jobs of set in the order they are created
+func sortedByCreation(set map[*Job]bool) []*Job {
+	jobs := make([]*Job, 0, len(set))
+	for job := range set {
+		jobs = append(jobs, job)
+	}
+	slices.SortFunc(jobs, func(a, b *Job) int {
+		return a.createdAt.Compare(b.createdAt)
+	})
+	return jobs
+}
+
+func (scheduler *Scheduler) fetchNewJob() {
+	if scheduler.status != StatusRunning || !scheduler.available || time.Now().Before(scheduler.backoffUntil) {
+		return
+	}
+	if scheduler.assigner != nil && scheduler.assigner.connected.Load() {
+		// jobs are pushed by server as capacity is advertised
 		return
 	}
-	if len(scheduler.pendingJobs) > 0 {
+	slots, running := scheduler.jobSlots()
+	free := max(slots-running, 0)
+	ahead := len(scheduler.ahead) + int(scheduler.fetched.Load())
+	if ahead >= free+scheduler.config.Lookahead {
 		return
 	}
+	// the job would wait for a container to free up
+	lookahead := ahead >= free
 	user := scheduler.c.user
 	if user == nil {
 		return
 	}
+	capacity, startable := scheduler.capacity()
+	// no more jobs are fetched than those which start or wait ahead
+	batchSize := min(scheduler.config.JobBatchSize, int(capacity.Jobs))
 	go func() {
 		// make sure only one goroutine of fetchNewJob is running
 		if !scheduler.fetchLock.TryLock() {
 			return
 		}
 		defer scheduler.fetchLock.Unlock()
+		if lookahead && scheduler.diskSpaceLow(scheduler.config.LookaheadMinFreeDisk) {
+			scheduler.logger.Debug().Msg("disk space is low, no job is fetched ahead")
+			return
+		}
 		var (
 			ctx    context.Context
 			cancel context.CancelFunc
@@ -191,69 +549,420 @@ func (scheduler *Scheduler) fetchNewJob() {
 		ctx = scheduler.c.AppendToOutgoingContext(context.Background(), user)
 		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
-		res, err := scheduler.c.GetNewJob(ctx, &pb.JobGetRequest{})
+		var trailer metadata.MD
+		res, err := scheduler.c.GetNewJob(ctx, &pb.JobGetRequest{
+			BatchSize: uint32(batchSize),
+			Capacity:  capacity,
+		}, grpc.Trailer(&trailer))
 		if err != nil {
 			scheduler.logger.Warn().Err(err).Msg("scheduler fails to get a new job")
+			scheduler.pollChan <- PollResult{Err: err, RetryAfter: retryAfter(trailer)}
 			return
 		}
-		if res == nil || len(res.JobId) == 0 {
+		jobs := BatchJobs(res)
+		if len(jobs) == 0 {
 			// no available jobs from server
 			scheduler.logger.Info().Msg("no available jobs from server")
+			result := PollResult{RetryAfter: retryAfter(trailer)}
+			if res != nil && res.RetryAfter > 0 {
+				result.RetryAfter = time.Duration(res.RetryAfter) * time.Second
+			}
+			scheduler.pollChan <- result
 			return
 		}
-		dir := filepath.Join(scheduler.dir, "job_"+res.JobId)
-		ctx = scheduler.c.AppendToOutgoingContext(context.Background(), user)
-		job, err := newJob(ctx, scheduler.c, scheduler.cli, scheduler.jobChan, scheduler.rm, dir, res)
-		if err != nil {
-			scheduler.logger.Warn().Err(err).Msg("scheduler fails to create job")
+		scheduler.fetched.Add(int32(len(jobs)))
+		accepted := 0
+		for i, job := range jobs {
+			if i >= batchSize {
+				// the server returns more jobs than asked, they fail at once so that it assigns them elsewhere
+				if err := scheduler.addJob(job, user, true, ErrNoCapacity); err != nil {
+					scheduler.logger.Warn().Err(err).Msg("scheduler fails to reject job")
+				}
+				continue
+			}
+			if err = scheduler.acceptJob(job, user, i >= startable); err != nil {
+				scheduler.logger.Warn().Err(err).Msg("scheduler fails to create job")
+			} else {
+				accepted++
+			}
+		}
+		if accepted == 0 {
+			scheduler.pollChan <- PollResult{Err: err}
 			return
 		}
-		scheduler.logger.Trace().Any("scheduler fetched new job", res).Send()
-		scheduler.jobChan <- job
+		scheduler.pollChan <- PollResult{Job: true, RetryAfter: time.Duration(res.RetryAfter) * time.Second}
 	}()
 }
 
-func (scheduler *Scheduler) attachContainerForJob(job *Job) bool {
-	var container *Container
+// acceptJob creates a job fetched from server and hands it to loop, the job should have been counted in fetched
+func (scheduler *Scheduler) acceptJob(res *pb.JobGetResponse, user *User, lookahead bool) error {
+	var reject error
+	if scheduler.gpus.unsatisfiable(res.GpuConf) {
+		// fails before anything is downloaded, the job can never run on this device
+		reject = ErrNoMatchingGpu
+	} else if res.Image == nil {
+		reject = ErrNoImage
+	}
+	return scheduler.addJob(res, user, lookahead, reject)
+}
 
-	// find container if any
-	for _, c := range scheduler.containers {
-		if c.imageUrl == job.metadata.Image.Url && c.resourceId == job.metadata.ResourceId {
-			container = c
-			break
+// addJob creates a job fetched or assigned, and hands it to loop. If reject is not nil, the job fails
+// at once with it, which is notified to server.
+func (scheduler *Scheduler) addJob(res *pb.JobGetResponse, user *User, lookahead bool, reject error) error {
+	dir := filepath.Join(scheduler.dir, "job_"+res.JobId)
+	// logs of an earlier run of the job are kept until this run expires
+	scheduler.logRetention.reuse(res.JobId)
+	ctx := scheduler.c.AppendToOutgoingContext(context.Background(), user)
+	job, err := newJob(ctx, scheduler.c, scheduler.cli, scheduler.jobChan, scheduler.rm, scheduler.config, dir, res)
+	if err != nil {
+		scheduler.fetched.Add(-1)
+		return err
+	}
+	job.gpuMonitor = scheduler.gpuMonitor.Load()
+	scheduler.logger.Trace().Any("scheduler fetched new job", res).Send()
+	job.ahead.Store(lookahead)
+	if reject != nil {
+		job.setErr(reject)
+	}
+	scheduler.jobsMu.Lock()
+	scheduler.jobs[res.JobId] = job
+	scheduler.jobsMu.Unlock()
+	scheduler.jobChan <- job
+	return nil
+}
+
+// diskSpaceLow reports whether free disk space of the data dir is less than min, false if it can not be told
+func (scheduler *Scheduler) diskSpaceLow(min int64) bool {
+	out, err := HostProbe().Command("df", "-Pk", scheduler.dir)
+	if err != nil {
+		return false
+	}
+	_, free, err := parseDf(out)
+	return err == nil && free < uint64(min)
+}
+
+func (scheduler *Scheduler) completeJob(job *Job) {
+	go func() {
+		job.handleCompletion()
+		if job.err == nil {
+			scheduler.benchmark.add(job.timings())
 		}
+		scheduler.logRetention.expire(job.metadata.JobId, job.logRun())
+		scheduler.jobsMu.Lock()
+		delete(scheduler.jobs, job.metadata.JobId)
+		scheduler.jobsMu.Unlock()
+	}()
+}
+
+func (scheduler *Scheduler) getJob(id string) *Job {
+	scheduler.jobsMu.Lock()
+	defer scheduler.jobsMu.Unlock()
+	return scheduler.jobs[id]
+}
+
+func (scheduler *Scheduler) allJobs() []*Job {
+	scheduler.jobsMu.Lock()
+	defer scheduler.jobsMu.Unlock()
+	jobs := make([]*Job, 0, len(scheduler.jobs))
+	for _, job := range scheduler.jobs {
+		jobs = append(jobs, job)
 	}
+	return jobs
+}
 
-	if container == nil {
-		// allocate new container
-		// TODO: should check system resouces before deciding to create a new container
-
-		// ignore mkdir error if any, it will be handled inside job.run
-		dir, _ := os.MkdirTemp(scheduler.dir, "container_")
-		container = newContainer(scheduler.cli, dir, job)
-		scheduler.containers = append(scheduler.containers, container)
-		job.logger.Debug().Str("dir", dir).Msg("container created for job")
-	} else if container.currentJob == nil {
-		container.currentJob = job
-		scheduler.logger.Debug().Str("container", container.id).Str("job", job.metadata.JobId).Msg("attach container for job")
+// findJobs finds current jobs by ids, an id may be shortened as long as it matches a single job.
+// All jobs are returned if ids is empty.
+func (scheduler *Scheduler) findJobs(ids []string) ([]*Job, error) {
+	all := scheduler.allJobs()
+	if len(ids) == 0 {
+		return all, nil
+	}
+	jobs := []*Job{}
+	for _, id := range ids {
+		var found []*Job
+		for _, job := range all {
+			if job.metadata.JobId == id {
+				found = []*Job{job}
+				break
+			} else if id != "" && strings.Contains(job.metadata.JobId, id) {
+				found = append(found, job)
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+		} else if len(found) > 1 {
+			return nil, fmt.Errorf("%w: %s", ErrAmbiguousJobId, id)
+		}
+		jobs = append(jobs, found[0])
+	}
+	return jobs, nil
+}
+
+// PauseJobs pauses jobs by ids, or all current jobs if ids is empty, and returns ids of paused jobs
+func (scheduler *Scheduler) PauseJobs(ids []string) ([]string, error) {
+	return scheduler.updateJobs(ids, (*Job).Pause)
+}
+
+// ResumeJobs resumes jobs paused by PauseJobs
+func (scheduler *Scheduler) ResumeJobs(ids []string) ([]string, error) {
+	return scheduler.updateJobs(ids, (*Job).Resume)
+}
+
+func (scheduler *Scheduler) updateJobs(ids []string, update func(job *Job) error) ([]string, error) {
+	jobs, err := scheduler.findJobs(ids)
+	if err != nil {
+		return nil, err
+	}
+	updated := []string{}
+	for _, job := range jobs {
+		if err := update(job); err != nil {
+			return updated, err
+		}
+		updated = append(updated, job.metadata.JobId)
+	}
+	return updated, nil
+}
+
+// JobBenchmark returns timings of recently succeeded jobs, including the overhead of engine
+func (scheduler *Scheduler) JobBenchmark() JobBenchmark {
+	return scheduler.benchmark.report()
+}
+
+// Jobs returns status of current jobs, ordered by creation time
+func (scheduler *Scheduler) Jobs() []JobStatus {
+	scheduler.jobsMu.Lock()
+	jobs := make([]JobStatus, 0, len(scheduler.jobs))
+	for _, job := range scheduler.jobs {
+		jobs = append(jobs, job.Status())
+	}
+	scheduler.jobsMu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+func (scheduler *Scheduler) lookupJobLogs(id string) (string, <-chan struct{}, error) {
+	if id == "" || filepath.Base(id) != id {
+		return "", nil, ErrJobNotFound
+	}
+	if job := scheduler.getJob(id); job != nil {
+		return job.logDir(), job.finished, nil
+	}
+	// logs of the latest run of a completed job
+	dir := latestJobLogs(filepath.Join(scheduler.config.LogDir, id))
+	if dir == "" {
+		return "", nil, ErrJobNotFound
+	}
+	finished := make(chan struct{})
+	close(finished)
+	return dir, finished, nil
+}
+
+// HasJobLogs reports whether id is a running job, or a finished job whose logs are still retained
+func (scheduler *Scheduler) HasJobLogs(id string) bool {
+	_, _, err := scheduler.lookupJobLogs(id)
+	return err == nil
+}
+
+// JobLogs reads the logs of a running job, or of a finished job whose logs are still retained
+func (scheduler *Scheduler) JobLogs(ctx context.Context, id string, options *LogOptions, fn func(LogEntry) error) error {
+	dir, finished, err := scheduler.lookupJobLogs(id)
+	if err != nil {
+		return err
+	}
+	return readJobLogs(ctx, dir, options, finished, fn)
+}
+
+func (scheduler *Scheduler) attachContainerForJob(job *Job) bool {
+	conf := job.metadata.GpuConf
+	if conf == nil {
+		conf = &pb.GpuConf{}
+	}
+
+	if slots, running := scheduler.jobSlots(); running >= slots {
+		// no more tasks run at once than slots, jobs fetched ahead wait until one frees up,
+		// even if an idle container could run them
+		scheduler.pendingJobs[job] = true
+		scheduler.logger.Debug().Int("pendingJobs", len(scheduler.pendingJobs)).Str("job", job.metadata.JobId).Msg("job queued, no free slot")
+		return false
+	}
+
+	var container *Container
+	if conf.Opt == pb.GpuOpt_EGO_None {
+		container = scheduler.findIdleContainer(job, nil)
+		if container == nil {
+			container = scheduler.createContainer(job, nil)
+		}
 	} else {
-		// TODO: support multiple container for the same image
-		container = nil
+		container = scheduler.findIdleContainer(job, conf)
+		if container == nil {
+			container = scheduler.createContainer(job, conf)
+		}
+		if container == nil && conf.Opt == pb.GpuOpt_EGO_PREFERRED {
+			// no gpu is available, fallback to cpu
+			container = scheduler.findIdleContainer(job, nil)
+			if container == nil {
+				container = scheduler.createContainer(job, nil)
+			}
+		}
+		if container == nil && !scheduler.gpus.hasMatching(conf) {
+			// the job can never run on this device
+			delete(scheduler.pendingJobs, job)
+			job.setErr(ErrNoMatchingGpu)
+			go func() {
+				scheduler.jobChan <- job
+			}()
+			return false
+		}
 	}
+
 	if container == nil {
 		// if no container found nor a new container was allocated, enqueue job
 		scheduler.pendingJobs[job] = true
 		scheduler.logger.Debug().Int("pendingJobs", len(scheduler.pendingJobs)).Str("job", job.metadata.JobId).Msg("job queued")
 		return false
 	}
+	container.currentJob = job
+	job.mu.Lock()
 	job.container = container
+	job.mu.Unlock()
+	job.ahead.Store(false)
+	delete(scheduler.ahead, job)
+	scheduler.logger.Debug().Str("container", container.id).Str("job", job.metadata.JobId).Msg("attach container for job")
 	return true
 }
 
+// findIdleContainer finds an idle container with the same image and resource of job.
+// If gpuConf is nil, only containers without gpu are considered,
+// otherwise the gpu of container should satisfy gpuConf.
+func (scheduler *Scheduler) findIdleContainer(job *Job, gpuConf *pb.GpuConf) *Container {
+	for _, c := range scheduler.containers {
+		if c.currentJob != nil || c.criu || c.imageUrl != job.runImageRef() || c.resourceId != job.metadata.ResourceId {
+			continue
+		}
+		if c.checkpointPath != job.checkpointPath() {
+			continue
+		}
+		if gpuConf == nil && c.gpu == nil {
+			return c
+		} else if gpuConf != nil && c.gpu != nil && gpuMatches(c.gpu, gpuConf) && scheduler.gpus.present(c.gpu) {
+			return c
+		}
+	}
+	return nil
+}
+
+// hasIdleContainer reports whether there is an idle container with the same image and resource of job
+func (scheduler *Scheduler) hasIdleContainer(job *Job) bool {
+	return slices.ContainsFunc(scheduler.containers, func(c *Container) bool {
+		return c.currentJob == nil && !c.criu && c.imageUrl == job.runImageRef() &&
+			c.resourceId == job.metadata.ResourceId && c.checkpointPath == job.checkpointPath()
+	})
+}
+
+// cmpBool compares booleans with false before true
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// createContainer allocates a new container for job. If gpuConf is not nil, a gpu is assigned
+// to the container, and nil is returned if no gpu is available.
+func (scheduler *Scheduler) createContainer(job *Job, gpuConf *pb.GpuConf) *Container {
+	// TODO: should check system resouces before deciding to create a new container
+	var gpu *gpuDevice
+	if gpuConf != nil {
+		gpu = scheduler.gpus.allocate(gpuConf)
+		if gpu == nil && scheduler.evictIdleGpuContainer(gpuConf) {
+			gpu = scheduler.gpus.allocate(gpuConf)
+		}
+		if gpu == nil {
+			return nil
+		}
+	}
+
+	// ignore mkdir error if any, it will be handled inside job.run
+	dir, _ := os.MkdirTemp(scheduler.dir, "container_")
+	criu := false
+	if conf := job.metadata.Checkpoint; conf != nil && conf.Criu {
+		if scheduler.criu {
+			criu = true
+		} else {
+			job.logger.Warn().Msg("docker checkpoint is not supported, only the checkpoint dir is saved")
+		}
+	}
+	container := newContainer(scheduler.cli, dir, job, gpu, criu)
+	scheduler.containers = append(scheduler.containers, container)
+	event := job.logger.Debug().Str("dir", dir)
+	if gpu != nil {
+		event = event.Str("gpu", gpu.Uuid)
+	}
+	event.Msg("container created for job")
+	return container
+}
+
+// evictIdleGpuContainer removes an idle container whose gpu satisfies gpuConf, so that
+// the gpu can be assigned to a new container. It returns false if there is no such container.
+func (scheduler *Scheduler) evictIdleGpuContainer(gpuConf *pb.GpuConf) bool {
+	for i, c := range scheduler.containers {
+		if c.currentJob != nil || c.gpu == nil || !gpuMatches(c.gpu, gpuConf) {
+			continue
+		}
+		c.logger.Debug().Str("gpu", c.gpu.Uuid).Msg("evict idle container")
+		scheduler.removeContainer(i)
+		return true
+	}
+	return false
+}
+
+// removeContainer removes the i-th container and releases its gpu
+func (scheduler *Scheduler) removeContainer(i int) {
+	c := scheduler.containers[i]
+	scheduler.containers = slices.Delete(scheduler.containers, i, i+1)
+	if c.gpu != nil {
+		scheduler.gpus.release(c.gpu)
+	}
+	go func() {
+		if err := c.remove(context.Background()); err != nil {
+			c.logger.Warn().Err(err).Msg("fail to remove container")
+		}
+		os.RemoveAll(c.dir)
+	}()
+}
+
 func (scheduler *Scheduler) rescheduleContainer(container *Container) {
 	container.currentJob = nil
+	if container.criu {
+		// the task was the main process of container, which can not run another one
+		if i := slices.Index(scheduler.containers, container); i >= 0 {
+			scheduler.removeContainer(i)
+		}
+	}
+	scheduler.schedulePendingJobs()
+	scheduler.updateLookahead()
+	scheduler.fetchNewJob()
+}
+
+// schedulePendingJobs attaches containers for pending jobs, and runs those attached
+func (scheduler *Scheduler) schedulePendingJobs() {
+	if scheduler.suspended {
+		return
+	}
 	jobs := []*Job{}
-	for job := range scheduler.pendingJobs {
+	// jobs fetched earlier run first, except that jobs which can reuse idle containers go ahead,
+	// so that batched jobs sharing an image run back-to-back in the same warm container
+	pending := sortedByCreation(scheduler.pendingJobs)
+	slices.SortStableFunc(pending, func(a, b *Job) int {
+		return cmpBool(scheduler.hasIdleContainer(b), scheduler.hasIdleContainer(a))
+	})
+	for _, job := range pending {
 		scheduler.attachContainerForJob(job)
 		if job.container != nil {
 			// if successfully attached
@@ -264,5 +973,87 @@ func (scheduler *Scheduler) rescheduleContainer(container *Container) {
 		delete(scheduler.pendingJobs, job)
 		scheduler.jobChan <- job
 	}
-	scheduler.fetchNewJob()
+}
+
+// evaluatePolicy reads the state of host and evaluates policy in background,
+// verdicts are sent back to the loop of scheduler
+func (scheduler *Scheduler) evaluatePolicy() {
+	if scheduler.policy.Empty() {
+		return
+	}
+	containers := []*Container{}
+	for _, c := range scheduler.containers {
+		if c.currentJob != nil {
+			containers = append(containers, c)
+		}
+	}
+	go func() {
+		// skip if the last evaluation has not finished
+		if !scheduler.policyLock.TryLock() {
+			return
+		}
+		defer scheduler.policyLock.Unlock()
+		sensor := *scheduler.sensor
+		sensor.JobsCpu = func(ctx context.Context) time.Duration {
+			var total time.Duration
+			for _, c := range containers {
+				if t, err := c.cpuTime(ctx); err == nil {
+					total += t
+				}
+			}
+			return total
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		scheduler.policyChan <- scheduler.policy.Evaluate(sensor.Read(ctx))
+	}()
+}
+
+// applyPolicy stops or resumes fetching new jobs, and suspends or resumes running jobs by verdicts of policy
+func (scheduler *Scheduler) applyPolicy(verdicts []PolicyVerdict) {
+	available, suspend := true, false
+	reasons := []string{}
+	for _, v := range verdicts {
+		if !v.Allow {
+			available = false
+			suspend = suspend || v.Suspend
+			reasons = append(reasons, v.Rule+": "+v.Reason)
+		}
+	}
+	scheduler.verdictsMu.Lock()
+	scheduler.verdicts = verdicts
+	scheduler.verdictsMu.Unlock()
+
+	if available && !scheduler.available {
+		scheduler.logger.Info().Msg("host becomes available for jobs")
+	} else if !available && scheduler.available {
+		scheduler.logger.Info().Strs("reasons", reasons).Msg("host becomes unavailable for jobs")
+	}
+	resumed := scheduler.suspended && !suspend
+	becameAvailable := available && !scheduler.available
+	scheduler.available = available
+	scheduler.suspended = suspend
+
+	// jobs are checked on every evaluation, since new jobs may be created after last one
+	go func() {
+		for _, job := range scheduler.allJobs() {
+			if err := job.suspend(suspend); err != nil {
+				job.logger.Warn().Err(err).Bool("suspend", suspend).Msg("fail to suspend or resume job")
+			}
+		}
+	}()
+	if resumed {
+		scheduler.schedulePendingJobs()
+	}
+	if becameAvailable {
+		// otherwise jobs are polled as usual, policy is evaluated far more often
+		scheduler.fetchNewJob()
+	}
+}
+
+// PolicyVerdicts returns verdicts of the last evaluation of policy, nil if it has not been evaluated
+func (scheduler *Scheduler) PolicyVerdicts() []PolicyVerdict {
+	scheduler.verdictsMu.Lock()
+	defer scheduler.verdictsMu.Unlock()
+	return slices.Clone(scheduler.verdicts)
 }
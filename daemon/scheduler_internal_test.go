@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"google.golang.org/grpc"
+)
+
+// testEngineClient answers jobs polled by getNewJob, other calls to server are not expected
+type testEngineClient struct {
+	pb.EngineClient
+	getNewJob func(in *pb.JobGetRequest) (*pb.JobGetResponse, error)
+}
+
+func (client *testEngineClient) GetNewJob(ctx context.Context, in *pb.JobGetRequest, opts ...grpc.CallOption) (*pb.JobGetResponse, error) {
+	return client.getNewJob(in)
+}
+
+func (client *testEngineClient) NotifyExecStatus(ctx context.Context, opts ...grpc.CallOption) (pb.Engine_NotifyExecStatusClient, error) {
+	return testNotifyStream{}, nil
+}
+
+// testNotifyStream discards notifications of jobs
+type testNotifyStream struct {
+	pb.Engine_NotifyExecStatusClient
+}
+
+func (testNotifyStream) Send(*pb.ExecNotificationRequest) error {
+	return nil
+}
+
+// newTestScheduler returns a running scheduler with gpus of info, whose loop is not started, nor connected to docker.
+// Jobs are polled from client by a logged in user.
+func newTestScheduler(t *testing.T, client *testEngineClient, config *SchedulerConfig, info *pb.GpuInfo) *Scheduler {
+	t.Helper()
+	c := &Connection{EngineClient: client, user: &User{Id: "test"}}
+	scheduler, err := newScheduler(c, nil, t.TempDir(), config, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.status = StatusRunning
+	scheduler.logger = zerolog.Nop()
+	return scheduler
+}
+
+// newTestSchedulerJob returns a test job of id, whose image is prepared
+func newTestSchedulerJob(config *SchedulerConfig, id string, image string) *Job {
+	job := NewTestJob(config)
+	job.metadata = &pb.JobGetResponse{JobId: id, Image: &pb.Image{Url: image}}
+	job.image, job.runImage = image, image
+	return job
+}
+
+// queue attaches a container to a job fetched ahead once it is prepared, as the loop does
+func (scheduler *Scheduler) queue(job *Job) bool {
+	scheduler.ahead[job] = true
+	return scheduler.attachContainerForJob(job)
+}
+
+// runningContainers returns the number of containers, and the number of those running jobs
+func (scheduler *Scheduler) runningContainers() (int, int) {
+	_, running := scheduler.jobSlots()
+	return len(scheduler.containers), running
+}
+
+func TestSchedulerSlots(t *testing.T) {
+	config := &SchedulerConfig{Lookahead: 2, LogDir: t.TempDir()}
+	s := newTestScheduler(t, nil, config, &pb.GpuInfo{})
+	// no more jobs are polled once a job completes
+	s.status = StatusPaused
+	jobs := []*Job{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		jobs = append(jobs, newTestSchedulerJob(config, id, "sath/"+id))
+	}
+	// two slots without gpus, jobs fetched ahead wait for them instead of starting containers
+	for i, job := range jobs {
+		if attached := s.queue(job); attached != (i < 2) {
+			t.Errorf("job %d: expect attached %v, got %v", i, i < 2, attached)
+		}
+	}
+	if containers, running := s.runningContainers(); containers != 2 || running != 2 {
+		t.Errorf("expect 2 containers running, got %d containers with %d running", containers, running)
+	}
+	if pending := len(s.pendingJobs); pending != 2 {
+		t.Errorf("expect 2 pending jobs, got %d", pending)
+	}
+
+	// the earliest pending job starts once a slot frees up
+	s.rescheduleContainer(jobs[0].container)
+	if jobs[2].container == nil || jobs[3].container != nil {
+		t.Errorf("expect only job c to start, got c %v d %v", jobs[2].container != nil, jobs[3].container != nil)
+	}
+	if containers, running := s.runningContainers(); containers != 3 || running != 2 {
+		t.Errorf("expect 3 containers with 2 running, got %d containers with %d running", containers, running)
+	}
+	if pending := len(s.pendingJobs); pending != 1 {
+		t.Errorf("expect 1 pending job, got %d", pending)
+	}
+}
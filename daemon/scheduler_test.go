@@ -13,8 +13,10 @@ import (
 	pb "github.com/sath-run/engine/daemon/protobuf"
 	"github.com/sath-run/engine/meta"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type ClientStream struct {
@@ -80,6 +82,9 @@ func (client *EngineClient) HandShake(ctx context.Context, in *pb.HandShakeReque
 		DeviceId: "Device001",
 	}, nil
 }
+func (client *EngineClient) UpdateSystemInfo(ctx context.Context, in *pb.SystemInfoUpdateRequest, opts ...grpc.CallOption) (*pb.SystemInfoUpdateResponse, error) {
+	return &pb.SystemInfoUpdateResponse{}, nil
+}
 func (client *EngineClient) Login(ctx context.Context, in *pb.LoginRequest, opts ...grpc.CallOption) (*pb.LoginResponse, error) {
 	return &pb.LoginResponse{
 		Token:     "TestUserToken",
@@ -95,6 +100,13 @@ func (client *EngineClient) NotifyExecStatus(ctx context.Context, opts ...grpc.C
 func (client *EngineClient) RouteCommand(ctx context.Context, opts ...grpc.CallOption) (pb.Engine_RouteCommandClient, error) {
 	return client.RouteCommandClient, nil
 }
+func (client *EngineClient) GetPrefetchHint(ctx context.Context, in *pb.PrefetchHintRequest, opts ...grpc.CallOption) (*pb.PrefetchHintResponse, error) {
+	return &pb.PrefetchHintResponse{}, nil
+}
+
+func (client *EngineClient) AssignJobs(ctx context.Context, opts ...grpc.CallOption) (pb.Engine_AssignJobsClient, error) {
+	return nil, status.Error(codes.Unimplemented, "jobs are polled")
+}
 
 var jobCount = 2
 
@@ -135,7 +147,10 @@ func NewEngineClient() *EngineClient {
 	}
 }
 
-var c *daemon.Connection
+var (
+	engineClient *EngineClient
+	c            *daemon.Connection
+)
 
 func checkErr(err error) {
 	if err != nil {
@@ -151,8 +166,8 @@ func TestMain(m *testing.M) {
 	err = meta.Init()
 	checkErr(err)
 
-	client := NewEngineClient()
-	c, err = daemon.NewConnectionWithClient(client)
+	engineClient = NewEngineClient()
+	c, err = daemon.NewConnectionWithClient(engineClient)
 	checkErr(err)
 	c.Login(context.TODO(), "", "")
 	code := m.Run()
@@ -164,7 +179,9 @@ func TestScheduler(t *testing.T) {
 	checkErr(err)
 	log.Trace().Str("schedulerDir", dir).Send()
 	log.Trace().Any("user", c.User()).Send()
-	s, err := daemon.NewScheduler(context.Background(), c, dir, 5*time.Second)
+	s, err := daemon.NewScheduler(context.Background(), c, dir, &daemon.SchedulerConfig{
+		JobInterval: 5 * time.Second,
+	})
 	checkErr(err)
 	s.Start()
 	time.Sleep(time.Second * 90)
@@ -1,94 +1,53 @@
 package daemon
 
 import (
+	"encoding/json"
 	"encoding/xml"
-	"os/exec"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
-
-	pb "github.com/sath-run/engine/daemon/protobuf"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/mem"
 )
 
-func GetSystemInfo() *pb.SystemInfo {
-	info := pb.SystemInfo{
-		Host:   &pb.HostInfo{},
-		Cpu:    &pb.CpuInfo{},
-		Memory: &pb.MemoryInfo{},
-		Gpu:    &pb.GpuInfo{},
-	}
-	cpus, err := cpu.Info()
-	if err != nil {
-		info.Cpu.Err = err.Error()
-	} else {
-		for _, cpu := range cpus {
-			cpuInfo := &pb.Cpu{
-				Id:        cpu.CPU,
-				CacheSize: cpu.CacheSize,
-				Clock:     uint64(cpu.Mhz * 1e6),
-				ModelName: cpu.ModelName,
-			}
-			info.Cpu.Cpus = append(info.Cpu.Cpus, cpuInfo)
-		}
+// parseClock parses clock of nvidia-smi like "1410 MHz" into Hz, "N/A" is reported as 0
+func parseClock(clock string) (uint64, error) {
+	if clock == "" || clock == "N/A" {
+		return 0, nil
 	}
-
-	hostInfo, err := host.Info()
+	retval, err := strconv.ParseUint(strings.TrimSuffix(clock, " MHz"), 10, 64)
 	if err != nil {
-		info.Host.Err = err.Error()
-	} else {
-		info.Host.PlatformFamily = hostInfo.PlatformFamily
-		info.Host.PlatformVersion = hostInfo.PlatformVersion
-		info.Host.KernelVersion = hostInfo.KernelVersion
-		info.Host.KernelArch = hostInfo.KernelArch
-		info.Host.Os = hostInfo.OS
-		info.Host.Platform = hostInfo.Platform
+		return 0, fmt.Errorf("invalid clock %q", clock)
 	}
+	return retval * 1e6, nil
+}
 
-	memInfo, err := mem.VirtualMemory()
-	if err != nil {
-		info.Memory.Err = err.Error()
-	} else {
-		info.Memory.Total = memInfo.Total
-	}
+// parseMemory parses memory size of nvidia-smi like "24576 MiB" into bytes
+func parseMemory(memory string) uint64 {
+	retval, _ := strconv.ParseUint(strings.TrimSuffix(memory, " MiB"), 10, 64)
+	return retval * 1024 * 1024
+}
 
-	gpuInfo, err := GetNvidiaGPUInfo()
-	if err != nil {
-		info.Gpu.Err = err.Error()
-	} else {
-		info.Gpu.CudaVersion = gpuInfo.CudaVersion
-		info.Gpu.DriverVersion = gpuInfo.DriverVersion
-		for _, gpu := range gpuInfo.Gpus {
-			g := &pb.Gpu{
-				Id:                  gpu.Id,
-				Uuid:                gpu.Uuid,
-				ProductName:         gpu.ProductName,
-				ProductBrand:        gpu.ProductBrand,
-				ProductArchitecture: gpu.ProductArchitecture,
-				VbiosVersion:        gpu.VbiosVersion,
-				GpuPartNumber:       gpu.GpuPartNumber,
-				Clocks:              &pb.GpuClocks{},
-				MaxClocks:           &pb.GpuClocks{},
-			}
-			g.Clocks.Graphics = parseClock(gpu.Clocks.Graphics)
-			g.Clocks.Mem = parseClock(gpu.Clocks.Mem)
-			g.Clocks.Sm = parseClock(gpu.Clocks.Sm)
-			g.Clocks.Video = parseClock(gpu.Clocks.Video)
-			g.MaxClocks.Graphics = parseClock(gpu.MaxClocks.Graphics)
-			g.MaxClocks.Mem = parseClock(gpu.MaxClocks.Mem)
-			g.MaxClocks.Sm = parseClock(gpu.MaxClocks.Sm)
-			g.MaxClocks.Video = parseClock(gpu.MaxClocks.Video)
-			info.Gpu.Gpus = append(info.Gpu.Gpus, g)
-		}
-	}
+// parsePercent parses utilization of nvidia-smi like "45 %"
+func parsePercent(percent string) uint32 {
+	retval, _ := strconv.ParseUint(strings.TrimSuffix(percent, " %"), 10, 32)
+	return uint32(retval)
+}
 
-	return &info
+// parseTemperature parses temperature of nvidia-smi like "45 C" in degrees Celsius
+func parseTemperature(temperature string) uint32 {
+	retval, _ := strconv.ParseUint(strings.TrimSuffix(temperature, " C"), 10, 32)
+	return uint32(retval)
 }
 
-func parseClock(clock string) uint64 {
-	retval, _ := strconv.ParseUint(strings.TrimSuffix(clock, " MHz"), 10, 64)
-	return retval * 1e6
+// parsePower parses power of nvidia-smi like "65.43 W" into milliwatts
+func parsePower(power string) uint64 {
+	retval, err := strconv.ParseFloat(strings.TrimSuffix(power, " W"), 64)
+	if err != nil || retval < 0 {
+		return 0
+	}
+	return uint64(math.Round(retval * 1000))
 }
 
 type GPUInfo struct {
@@ -99,16 +58,71 @@ type GPUInfo struct {
 }
 
 type Gpu struct {
-	Id                  string   `xml:"id,attr"`
-	ProductName         string   `xml:"product_name"`
-	ProductBrand        string   `xml:"product_brand"`
-	ProductArchitecture string   `xml:"product_architecture"`
-	Uuid                string   `xml:"uuid"`
-	VbiosVersion        string   `xml:"vbios_version"`
-	GpuPartNumber       string   `xml:"gpu_part_number"`
-	GraphicsClock       string   `xml:"graphics_clock"`
-	Clocks              GpuClock `xml:"clocks"`
-	MaxClocks           GpuClock `xml:"max_clocks"`
+	Id                  string         `xml:"id,attr"`
+	ProductName         string         `xml:"product_name"`
+	ProductBrand        string         `xml:"product_brand"`
+	ProductArchitecture string         `xml:"product_architecture"`
+	Uuid                string         `xml:"uuid"`
+	MinorNumber         string         `xml:"minor_number"`
+	VbiosVersion        string         `xml:"vbios_version"`
+	GpuPartNumber       string         `xml:"gpu_part_number"`
+	GraphicsClock       string         `xml:"graphics_clock"`
+	Clocks              GpuClock       `xml:"clocks"`
+	MaxClocks           GpuClock       `xml:"max_clocks"`
+	FbMemoryUsage       GpuMemory      `xml:"fb_memory_usage"`
+	Utilization         GpuUtilization `xml:"utilization"`
+	Temperature         GpuTemperature `xml:"temperature"`
+	// drivers before 530 report power in power_readings, later ones in gpu_power_readings
+	PowerReadings    GpuPower `xml:"power_readings"`
+	GpuPowerReadings GpuPower `xml:"gpu_power_readings"`
+}
+
+type GpuUtilization struct {
+	Gpu    string `xml:"gpu_util"`
+	Memory string `xml:"memory_util"`
+}
+
+type GpuTemperature struct {
+	Gpu string `xml:"gpu_temp"`
+}
+
+type GpuPower struct {
+	PowerDraw          string `xml:"power_draw"`
+	AveragePowerDraw   string `xml:"average_power_draw"`
+	InstantPowerDraw   string `xml:"instant_power_draw"`
+	PowerLimit         string `xml:"power_limit"`
+	CurrentPowerLimit  string `xml:"current_power_limit"`
+	EnforcedPowerLimit string `xml:"enforced_power_limit"`
+}
+
+// powerDraw returns power draw of gpu in milliwatts, fields differ among driver versions
+func (gpu *Gpu) powerDraw() uint64 {
+	for _, power := range []GpuPower{gpu.GpuPowerReadings, gpu.PowerReadings} {
+		for _, draw := range []string{power.PowerDraw, power.AveragePowerDraw, power.InstantPowerDraw} {
+			if value := parsePower(draw); value > 0 {
+				return value
+			}
+		}
+	}
+	return 0
+}
+
+// powerLimit returns power limit of gpu in milliwatts
+func (gpu *Gpu) powerLimit() uint64 {
+	for _, power := range []GpuPower{gpu.GpuPowerReadings, gpu.PowerReadings} {
+		for _, limit := range []string{power.CurrentPowerLimit, power.EnforcedPowerLimit, power.PowerLimit} {
+			if value := parsePower(limit); value > 0 {
+				return value
+			}
+		}
+	}
+	return 0
+}
+
+type GpuMemory struct {
+	Total string `xml:"total"`
+	Used  string `xml:"used"`
+	Free  string `xml:"free"`
 }
 
 type GpuClock struct {
@@ -119,13 +133,82 @@ type GpuClock struct {
 }
 
 func GetNvidiaGPUInfo() (*GPUInfo, error) {
-	out, err := exec.Command("nvidia-smi", "-q", "-x").Output()
-	if err != nil {
-		return nil, err
-	}
+	return HostProbe().nvidiaGPUInfo()
+}
+
+// ParseNvidiaSmi parses the xml output of "nvidia-smi -q -x"
+func ParseNvidiaSmi(out []byte) (*GPUInfo, error) {
 	var info GPUInfo
 	if err := xml.Unmarshal(out, &info); err != nil {
 		return nil, err
 	}
 	return &info, nil
 }
+
+type RocmInfo struct {
+	DriverVersion string
+	Gpus          []RocmGpu
+}
+
+type RocmGpu struct {
+	// index of card, N of "cardN"
+	Index       int
+	Uuid        string
+	ProductName string
+	Vendor      string
+	Sku         string
+	GfxVersion  string
+	// memory in bytes
+	VramTotal uint64
+	VramUsed  uint64
+}
+
+func GetRocmGPUInfo() (*RocmInfo, error) {
+	return HostProbe().rocmGPUInfo()
+}
+
+// ParseRocmSmi parses the json output of rocm-smi, keys of fields differ
+// in case among ROCm versions, e.g. "Card series" and "Card Series"
+func ParseRocmSmi(data []byte) (*RocmInfo, error) {
+	var out map[string]map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	info := RocmInfo{}
+	for key, fields := range out {
+		values := map[string]string{}
+		for k, v := range fields {
+			values[strings.ToLower(k)] = fmt.Sprint(v)
+		}
+		if key == "system" {
+			info.DriverVersion = values["driver version"]
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(key, "card"))
+		if err != nil || !strings.HasPrefix(key, "card") {
+			continue
+		}
+		gpu := RocmGpu{
+			Index:       index,
+			Uuid:        values["unique id"],
+			ProductName: values["card series"],
+			Vendor:      values["card vendor"],
+			Sku:         values["card sku"],
+			GfxVersion:  values["gfx version"],
+		}
+		// consumer cards may not have unique id
+		if gpu.Uuid == "" || gpu.Uuid == "N/A" {
+			gpu.Uuid = key
+		}
+		gpu.VramTotal, _ = strconv.ParseUint(values["vram total memory (b)"], 10, 64)
+		gpu.VramUsed, _ = strconv.ParseUint(values["vram total used memory (b)"], 10, 64)
+		info.Gpus = append(info.Gpus, gpu)
+	}
+	if len(info.Gpus) == 0 {
+		return nil, errors.New("no amd gpu found by rocm-smi")
+	}
+	sort.Slice(info.Gpus, func(i, j int) bool {
+		return info.Gpus[i].Index < info.Gpus[j].Index
+	})
+	return &info, nil
+}
@@ -0,0 +1,80 @@
+package daemon_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sath-run/engine/daemon"
+)
+
+func TestParseRocmSmi(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		driver      string
+		uuids       []string
+		productName string
+		vram        uint64
+	}{
+		{
+			fixture:     "rocm5-mi100x2.json",
+			driver:      "5.16.9.22.20",
+			uuids:       []string{"0x9246c2e172b1b57c", "0x4b1f9e40d2a6c385"},
+			productName: "Arcturus GL-XL [Instinct MI100]",
+			vram:        34342961152,
+		},
+		{
+			fixture:     "rocm6-mi210.json",
+			driver:      "6.7.0",
+			uuids:       []string{"0x5f3a9d2c1b8e4f07"},
+			productName: "AMD Instinct MI210",
+			vram:        68702699520,
+		},
+		{
+			// consumer cards report no unique id
+			fixture:     "rocm6-rx7900xtx.json",
+			driver:      "6.8.5",
+			uuids:       []string{"card0"},
+			productName: "Navi 31 [Radeon RX 7900 XT/7900 XTX]",
+			vram:        25753026560,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "rocm-smi", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := daemon.ParseRocmSmi(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.DriverVersion != tt.driver {
+				t.Errorf("driver version: got %q, want %q", info.DriverVersion, tt.driver)
+			}
+			if len(info.Gpus) != len(tt.uuids) {
+				t.Fatalf("got %d gpus, want %d", len(info.Gpus), len(tt.uuids))
+			}
+			for i, gpu := range info.Gpus {
+				if gpu.Index != i {
+					t.Errorf("gpu %d: got index %d", i, gpu.Index)
+				}
+				if gpu.Uuid != tt.uuids[i] {
+					t.Errorf("gpu %d: got uuid %q, want %q", i, gpu.Uuid, tt.uuids[i])
+				}
+				if gpu.ProductName != tt.productName {
+					t.Errorf("gpu %d: got product name %q, want %q", i, gpu.ProductName, tt.productName)
+				}
+				if gpu.VramTotal != tt.vram {
+					t.Errorf("gpu %d: got vram %d, want %d", i, gpu.VramTotal, tt.vram)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRocmSmiNoGpu(t *testing.T) {
+	if _, err := daemon.ParseRocmSmi([]byte(`{"system": {"Driver version": "6.7.0"}}`)); err == nil {
+		t.Error("expected error when no card is reported")
+	}
+}
@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	pb "github.com/sath-run/engine/daemon/protobuf"
+	"google.golang.org/protobuf/proto"
+)
+
+// SystemInfoChanges returns parts of system info which have changed in a way that matters
+// to scheduling. Readings which fluctuate all the time, like clocks, free disk and bandwidth,
+// only count when they change significantly.
+func SystemInfoChanges(old *pb.SystemInfo, new *pb.SystemInfo) []string {
+	if old == nil {
+		return []string{"all"}
+	}
+	a, b := stableSystemInfo(old), stableSystemInfo(new)
+	changes := []string{}
+	for _, part := range []struct {
+		name     string
+		old, new proto.Message
+	}{
+		{"host", a.Host, b.Host},
+		{"cpu", a.Cpu, b.Cpu},
+		{"memory", a.Memory, b.Memory},
+		{"gpu", a.Gpu, b.Gpu},
+		{"disk", a.Disk, b.Disk},
+		{"network", a.Network, b.Network},
+		{"runtime", a.Runtime, b.Runtime},
+		{"cgroup", a.Cgroup, b.Cgroup},
+	} {
+		if !proto.Equal(part.old, part.new) {
+			changes = append(changes, part.name)
+		}
+	}
+
+	if !slices.Contains(changes, "disk") {
+		// free disk matters when it changes by more than 1GB or 5% of disk
+		threshold := max(1024*1024*1024, new.GetDisk().GetTotal()/20)
+		if absDiff(old.GetDisk().GetFree(), new.GetDisk().GetFree()) > threshold {
+			changes = append(changes, "disk")
+		}
+	}
+	if !slices.Contains(changes, "network") {
+		if bandwidthChanged(old.GetNetwork().GetDownloadBandwidth(), new.GetNetwork().GetDownloadBandwidth()) ||
+			bandwidthChanged(old.GetNetwork().GetUploadBandwidth(), new.GetNetwork().GetUploadBandwidth()) {
+			changes = append(changes, "network")
+		}
+	}
+	return changes
+}
+
+// stableSystemInfo clears readings of system info which fluctuate all the time
+func stableSystemInfo(info *pb.SystemInfo) *pb.SystemInfo {
+	info = proto.Clone(info).(*pb.SystemInfo)
+	for _, cpu := range info.GetCpu().GetCpus() {
+		cpu.Clock = 0
+	}
+	for _, gpu := range info.GetGpu().GetGpus() {
+		gpu.Clocks = nil
+	}
+	if info.Disk != nil {
+		info.Disk.Free = 0
+	}
+	if info.Network != nil {
+		info.Network.DownloadBandwidth = 0
+		info.Network.UploadBandwidth = 0
+	}
+	return info
+}
+
+// bandwidthChanged reports whether bandwidth is measured for the first time, or has doubled or halved
+func bandwidthChanged(old uint64, new uint64) bool {
+	if old == 0 {
+		return new != 0
+	}
+	return new > old*2 || new < old/2
+}
+
+func absDiff(a uint64, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// watchSystemInfo probes the system periodically. When capabilities change, e.g. a gpu is
+// plugged in or a driver is upgraded, they are reported to server and gpus of scheduler are updated.
+func (core *Core) watchSystemInfo(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info := GetSystemInfo()
+		changes := SystemInfoChanges(core.c.SystemInfo(), info)
+		if len(changes) == 0 {
+			continue
+		}
+		log.Info().Strs("changes", changes).Msg("system info changed")
+		if slices.Contains(changes, "gpu") {
+			core.scheduler.UpdateGpus(info.Gpu)
+		}
+		// if it fails, changes are reported again next time since last reported info is not updated
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := core.c.UpdateSystemInfo(reqCtx, info, changes); err != nil {
+			log.Warn().Err(err).Msg("fail to update system info")
+		}
+		cancel()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -25,16 +26,101 @@ var grpcAddrArg string
 var sockArg string
 var sslArg bool
 var showVersion bool
+var schedulerConfig daemon.SchedulerConfig
+var sysInfoInterval time.Duration
+var imageBudget float64
+var prefetchBandwidth float64
+var prefetchMinFreeDisk float64
+var lookaheadBandwidth float64
+var lookaheadMinFreeDisk float64
 
 func init() {
 	flag.StringVar(&dataPath, "data", "", "path of data folder")
 	flag.StringVar(&grpcAddrArg, "grpc", "", "grpc address for debug mode")
 	flag.BoolVar(&sslArg, "ssl", true, "grpc comunication whether or not using ssl")
 	flag.BoolVar(&showVersion, "version", false, "show current version and exit")
+	flag.StringVar(&schedulerConfig.LogDir, "log-dir", "", "dir where job logs are kept across restarts, default to logs under sath home")
+	flag.DurationVar(&schedulerConfig.LogRetention, "log-retention", 24*time.Hour, "how long job logs are kept after job completion")
+	flag.DurationVar(&schedulerConfig.ImagePullTimeout, "pull-timeout", 30*time.Minute, "max time of pulling the image of a job")
+	flag.DurationVar(&schedulerConfig.DownloadTimeout, "download-timeout", time.Hour, "max time of downloading resources or inputs of a job")
+	flag.DurationVar(&schedulerConfig.RunTimeout, "run-timeout", 24*time.Hour, "default max running time of a job, if not specified by server")
+	flag.DurationVar(&schedulerConfig.UploadTimeout, "upload-timeout", time.Hour, "max time of uploading outputs of a job")
+	flag.DurationVar(&schedulerConfig.StallTimeout, "stall-timeout", time.Hour, "fail a running job if it produces no output nor progress for this period, 0 to disable")
+	flag.DurationVar(&schedulerConfig.GpuStatsInterval, "gpu-stats-interval", 10*time.Second, "interval of sampling gpu utilization of running jobs")
+	flag.DurationVar(&schedulerConfig.Policy.Interval, "policy-interval", 30*time.Second, "interval of evaluating availability policies")
+	flag.Func("run-windows", "comma separated time windows when jobs may run, e.g. 22:00-07:00, empty to run at any time", func(s string) error {
+		schedulerConfig.Policy.RunWindows = strings.Split(s, ",")
+		return nil
+	})
+	flag.Float64Var(&schedulerConfig.Policy.IdleCpu, "idle-cpu", 0, "only run jobs when other processes use less cpu than this percentage, 0 to disable")
+	flag.DurationVar(&schedulerConfig.Policy.IdleSession, "idle-session", 0, "only run jobs when all user sessions are idle for this period, 0 to disable")
+	flag.BoolVar(&schedulerConfig.Policy.RequireAC, "require-ac", false, "only run jobs on AC power")
+	flag.Float64Var(&schedulerConfig.Policy.MaxCpuTemperature, "max-cpu-temp", 0, "back off when cpu temperature in celsius exceeds this, 0 to disable")
+	flag.Float64Var(&schedulerConfig.Policy.MaxGpuTemperature, "max-gpu-temp", 0, "back off when gpu temperature in celsius exceeds this, 0 to disable")
+	flag.Func("suspend", "comma separated policy rules (run-window, idle, power, temperature) which also suspend running jobs when denying", func(s string) error {
+		schedulerConfig.Policy.Suspend = strings.Split(s, ",")
+		return nil
+	})
+	flag.StringVar(&schedulerConfig.CheckpointDir, "checkpoint-dir", "", "dir where checkpoints of jobs are kept locally, default to checkpoints under sath home")
+	flag.DurationVar(&schedulerConfig.CheckpointInterval, "checkpoint-interval", 30*time.Minute, "default interval of saving checkpoints of jobs, if not specified by server")
+	flag.DurationVar(&schedulerConfig.CheckpointRetention, "checkpoint-retention", 7*24*time.Hour, "how long local checkpoints of unfinished jobs are kept")
+	flag.Float64Var(&imageBudget, "image-budget", 0, "max size in GB of images pulled for jobs, least recently used ones are removed when exceeded, 0 to keep all")
+	flag.DurationVar(&schedulerConfig.ImageGCInterval, "image-gc-interval", time.Hour, "interval of removing images exceeding the budget")
+	flag.StringVar(&schedulerConfig.ImagePull, "image-pull", "24h", "when images of jobs are pulled: always, if-not-present, or an interval of refreshing tags, e.g. 24h; images pinned by digest are never pulled again")
+	flag.Func("allowed-registries", "comma separated registries images of jobs may be pulled from, e.g. ghcr.io, empty to allow all", func(s string) error {
+		schedulerConfig.ImagePolicy.AllowedRegistries = strings.Split(s, ",")
+		return nil
+	})
+	flag.Func("allowed-repos", "comma separated repositories images of jobs may be pulled from, glob patterns like ghcr.io/sath-run/* are supported", func(s string) error {
+		schedulerConfig.ImagePolicy.AllowedRepositories = strings.Split(s, ",")
+		return nil
+	})
+	flag.Func("denied-images", "comma separated repositories or references which are never run, glob patterns are supported", func(s string) error {
+		schedulerConfig.ImagePolicy.Denied = strings.Split(s, ",")
+		return nil
+	})
+	flag.BoolVar(&schedulerConfig.ImagePolicy.RequireDigest, "require-digest", false, "only run images pinned by digest")
+	flag.Func("image-keys", "comma separated paths of public keys, e.g. cosign.pub, images must be signed by any of them with cosign", func(s string) error {
+		schedulerConfig.ImagePolicy.PublicKeys = strings.Split(s, ",")
+		return nil
+	})
+	flag.Func("registry-mirrors", "comma separated mirrors tried before registries, e.g. docker.io=mirror.lab:5000, a repository prefix may also be mirrored, e.g. ghcr.io/sath-run=harbor.lab/sath-run", func(s string) error {
+		schedulerConfig.RegistryMirrors = strings.Split(s, ",")
+		return nil
+	})
+	flag.Func("image-rewrites", "comma separated rules rewriting registries or repository prefixes of images, e.g. docker.io=registry.lab/dockerhub, the original registries are never used", func(s string) error {
+		schedulerConfig.ImageRewrites = strings.Split(s, ",")
+		return nil
+	})
+	flag.IntVar(&schedulerConfig.JobBatchSize, "job-batch-size", 1, "max number of jobs fetched by each request, larger batches suit workloads of many short tasks")
+	flag.DurationVar(&schedulerConfig.JobMaxInterval, "job-max-interval", 10*time.Minute, "max interval of polling jobs when backing off from errors or answers of no job")
+	flag.IntVar(&schedulerConfig.Lookahead, "lookahead", 1, "number of jobs fetched and prepared ahead while others are running, so that they start once containers free up")
+	flag.Float64Var(&lookaheadBandwidth, "lookahead-bandwidth", 0, "max bandwidth in MB/s of downloading files of jobs fetched ahead, 0 for unlimited")
+	flag.Float64Var(&lookaheadMinFreeDisk, "lookahead-min-free-disk", 10, "no job is fetched ahead if free disk space in GB is less than this")
+	flag.BoolVar(&schedulerConfig.PollOnly, "poll-only", false, "only poll jobs, instead of having jobs assigned by stream while the server supports it")
+	flag.BoolVar(&schedulerConfig.Prefetch.Disabled, "no-prefetch", false, "do not prefetch images and resources while idle")
+	flag.DurationVar(&schedulerConfig.Prefetch.Idle, "prefetch-idle", time.Minute, "prefetching starts once no job has run for this period")
+	flag.DurationVar(&schedulerConfig.Prefetch.Interval, "prefetch-interval", 10*time.Minute, "interval of requesting images and resources to prefetch from server")
+	flag.Func("prefetch-images", "comma separated images prefetched while idle, besides those hinted by server", func(s string) error {
+		schedulerConfig.Prefetch.Images = strings.Split(s, ",")
+		return nil
+	})
+	flag.Func("prefetch-resources", "comma separated resources prefetched while idle, in the form of <resource id>:<path>=<url>", func(s string) error {
+		schedulerConfig.Prefetch.Resources = strings.Split(s, ",")
+		return nil
+	})
+	flag.Float64Var(&prefetchBandwidth, "prefetch-bandwidth", 0, "max bandwidth in MB/s of prefetching resources, 0 for unlimited")
+	flag.Float64Var(&prefetchMinFreeDisk, "prefetch-min-free-disk", 10, "min free disk space in GB kept by prefetching")
+	flag.DurationVar(&sysInfoInterval, "sysinfo-interval", 5*time.Minute, "interval of probing system info again to report hardware or driver changes")
 }
 
 func main() {
 	flag.Parse()
+	schedulerConfig.ImageDiskBudget = int64(imageBudget * (1 << 30))
+	schedulerConfig.Prefetch.Bandwidth = int64(prefetchBandwidth * (1 << 20))
+	schedulerConfig.Prefetch.MinFreeDisk = int64(prefetchMinFreeDisk * (1 << 30))
+	schedulerConfig.LookaheadBandwidth = int64(lookaheadBandwidth * (1 << 20))
+	schedulerConfig.LookaheadMinFreeDisk = int64(lookaheadMinFreeDisk * (1 << 30))
 
 	if showVersion {
 		fmt.Println("Sath " + constants.Version)
@@ -75,6 +161,9 @@ func main() {
 		GrpcAddress: grpcAddr,
 		SSL:         ssl,
 		DataDir:     dataPath,
+		Scheduler:   schedulerConfig,
+
+		SystemInfoInterval: sysInfoInterval,
 	})
 	if err != nil {
 		log.Fatal().Err(err).Send()
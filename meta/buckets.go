@@ -6,6 +6,8 @@ var (
 	bucketKeyVersion    = []byte(schemaVersion)
 	bucketKeyDBVersion  = []byte("version") // stores the version of the schema
 	bucketKeyCredential = []byte("credential")
+	bucketKeyImage      = []byte("image")
+	bucketKeyRegistry   = []byte("registry")
 
 	bucketKeyUserToken   = []byte("usertoken")
 	bucketKeyDeviceToken = []byte("devicetoken")
@@ -47,3 +49,19 @@ func credentialBucketPath() [][]byte {
 func getCredentialBucket(tx *bolt.Tx) *bolt.Bucket {
 	return getBucket(tx, credentialBucketPath()...)
 }
+
+func imageBucketPath() [][]byte {
+	return [][]byte{bucketKeyVersion, bucketKeyImage}
+}
+
+func getImageBucket(tx *bolt.Tx) *bolt.Bucket {
+	return getBucket(tx, imageBucketPath()...)
+}
+
+func registryBucketPath() [][]byte {
+	return [][]byte{bucketKeyVersion, bucketKeyCredential, bucketKeyRegistry}
+}
+
+func getRegistryBucket(tx *bolt.Tx) *bolt.Bucket {
+	return getBucket(tx, registryBucketPath()...)
+}
@@ -22,7 +22,7 @@ const (
 	// dbVersion represents updates to the schema
 	// version which are additions and compatible with
 	// prior version of the same schema.
-	dbVersion = 1
+	dbVersion = 3
 )
 
 type DB struct {
@@ -53,6 +53,12 @@ func Init() error {
 		if _, err := createBucketIfNotExists(tx, credentialBucketPath()...); err != nil {
 			return err
 		}
+		if _, err := createBucketIfNotExists(tx, imageBucketPath()...); err != nil {
+			return err
+		}
+		if _, err := createBucketIfNotExists(tx, registryBucketPath()...); err != nil {
+			return err
+		}
 		return nil
 	})
 	return err
@@ -0,0 +1,65 @@
+package meta
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sath-run/engine/constants"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Image is a docker image pulled by engine
+type Image struct {
+	// reference the image is pulled by, e.g. "ubuntu:22.04"
+	Ref string `json:"ref"`
+	// content addressable id of the image
+	Id string `json:"id"`
+	// size in bytes, including layers shared with other images
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+	// last time the reference is pulled from registry, zero if it was present before engine used it
+	PulledAt time.Time `json:"pulledAt"`
+}
+
+func GetImages() ([]Image, error) {
+	images := []Image{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return getImageBucket(tx).ForEach(func(k, v []byte) error {
+			var image Image
+			if err := json.Unmarshal(v, &image); err != nil {
+				return err
+			}
+			images = append(images, image)
+			return nil
+		})
+	})
+	return images, err
+}
+
+func PutImage(image Image) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return getImageBucket(tx).Put([]byte(image.Ref), data)
+	})
+}
+
+func RemoveImage(ref string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return getImageBucket(tx).Delete([]byte(ref))
+	})
+}
+
+func GetImage(ref string) (Image, error) {
+	var image Image
+	err := db.View(func(tx *bolt.Tx) error {
+		v := getImageBucket(tx).Get([]byte(ref))
+		if v == nil {
+			return constants.ErrNil
+		}
+		return json.Unmarshal(v, &image)
+	})
+	return image, err
+}
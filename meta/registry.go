@@ -0,0 +1,61 @@
+package meta
+
+import (
+	"encoding/json"
+
+	"github.com/sath-run/engine/constants"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RegistryCredential is a credential of docker registry used to pull images,
+// for registries whose auth is not provided along with jobs, e.g. local mirrors
+type RegistryCredential struct {
+	// domain of the registry, e.g. "mirror.lab:5000"
+	Registry      string `json:"registry"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identityToken"`
+}
+
+func GetRegistryCredentials() ([]RegistryCredential, error) {
+	credentials := []RegistryCredential{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return getRegistryBucket(tx).ForEach(func(k, v []byte) error {
+			var credential RegistryCredential
+			if err := json.Unmarshal(v, &credential); err != nil {
+				return err
+			}
+			credentials = append(credentials, credential)
+			return nil
+		})
+	})
+	return credentials, err
+}
+
+func GetRegistryCredential(registry string) (RegistryCredential, error) {
+	var credential RegistryCredential
+	err := db.View(func(tx *bolt.Tx) error {
+		v := getRegistryBucket(tx).Get([]byte(registry))
+		if v == nil {
+			return constants.ErrNil
+		}
+		return json.Unmarshal(v, &credential)
+	})
+	return credential, err
+}
+
+func PutRegistryCredential(credential RegistryCredential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return getRegistryBucket(tx).Put([]byte(credential.Registry), data)
+	})
+}
+
+func RemoveRegistryCredential(registry string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return getRegistryBucket(tx).Delete([]byte(registry))
+	})
+}